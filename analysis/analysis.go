@@ -0,0 +1,135 @@
+// Package analysis turns text into the tokens stored in and looked up from the index.
+package analysis
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Analyzer runs text through a chain of character filters, tokenizes it and runs the resulting
+// tokens through a chain of token filters.
+type Analyzer struct {
+	language    string
+	tokenizer   Tokenizer
+	charFilters []CharFilter
+	filters     []TokenFilter
+}
+
+// NewAnalyzer creates an analyzer for the given language. Stop words are always removed,
+// tokens are stemmed if stem is true, and the named token filters are applied last, in order.
+// Named character filters are applied in order before tokenization.
+func NewAnalyzer(language string, stem bool, filters []string) (*Analyzer, error) {
+	names := []string{"stop"}
+	if stem {
+		names = append(names, "stem")
+	}
+
+	a := &Analyzer{language: language, filters: make([]TokenFilter, 0, len(names)+len(filters))}
+	for _, name := range filters {
+		if !isCharFilter(name) {
+			names = append(names, name)
+			continue
+		}
+		filter, err := NewCharFilter(name, language)
+		if err != nil {
+			return nil, err
+		}
+		a.charFilters = append(a.charFilters, filter)
+	}
+	for _, name := range names {
+		filter, err := NewTokenFilter(name, language)
+		if err != nil {
+			return nil, err
+		}
+		if name == "stop" {
+			filter = stopWordFilter{filter}
+		}
+		a.filters = append(a.filters, filter)
+	}
+	return a, nil
+}
+
+// Analyze performs tokenization and token filtering on the given text.
+func (a *Analyzer) Analyze(text string) ([]string, error) {
+	for _, filter := range a.charFilters {
+		text = filter.FilterText(text)
+	}
+	var tokens []string
+	if a.tokenizer != nil {
+		tokens = a.tokenizer.Tokenize(text)
+	} else {
+		tokens = Tokenize(text)
+	}
+
+	var err error
+	for _, filter := range a.filters {
+		tokens, err = filter.Filter(tokens)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+// SurfaceForms returns, for each token of text, the words of text it was produced from, in order of
+// first appearance. The tokens of each word are run through the token filters on their own, so
+// filters that depend on the tokens around them can produce tokens missing from the result. Words
+// keep the case they have in text, after character filters.
+func (a *Analyzer) SurfaceForms(text string) (map[string][]string, error) {
+	for _, filter := range a.charFilters {
+		text = filter.FilterText(text)
+	}
+	forms := make(map[string][]string)
+	for _, chunk := range strings.Fields(text) {
+		lower := strings.ToLower(chunk)
+		var raw []string
+		if a.tokenizer != nil {
+			raw = a.tokenizer.Tokenize(chunk)
+		} else {
+			raw = Tokenize(chunk)
+		}
+		for _, token := range raw {
+			form := token
+			// the token is usually a lowercased span of the chunk, unless lowercasing changed lengths
+			if i := strings.Index(lower, token); i >= 0 && len(lower) == len(chunk) {
+				form = chunk[i : i+len(token)]
+			}
+			tokens := []string{token}
+			var err error
+			for _, filter := range a.filters {
+				if tokens, err = filter.Filter(tokens); err != nil {
+					return nil, err
+				}
+			}
+			for _, token := range tokens {
+				if !slices.Contains(forms[token], form) {
+					forms[token] = append(forms[token], form)
+				}
+			}
+		}
+	}
+	return forms, nil
+}
+
+// Clone returns a copy of the analyzer whose filters can be changed without changing those of a.
+func (a *Analyzer) Clone() *Analyzer {
+	clone := *a
+	clone.charFilters = slices.Clone(a.charFilters)
+	clone.filters = slices.Clone(a.filters)
+	return &clone
+}
+
+// Language returns the language the analyzer was created for.
+func (a *Analyzer) Language() string {
+	return a.language
+}
+
+// Tokenize lowercases the text and splits it on anything that is not a letter, number or mark.
+func Tokenize(text string) []string {
+	text = strings.ToLower(text)
+	tokens := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+	})
+	return tokens
+}
@@ -0,0 +1,24 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSurfaceForms(t *testing.T) {
+	analyzer, err := NewAnalyzer("english", true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	forms, err := analyzer.SurfaceForms("Running is what the runner runs, and RUNS again.")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string][]string{
+		"run":    {"Running", "runs", "RUNS"},
+		"runner": {"runner"},
+	}
+	if !reflect.DeepEqual(forms, expected) {
+		t.Errorf("surface forms %v different from expected %v", forms, expected)
+	}
+}
@@ -0,0 +1,62 @@
+package analysis
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CodeTokenizer splits source code into identifiers, and identifiers written in camelCase or
+// snake_case into the words they are made of. Both the identifier and its words are emitted,
+// so parseHTTPRequest produces parsehttprequest, parse, http and request.
+type CodeTokenizer struct {
+	PreserveCase bool // keep the case of identifiers and words instead of lowercasing them
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r)
+}
+
+func (t CodeTokenizer) Tokenize(text string) []string {
+	tokens := make([]string, 0)
+	for _, identifier := range strings.FieldsFunc(text, func(r rune) bool { return !isIdentifierRune(r) }) {
+		words := splitIdentifier(identifier)
+		if len(words) == 0 {
+			continue
+		}
+		if len(words) > 1 || words[0] != identifier {
+			words = append([]string{identifier}, words...)
+		}
+		for _, word := range words {
+			if !t.PreserveCase {
+				word = strings.ToLower(word)
+			}
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// splitIdentifier splits an identifier on underscores and case changes. A run of upper case
+// letters is an acronym, and its last letter starts a new word if a lower case letter follows:
+// HTTPRequest is split into HTTP and Request.
+func splitIdentifier(identifier string) []string {
+	words := make([]string, 0)
+	for _, part := range strings.Split(identifier, "_") {
+		runes := []rune(part)
+		var start int
+		for i := 1; i < len(runes); i++ {
+			prev, cur := runes[i-1], runes[i]
+			lowerToUpper := !unicode.IsUpper(prev) && unicode.IsUpper(cur)
+			acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(cur) &&
+				i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if lowerToUpper || acronymEnd {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+		if start < len(runes) {
+			words = append(words, string(runes[start:]))
+		}
+	}
+	return words
+}
@@ -0,0 +1,25 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCodeTokenizer(t *testing.T) {
+	inputs := []struct {
+		tokenizer CodeTokenizer
+		text      string
+		tokens    []string
+	}{
+		{CodeTokenizer{}, "parseHTTPRequest", []string{"parsehttprequest", "parse", "http", "request"}},
+		{CodeTokenizer{}, "parse_http_request()", []string{"parse_http_request", "parse", "http", "request"}},
+		{CodeTokenizer{}, "x := sha256Sum(buf)", []string{"x", "sha256sum", "sha256", "sum", "buf"}},
+		{CodeTokenizer{}, "__init__ URL", []string{"__init__", "init", "url"}},
+		{CodeTokenizer{PreserveCase: true}, "getID", []string{"getID", "get", "ID"}},
+	}
+	for _, input := range inputs {
+		if tokens := input.tokenizer.Tokenize(input.text); !slices.Equal(tokens, input.tokens) {
+			t.Errorf("tokens %v different from expected %v for %s", tokens, input.tokens, input.text)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package analysis
+
+import (
+	_ "embed"
+	"strings"
+)
+
+const (
+	minCompoundLength = 6 // shorter tokens are never split
+	minSubwordLength  = 3
+)
+
+//go:embed german_words.txt
+var germanWordList string
+
+// germanLinkingElements are the letters German can insert between the parts of a compound,
+// as in "Arbeitsamt" (arbeit + s + amt).
+var germanLinkingElements = []string{"s", "es", "n", "en", "e", "er"}
+
+var germanDecompounder = NewDecompounder(parseWordList(germanWordList), germanLinkingElements)
+
+// parseWordList returns the non-empty lines of a word list that are not # comments.
+func parseWordList(list string) []string {
+	words := make([]string, 0)
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words
+}
+
+// Decompounder is a token filter that splits compound words into the dictionary words they are made of.
+// Compounds are kept, and their parts are added right after them.
+type Decompounder struct {
+	words   map[string]struct{}
+	linking []string
+}
+
+// NewDecompounder creates a decompounder from a dictionary of lowercase words. Parts of a compound may
+// be joined by any of the linking elements.
+func NewDecompounder(words []string, linking []string) *Decompounder {
+	d := &Decompounder{words: make(map[string]struct{}, len(words)), linking: linking}
+	for _, word := range words {
+		d.words[word] = struct{}{}
+	}
+	return d
+}
+
+func (d *Decompounder) Filter(tokens []string) ([]string, error) {
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, token)
+		result = append(result, d.split(token)...)
+	}
+	return result, nil
+}
+
+// split returns the decomposition of word into the fewest dictionary words, preferring longer
+// leading parts. It returns nil unless the word is made of at least two dictionary words.
+func (d *Decompounder) split(word string) []string {
+	if len(word) < minCompoundLength {
+		return nil
+	}
+
+	// parts[i] is the number of parts of the best decomposition of word[i:], 0 if there is none,
+	// and end[i] and next[i] are where its first part ends and where the second part starts
+	n := len(word)
+	parts := make([]int, n+1)
+	end := make([]int, n+1)
+	next := make([]int, n+1)
+	for i := n - minSubwordLength; i >= 0; i-- {
+		for j := n; j >= i+minSubwordLength; j-- {
+			if _, ok := d.words[word[i:j]]; !ok {
+				continue
+			}
+			if j == n {
+				if parts[i] == 0 || parts[i] > 1 {
+					parts[i], end[i], next[i] = 1, j, j
+				}
+				continue
+			}
+			for _, k := range d.nextParts(word, j) {
+				if parts[k] > 0 && (parts[i] == 0 || parts[k]+1 < parts[i]) {
+					parts[i], end[i], next[i] = parts[k]+1, j, k
+				}
+			}
+		}
+	}
+
+	if parts[0] < 2 {
+		return nil
+	}
+	result := make([]string, 0, parts[0])
+	for i := 0; i < n; i = next[i] {
+		result = append(result, word[i:end[i]])
+	}
+	return result
+}
+
+// nextParts returns the positions where the part after one ending at j can start.
+func (d *Decompounder) nextParts(word string, j int) []int {
+	positions := []int{j}
+	for _, l := range d.linking {
+		if strings.HasPrefix(word[j:], l) {
+			positions = append(positions, j+len(l))
+		}
+	}
+	return positions
+}
@@ -0,0 +1,32 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDecompounder(t *testing.T) {
+	inputs := []analyzeTest{
+		{"Donaudampfschiff", "german", []string{"donaudampfschiff", "donau", "dampf", "schiff"}},
+		{"Arbeitsamt", "german", []string{"arbeitsamt", "arbeit", "amt"}},
+		{"Krankenhaus und Haustür", "german", []string{"krankenhaus", "haustür", "haus", "tür"}},
+		{"Donaublau", "german", []string{"donaublau"}},
+	}
+	for _, input := range inputs {
+		analyzer, err := NewAnalyzer(input.language, false, []string{"decompound"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tokens, err := analyzer.Analyze(input.text)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !slices.Equal(tokens, input.tokens) {
+			t.Errorf("tokens %v different from expected %v for %s", tokens, input.tokens, input.text)
+		}
+	}
+
+	if _, err := NewAnalyzer("english", false, []string{"decompound"}); err == nil {
+		t.Errorf("expected an error for a language without a dictionary")
+	}
+}
@@ -0,0 +1,45 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// elidedArticles are the articles, pronouns and conjunctions that lose their final vowel before
+// a word starting with a vowel, joining it with an apostrophe.
+var elidedArticles = map[string][]string{
+	"french": {"l", "m", "t", "qu", "n", "s", "j", "d", "c", "jusqu", "quoiqu", "lorsqu", "puisqu"},
+	"italian": {
+		"c", "l", "all", "dall", "dell", "nell", "sull", "coll", "pell", "gl", "agl", "dagl", "degl",
+		"negl", "sugl", "un", "m", "t", "s", "v", "d", "quell", "quest", "bell",
+	},
+}
+
+// NewElisionFilter creates a character filter that removes the given elided articles together
+// with their apostrophe, so l'avion becomes avion. Articles are matched case-insensitively and
+// only at the start of a word.
+func NewElisionFilter(articles []string) CharFilter {
+	sorted := make([]string, len(articles))
+	for i, article := range articles {
+		sorted[i] = regexp.QuoteMeta(article)
+	}
+	// the longest articles must be tried first, e.g. dell before d
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	pattern := regexp.MustCompile(
+		`(?i)(^|[^\pL\pN\pM])(?:` + strings.Join(sorted, "|") + `)['’]([\pL\pN])`,
+	)
+	return CharFilterFunc(func(text string) string {
+		return pattern.ReplaceAllString(text, "${1}${2}")
+	})
+}
+
+func newLanguageElisionFilter(language string) (CharFilter, error) {
+	articles, ok := elidedArticles[language]
+	if !ok {
+		return nil, fmt.Errorf("elision filter is only available for french and italian")
+	}
+	return NewElisionFilter(articles), nil
+}
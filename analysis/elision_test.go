@@ -0,0 +1,35 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestElisionFilter(t *testing.T) {
+	inputs := []analyzeTest{
+		{"L'avion d'Air France", "french", []string{"avion", "air", "france"}},
+		{"D’accord, jusqu'ici", "french", []string{"accord", "ici"}},
+		{"aujourd'hui", "french", []string{"aujourd", "hui"}},
+		{"Dell'amica e l'uomo", "italian", []string{"amica", "uomo"}},
+	}
+	for _, input := range inputs {
+		analyzer, err := NewAnalyzer(input.language, false, []string{"elision"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tokens, err := analyzer.Analyze(input.text)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !slices.Equal(tokens, input.tokens) {
+			t.Errorf("tokens %v different from expected %v for %s", tokens, input.tokens, input.text)
+		}
+	}
+
+	if !slices.Contains(CharFilters(), "elision") {
+		t.Errorf("elision missing from character filters %v", CharFilters())
+	}
+	if _, err := NewAnalyzer("english", false, []string{"elision"}); err == nil {
+		t.Errorf("expected an error for a language without elision")
+	}
+}
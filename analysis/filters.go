@@ -0,0 +1,27 @@
+package analysis
+
+import "fmt"
+
+func init() {
+	RegisterTokenFilter("stop", func(language string) (TokenFilter, error) {
+		return TokenFilterFunc(func(tokens []string) ([]string, error) {
+			return filterStopWords(tokens, language), nil
+		}), nil
+	})
+	RegisterTokenFilter("stem", func(language string) (TokenFilter, error) {
+		return TokenFilterFunc(func(tokens []string) ([]string, error) {
+			return stemTokens(tokens, language)
+		}), nil
+	})
+	RegisterTokenFilter("decompound", func(language string) (TokenFilter, error) {
+		if language != "german" {
+			return nil, fmt.Errorf("decompound filter is only available for german")
+		}
+		return germanDecompounder, nil
+	})
+	RegisterCharFilter("elision", newLanguageElisionFilter)
+	RegisterCharFilter("possessive", newPossessiveFilter)
+	RegisterCharFilter("html_strip", func(language string) (CharFilter, error) {
+		return CharFilterFunc(StripHTML), nil
+	})
+}
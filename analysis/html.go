@@ -0,0 +1,42 @@
+package analysis
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// StripHTML removes HTML and XML markup from text, keeping the text content with entities decoded.
+// Tags are replaced with a space so that words in adjacent elements are not joined, and the
+// contents of script and style elements are dropped.
+func StripHTML(text string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(text))
+	var skip atom.Atom
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// the reader never fails, so this is the end of the text
+			return b.String()
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(tokenizer.Text())
+			}
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if a := atom.Lookup(name); a == atom.Script || a == atom.Style {
+				skip = a
+			}
+			b.WriteByte(' ')
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if atom.Lookup(name) == skip {
+				skip = 0
+			}
+			b.WriteByte(' ')
+		case html.SelfClosingTagToken:
+			b.WriteByte(' ')
+		}
+	}
+}
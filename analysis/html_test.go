@@ -0,0 +1,33 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStripHTML(t *testing.T) {
+	inputs := map[string]string{
+		"<p>Fish &amp; chips</p><p>served</p>":                  " Fish & chips  served ",
+		"<b>bold</b>er<br/>text":                                " bold er text",
+		"<script>var x = '<p>';</script><style>p{}</style>ok":   "    ok",
+		"<?xml version=\"1.0\"?><title>XML &lt;doc&gt;</title>": " XML <doc> ",
+		"plain text, 3 < 4":                                     "plain text, 3 < 4",
+	}
+	for text, expected := range inputs {
+		if stripped := StripHTML(text); stripped != expected {
+			t.Errorf("stripped %q different from expected %q for %q", stripped, expected, text)
+		}
+	}
+
+	analyzer, err := NewAnalyzer("english", false, []string{"html_strip"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tokens, err := analyzer.Analyze(`<div class="menu">Caf&eacute; <a href="/x">menus</a></div>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(tokens, []string{"café", "menus"}) {
+		t.Errorf("wrong tokens %v", tokens)
+	}
+}
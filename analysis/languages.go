@@ -0,0 +1,176 @@
+package analysis
+
+import (
+	snowballstem "github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/dutch"
+	"github.com/blevesearch/snowballstem/german"
+	"github.com/blevesearch/snowballstem/italian"
+	"github.com/blevesearch/snowballstem/portuguese"
+	"github.com/kljensen/snowball"
+	"github.com/kljensen/snowball/english"
+	"github.com/kljensen/snowball/french"
+	"github.com/kljensen/snowball/hungarian"
+	"github.com/kljensen/snowball/norwegian"
+	"github.com/kljensen/snowball/russian"
+	"github.com/kljensen/snowball/spanish"
+	"github.com/kljensen/snowball/swedish"
+)
+
+var stopWordFuncs = map[string]func(string) bool{
+	"dutch":      isDutchStopWord,
+	"english":    english.IsStopWord,
+	"french":     french.IsStopWord,
+	"german":     isGermanStopWord,
+	"hungarian":  hungarian.IsStopWord,
+	"italian":    isItalianStopWord,
+	"norwegian":  norwegian.IsStopWord,
+	"portuguese": isPortugueseStopWord,
+	"russian":    russian.IsStopWord,
+	"spanish":    spanish.IsStopWord,
+	"swedish":    swedish.IsStopWord,
+}
+
+func filterStopWords(tokens []string, language string) []string {
+	isStopWord, ok := stopWordFuncs[language]
+	if !ok {
+		return tokens
+	}
+
+	var result []string
+	for _, token := range tokens {
+		if !isStopWord(token) {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+func stemTokens(tokens []string, language string) ([]string, error) {
+	if stem, ok := extraStemmers[language]; ok {
+		for i, token := range tokens {
+			tokens[i] = stemWord(token, stem)
+		}
+		return tokens, nil
+	}
+
+	for i, token := range tokens {
+		stemmed, err := snowball.Stem(token, language, false)
+		if err != nil {
+			return nil, err
+		}
+		tokens[i] = stemmed
+	}
+	return tokens, nil
+}
+
+// extraStemmers are the snowball algorithms for languages not covered by the kljensen/snowball package.
+var extraStemmers = map[string]func(*snowballstem.Env) bool{
+	"dutch":      dutch.Stem,
+	"german":     german.Stem,
+	"italian":    italian.Stem,
+	"portuguese": portuguese.Stem,
+}
+
+func stemWord(word string, stem func(*snowballstem.Env) bool) string {
+	env := snowballstem.NewEnv(word)
+	stem(env)
+	return env.Current()
+}
+
+func stopWordSet(words ...string) func(string) bool {
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		set[word] = struct{}{}
+	}
+	return func(word string) bool {
+		_, ok := set[word]
+		return ok
+	}
+}
+
+// Stop word lists below are taken from the snowball project.
+
+var isDutchStopWord = stopWordSet(
+	"de", "en", "van", "ik", "te", "dat", "die", "in", "een", "hij", "het", "niet", "zijn", "is", "was",
+	"op", "aan", "met", "als", "voor", "had", "er", "maar", "om", "hem", "dan", "zou", "of", "wat", "mijn",
+	"men", "dit", "zo", "door", "over", "ze", "zich", "bij", "ook", "tot", "je", "mij", "uit", "der", "daar",
+	"haar", "naar", "heb", "hoe", "heeft", "hebben", "deze", "u", "want", "nog", "zal", "me", "zij", "nu",
+	"ge", "geen", "omdat", "iets", "worden", "toch", "al", "waren", "veel", "meer", "doen", "toen", "moet",
+	"ben", "zonder", "kan", "hun", "dus", "alles", "onder", "ja", "eens", "hier", "wie", "werd", "altijd",
+	"doch", "wordt", "wezen", "kunnen", "ons", "zelf", "tegen", "na", "reeds", "wil", "kon", "niets", "uw",
+	"iemand", "geweest", "andere",
+)
+
+var isGermanStopWord = stopWordSet(
+	"aber", "alle", "allem", "allen", "aller", "alles", "als", "also", "am", "an", "ander", "andere",
+	"anderem", "anderen", "anderer", "anderes", "anderm", "andern", "anderr", "anders", "auch", "auf",
+	"aus", "bei", "bin", "bis", "bist", "da", "damit", "dann", "der", "den", "des", "dem", "die", "das",
+	"dass", "daß", "derselbe", "derselben", "denselben", "desselben", "demselben", "dieselbe", "dieselben",
+	"dasselbe", "dazu", "dein", "deine", "deinem", "deinen", "deiner", "deines", "denn", "derer", "dessen",
+	"dich", "dir", "du", "dies", "diese", "diesem", "diesen", "dieser", "dieses", "doch", "dort", "durch",
+	"ein", "eine", "einem", "einen", "einer", "eines", "einig", "einige", "einigem", "einigen", "einiger",
+	"einiges", "einmal", "er", "ihn", "ihm", "es", "etwas", "euer", "eure", "eurem", "euren", "eurer",
+	"eures", "für", "gegen", "gewesen", "hab", "habe", "haben", "hat", "hatte", "hatten", "hier", "hin",
+	"hinter", "ich", "mich", "mir", "ihr", "ihre", "ihrem", "ihren", "ihrer", "ihres", "euch", "im", "in",
+	"indem", "ins", "ist", "jede", "jedem", "jeden", "jeder", "jedes", "jene", "jenem", "jenen", "jener",
+	"jenes", "jetzt", "kann", "kein", "keine", "keinem", "keinen", "keiner", "keines", "können", "könnte",
+	"machen", "man", "manche", "manchem", "manchen", "mancher", "manches", "mein", "meine", "meinem",
+	"meinen", "meiner", "meines", "mit", "muss", "musste", "nach", "nicht", "nichts", "noch", "nun", "nur",
+	"ob", "oder", "ohne", "sehr", "sein", "seine", "seinem", "seinen", "seiner", "seines", "selbst", "sich",
+	"sie", "ihnen", "sind", "so", "solche", "solchem", "solchen", "solcher", "solches", "soll", "sollte",
+	"sondern", "sonst", "über", "um", "und", "uns", "unse", "unsem", "unsen", "unser", "unses", "unter",
+	"viel", "vom", "von", "vor", "während", "war", "waren", "warst", "was", "weg", "weil", "weiter",
+	"welche", "welchem", "welchen", "welcher", "welches", "wenn", "werde", "werden", "wie", "wieder",
+	"will", "wir", "wird", "wirst", "wo", "wollen", "wollte", "würde", "würden", "zu", "zum", "zur", "zwar",
+	"zwischen",
+)
+
+var isItalianStopWord = stopWordSet(
+	"ad", "al", "allo", "ai", "agli", "all", "agl", "alla", "alle", "con", "col", "coi", "da", "dal",
+	"dallo", "dai", "dagli", "dall", "dagl", "dalla", "dalle", "di", "del", "dello", "dei", "degli", "dell",
+	"degl", "della", "delle", "in", "nel", "nello", "nei", "negli", "nell", "negl", "nella", "nelle", "su",
+	"sul", "sullo", "sui", "sugli", "sull", "sugl", "sulla", "sulle", "per", "tra", "contro", "io", "tu",
+	"lui", "lei", "noi", "voi", "loro", "mio", "mia", "miei", "mie", "tuo", "tua", "tuoi", "tue", "suo",
+	"sua", "suoi", "sue", "nostro", "nostra", "nostri", "nostre", "vostro", "vostra", "vostri", "vostre",
+	"mi", "ti", "ci", "vi", "lo", "la", "li", "le", "gli", "ne", "il", "un", "uno", "una", "ma", "ed", "se",
+	"perché", "anche", "come", "dov", "dove", "che", "chi", "cui", "non", "più", "quale", "quanto",
+	"quanti", "quanta", "quante", "quello", "quelli", "quella", "quelle", "questo", "questi", "questa",
+	"queste", "si", "tutto", "tutti", "a", "c", "e", "i", "l", "o", "ho", "hai", "ha", "abbiamo", "avete",
+	"hanno", "abbia", "abbiate", "abbiano", "avrò", "avrai", "avrà", "avremo", "avrete", "avranno",
+	"avrei", "avresti", "avrebbe", "avremmo", "avreste", "avrebbero", "avevo", "avevi", "aveva",
+	"avevamo", "avevate", "avevano", "ebbi", "avesti", "ebbe", "avemmo", "aveste", "ebbero", "avessi",
+	"avesse", "avessimo", "avessero", "avendo", "avuto", "avuta", "avuti", "avute", "sono", "sei", "è",
+	"siamo", "siete", "sia", "siate", "siano", "sarò", "sarai", "sarà", "saremo", "sarete", "saranno",
+	"sarei", "saresti", "sarebbe", "saremmo", "sareste", "sarebbero", "ero", "eri", "era", "eravamo",
+	"eravate", "erano", "fui", "fosti", "fu", "fummo", "foste", "furono", "fossi", "fosse", "fossimo",
+	"fossero", "essendo", "faccio", "fai", "facciamo", "fanno", "faccia", "facciate", "facciano", "farò",
+	"farai", "farà", "faremo", "farete", "faranno", "farei", "faresti", "farebbe", "faremmo", "fareste",
+	"farebbero", "facevo", "facevi", "faceva", "facevamo", "facevate", "facevano", "feci", "facesti",
+	"fece", "facemmo", "faceste", "fecero", "facessi", "facesse", "facessimo", "facessero", "facendo",
+	"sto", "stai", "sta", "stiamo", "stanno", "stia", "stiate", "stiano", "starò", "starai", "starà",
+	"staremo", "starete", "staranno", "starei", "staresti", "starebbe", "staremmo", "stareste",
+	"starebbero", "stavo", "stavi", "stava", "stavamo", "stavate", "stavano", "stetti", "stesti", "stette",
+	"stemmo", "steste", "stettero", "stessi", "stesse", "stessimo", "stessero", "stando",
+)
+
+var isPortugueseStopWord = stopWordSet(
+	"de", "a", "o", "que", "e", "do", "da", "em", "um", "para", "com", "não", "uma", "os", "no", "se", "na",
+	"por", "mais", "as", "dos", "como", "mas", "ao", "ele", "das", "à", "seu", "sua", "ou", "quando",
+	"muito", "nos", "já", "eu", "também", "só", "pelo", "pela", "até", "isso", "ela", "entre", "depois",
+	"sem", "mesmo", "aos", "seus", "quem", "nas", "me", "esse", "eles", "você", "essa", "num", "nem",
+	"suas", "meu", "às", "minha", "numa", "pelos", "elas", "qual", "nós", "lhe", "deles", "essas",
+	"esses", "pelas", "este", "dele", "tu", "te", "vocês", "vos", "lhes", "meus", "minhas", "teu", "tua",
+	"teus", "tuas", "nosso", "nossa", "nossos", "nossas", "dela", "delas", "esta", "estes", "estas",
+	"aquele", "aquela", "aqueles", "aquelas", "isto", "aquilo", "estou", "está", "estamos", "estão",
+	"estive", "esteve", "estivemos", "estiveram", "estava", "estávamos", "estavam", "estivera",
+	"estivéramos", "esteja", "estejamos", "estejam", "estivesse", "estivéssemos", "estivessem",
+	"estiver", "estivermos", "estiverem", "hei", "há", "havemos", "hão", "houve", "houvemos",
+	"houveram", "houvera", "houvéramos", "haja", "hajamos", "hajam", "houvesse", "houvéssemos",
+	"houvessem", "houver", "houvermos", "houverem", "houverei", "houverá", "houveremos", "houverão",
+	"houveria", "houveríamos", "houveriam", "sou", "somos", "são", "era", "éramos", "eram", "fui", "foi",
+	"fomos", "foram", "fora", "fôramos", "seja", "sejamos", "sejam", "fosse", "fôssemos", "fossem", "for",
+	"formos", "forem", "serei", "será", "seremos", "serão", "seria", "seríamos", "seriam", "tenho", "tem",
+	"temos", "tém", "tinha", "tínhamos", "tinham", "tive", "teve", "tivemos", "tiveram", "tivera",
+	"tivéramos", "tenha", "tenhamos", "tenham", "tivesse", "tivéssemos", "tivessem", "tiver",
+	"tivermos", "tiverem", "terei", "terá", "teremos", "terão", "teria", "teríamos", "teriam",
+)
@@ -0,0 +1,34 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+type analyzeTest struct {
+	text     string
+	language string
+	tokens   []string
+}
+
+func TestAnalyzeExtraLanguages(t *testing.T) {
+	inputs := []analyzeTest{
+		{"Die Katzen spielten mit den Häusern", "german", []string{"katz", "spielt", "haus"}},
+		{"De katten liepen naar de huizen", "dutch", []string{"kat", "liep", "huiz"}},
+		{"I gatti giocavano nelle case", "italian", []string{"gatt", "gioc", "cas"}},
+		{"Os gatos brincavam nas casas", "portuguese", []string{"gat", "brinc", "cas"}},
+	}
+	for _, input := range inputs {
+		analyzer, err := NewAnalyzer(input.language, true, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tokens, err := analyzer.Analyze(input.text)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !slices.Equal(tokens, input.tokens) {
+			t.Errorf("tokens %v different from expected %v for %s", tokens, input.tokens, input.language)
+		}
+	}
+}
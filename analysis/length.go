@@ -0,0 +1,36 @@
+package analysis
+
+import "unicode/utf8"
+
+// LengthFilter drops tokens shorter than Min characters. Tokens longer than Max characters are
+// truncated to their first Max characters if Truncate is set, and dropped otherwise. A zero Min
+// or Max disables that bound.
+type LengthFilter struct {
+	Min      int
+	Max      int
+	Truncate bool
+}
+
+func (f LengthFilter) Filter(tokens []string) ([]string, error) {
+	filtered := tokens[:0]
+	for _, token := range tokens {
+		length := utf8.RuneCountInString(token)
+		if length < f.Min {
+			continue
+		}
+		if f.Max > 0 && length > f.Max {
+			if !f.Truncate {
+				continue
+			}
+			token = string([]rune(token)[:f.Max])
+		}
+		filtered = append(filtered, token)
+	}
+	return filtered, nil
+}
+
+// LimitTokenLength makes the analyzer apply the length filter right after tokenization, before
+// any other token filter.
+func (a *Analyzer) LimitTokenLength(filter LengthFilter) {
+	a.filters = append([]TokenFilter{filter}, a.filters...)
+}
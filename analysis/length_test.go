@@ -0,0 +1,41 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLengthFilter(t *testing.T) {
+	inputs := []struct {
+		filter LengthFilter
+		tokens []string
+	}{
+		{LengthFilter{}, []string{"a", "ab", "abcdef", "ça"}},
+		{LengthFilter{Min: 2}, []string{"ab", "abcdef", "ça"}},
+		{LengthFilter{Max: 4}, []string{"a", "ab", "ça"}},
+		{LengthFilter{Min: 2, Max: 4, Truncate: true}, []string{"ab", "abcd", "ça"}},
+	}
+	for _, input := range inputs {
+		tokens, err := input.filter.Filter([]string{"a", "ab", "abcdef", "ça"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !slices.Equal(tokens, input.tokens) {
+			t.Errorf("tokens %v different from expected %v for %+v", tokens, input.tokens, input.filter)
+		}
+	}
+
+	analyzer, err := NewAnalyzer("english", true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	analyzer.LimitTokenLength(LengthFilter{Max: 8, Truncate: true})
+	tokens, err := analyzer.Analyze("x internationalization")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// truncation happens before stemming
+	if !slices.Equal(tokens, []string{"x", "internat"}) {
+		t.Errorf("wrong tokens %v", tokens)
+	}
+}
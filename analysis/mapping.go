@@ -0,0 +1,33 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+)
+
+// NewMappingFilter creates a character filter that replaces every occurrence of a key of mappings
+// with its value. Where several keys match at the same position, the longest one is replaced.
+func NewMappingFilter(mappings map[string]string) CharFilter {
+	keys := make([]string, 0, len(mappings))
+	for key := range mappings {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	pairs := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key, mappings[key])
+	}
+	return CharFilterFunc(strings.NewReplacer(pairs...).Replace)
+}
+
+// AddCharFilter appends a character filter to the analyzer, to be applied after the character
+// filters it was created with.
+func (a *Analyzer) AddCharFilter(filter CharFilter) {
+	a.charFilters = append(a.charFilters, filter)
+}
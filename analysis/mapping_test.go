@@ -0,0 +1,33 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMappingFilter(t *testing.T) {
+	filter := NewMappingFilter(map[string]string{"&": " and ", "ß": "ss", "“": `"`, "”": `"`, "c++": "cpp", "C": "k"})
+	inputs := map[string]string{
+		"Straße & “Brücke”": `Strasse  and  "Brücke"`,
+		"c++ and C":         "cpp and k",
+	}
+	for text, expected := range inputs {
+		if mapped := filter.FilterText(text); mapped != expected {
+			t.Errorf("mapped %q different from expected %q for %q", mapped, expected, text)
+		}
+	}
+
+	analyzer, err := NewAnalyzer("english", false, []string{"html_strip"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	analyzer.AddCharFilter(NewMappingFilter(map[string]string{"&": "n"}))
+	tokens, err := analyzer.Analyze("<b>R&amp;D</b>")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// entities are decoded before mapping
+	if !slices.Equal(tokens, []string{"rnd"}) {
+		t.Errorf("wrong tokens %v", tokens)
+	}
+}
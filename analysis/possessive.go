@@ -0,0 +1,23 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var possessivePattern = regexp.MustCompile(`([\pL\pN\pM])'[sS]([^\pL\pN\pM]|$)`)
+
+// stripPossessives normalizes curly apostrophes and removes the possessive 's from the end of words,
+// so company's becomes company without stemming.
+func stripPossessives(text string) string {
+	text = strings.ReplaceAll(text, "’", "'")
+	return possessivePattern.ReplaceAllString(text, "${1}${2}")
+}
+
+func newPossessiveFilter(language string) (CharFilter, error) {
+	if language != "english" {
+		return nil, fmt.Errorf("possessive filter is only available for english")
+	}
+	return CharFilterFunc(stripPossessives), nil
+}
@@ -0,0 +1,33 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPossessiveFilter(t *testing.T) {
+	inputs := map[string][]string{
+		"The company's results":     {"company", "results"},
+		"Charles’s CAT'S toy":       {"charles", "cat", "toy"},
+		"it's a company's":          {"company"},
+		"the students' essays":      {"students", "essays"},
+		"'s alone and o'sullivan's": {"alone", "o", "sullivan"},
+	}
+	for text, expected := range inputs {
+		analyzer, err := NewAnalyzer("english", false, []string{"possessive"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tokens, err := analyzer.Analyze(text)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !slices.Equal(tokens, expected) {
+			t.Errorf("tokens %v different from expected %v for %s", tokens, expected, text)
+		}
+	}
+
+	if _, err := NewAnalyzer("french", false, []string{"possessive"}); err == nil {
+		t.Errorf("expected an error for a language other than english")
+	}
+}
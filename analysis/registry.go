@@ -0,0 +1,140 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// TokenFilter transforms a stream of tokens, e.g. by removing, replacing or adding tokens.
+type TokenFilter interface {
+	Filter(tokens []string) ([]string, error)
+}
+
+// TokenFilterFunc adapts an ordinary function to the TokenFilter interface.
+type TokenFilterFunc func(tokens []string) ([]string, error)
+
+func (f TokenFilterFunc) Filter(tokens []string) ([]string, error) {
+	return f(tokens)
+}
+
+// TokenFilterFactory creates a token filter for the language of an index.
+type TokenFilterFactory func(language string) (TokenFilter, error)
+
+// CharFilter transforms text before it is tokenized, e.g. to remove markup or rewrite characters
+// the tokenizer would split on.
+type CharFilter interface {
+	FilterText(text string) string
+}
+
+// CharFilterFunc adapts an ordinary function to the CharFilter interface.
+type CharFilterFunc func(text string) string
+
+func (f CharFilterFunc) FilterText(text string) string {
+	return f(text)
+}
+
+// CharFilterFactory creates a character filter for the language of an index.
+type CharFilterFactory func(language string) (CharFilter, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]TokenFilterFactory)
+	charRegistry = make(map[string]CharFilterFactory)
+)
+
+// registered reports whether a token or character filter uses name. It must be called with registryLock held.
+func registered(name string) bool {
+	_, token := registry[name]
+	_, char := charRegistry[name]
+	return token || char
+}
+
+// RegisterTokenFilter makes a token filter available by name in index settings.
+// It panics if a filter with the same name is already registered or the factory is nil.
+func RegisterTokenFilter(name string, factory TokenFilterFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if factory == nil {
+		panic("analysis: RegisterTokenFilter factory is nil")
+	}
+	if registered(name) {
+		panic("analysis: RegisterTokenFilter called twice for filter " + name)
+	}
+	registry[name] = factory
+}
+
+// RegisterCharFilter makes a character filter available by name in index settings. Token and
+// character filters share a namespace. It panics if a filter with the same name is already
+// registered or the factory is nil.
+func RegisterCharFilter(name string, factory CharFilterFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if factory == nil {
+		panic("analysis: RegisterCharFilter factory is nil")
+	}
+	if registered(name) {
+		panic("analysis: RegisterCharFilter called twice for filter " + name)
+	}
+	charRegistry[name] = factory
+}
+
+// NewTokenFilter creates the token filter registered under name for the given language.
+func NewTokenFilter(name string, language string) (TokenFilter, error) {
+	registryLock.RLock()
+	factory, ok := registry[name]
+	registryLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown token filter %q", name)
+	}
+	return factory(language)
+}
+
+// TokenFilters returns the sorted names of all registered token filters.
+func TokenFilters() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCharFilter creates the character filter registered under name for the given language.
+func NewCharFilter(name string, language string) (CharFilter, error) {
+	registryLock.RLock()
+	factory, ok := charRegistry[name]
+	registryLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown character filter %q", name)
+	}
+	return factory(language)
+}
+
+// CharFilters returns the sorted names of all registered character filters.
+func CharFilters() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(charRegistry))
+	for name := range charRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isCharFilter reports whether name is a registered character filter.
+func isCharFilter(name string) bool {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	_, ok := charRegistry[name]
+	return ok
+}
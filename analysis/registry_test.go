@@ -0,0 +1,48 @@
+package analysis
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegisterTokenFilter(t *testing.T) {
+	RegisterTokenFilter("test_reverse", func(language string) (TokenFilter, error) {
+		return TokenFilterFunc(func(tokens []string) ([]string, error) {
+			for i, token := range tokens {
+				runes := []rune(token)
+				slices.Reverse(runes)
+				tokens[i] = string(runes)
+			}
+			return tokens, nil
+		}), nil
+	})
+
+	if !slices.Contains(TokenFilters(), "test_reverse") {
+		t.Errorf("registered filter missing from %v", TokenFilters())
+	}
+
+	analyzer, err := NewAnalyzer("english", false, []string{"test_reverse"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tokens, err := analyzer.Analyze("The quick fox")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(tokens, []string{"kciuq", "xof"}) {
+		t.Errorf("wrong tokens %v", tokens)
+	}
+
+	_, err = NewAnalyzer("english", false, []string{"missing"})
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected unknown filter error, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a filter twice should panic")
+		}
+	}()
+	RegisterTokenFilter("test_reverse", func(language string) (TokenFilter, error) { return nil, nil })
+}
@@ -0,0 +1,45 @@
+package analysis
+
+// stopWordFilter marks the token filters that remove stop words, so that they can be told apart
+// from the other filters of an analyzer.
+type stopWordFilter struct {
+	TokenFilter
+}
+
+// AddStopWords makes the analyzer remove words after every other token filter, so they are
+// compared with the final form of tokens, stemmed if stemming is enabled.
+func (a *Analyzer) AddStopWords(words []string) {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	a.filters = append(a.filters, stopWordFilter{TokenFilterFunc(func(tokens []string) ([]string, error) {
+		filtered := tokens[:0]
+		for _, token := range tokens {
+			if !set[token] {
+				filtered = append(filtered, token)
+			}
+		}
+		return filtered, nil
+	})})
+}
+
+// RemoveStopWords makes the analyzer keep stop words, removing the stop word list of its language
+// and any added with AddStopWords.
+func (a *Analyzer) RemoveStopWords() {
+	filters := make([]TokenFilter, 0, len(a.filters))
+	for _, filter := range a.filters {
+		if _, ok := filter.(stopWordFilter); !ok {
+			filters = append(filters, filter)
+		}
+	}
+	a.filters = filters
+}
+
+// WithoutStopWords returns a copy of the analyzer that keeps stop words, leaving the analyzer
+// unchanged.
+func (a *Analyzer) WithoutStopWords() *Analyzer {
+	clone := *a
+	clone.RemoveStopWords()
+	return &clone
+}
@@ -0,0 +1,43 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStopWords(t *testing.T) {
+	analyzer, err := NewAnalyzer("english", true, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	analyzer.RemoveStopWords()
+	tokens, err := analyzer.Analyze("the errors of the logs")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(tokens, []string{"the", "error", "of", "the", "log"}) {
+		t.Errorf("wrong tokens without stop words %v", tokens)
+	}
+
+	// added stop words are compared with stemmed tokens
+	analyzer.AddStopWords([]string{"error", "the"})
+	tokens, err = analyzer.Analyze("the errors of the logs")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(tokens, []string{"of", "log"}) {
+		t.Errorf("wrong tokens with added stop words %v", tokens)
+	}
+
+	// copies without stop words leave the analyzer unchanged
+	tokens, err = analyzer.WithoutStopWords().Analyze("the errors of the logs")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(tokens, []string{"the", "error", "of", "the", "log"}) {
+		t.Errorf("wrong tokens of a copy without stop words %v", tokens)
+	}
+	if tokens, _ = analyzer.Analyze("the errors"); len(tokens) != 0 {
+		t.Errorf("expected the analyzer to keep removing stop words, got %v", tokens)
+	}
+}
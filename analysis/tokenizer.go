@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Tokenizer splits text into tokens.
+type Tokenizer interface {
+	Tokenize(text string) []string
+}
+
+// StandardTokenizer lowercases text and splits it like Tokenize. It can optionally keep URLs,
+// email addresses, hashtags and mentions as single tokens instead of splitting them into fragments.
+type StandardTokenizer struct {
+	URLs          bool // keep URLs and email addresses whole
+	URLComponents bool // also emit the host and path words of URLs and the parts of email addresses
+	Hashtags      bool // keep #hashtags and @mentions whole
+	StripSigils   bool // remove the leading # or @ of hashtags and mentions
+}
+
+var (
+	urlEmailPattern = regexp.MustCompile(
+		`(?i)\b(?:(?:https?|ftp)://|www\.)[^\s<>"'` + "`" + `]+|[\pL\pN._%+\-]+@[\pL\pN\-]+(?:\.[\pL\pN\-]+)+`,
+	)
+	// the sigil must start a word, so that email addresses and URL fragments are not matched
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\pL\pN\pM_@#&/])([#@][\pL\pN\pM_]+)`)
+)
+
+type span struct {
+	start, end int
+}
+
+// spans returns the positions of the tokens that must not be split, ordered by start.
+func (t StandardTokenizer) spans(text string) []span {
+	spans := make([]span, 0)
+	if t.URLs {
+		for _, loc := range urlEmailPattern.FindAllStringIndex(text, -1) {
+			// punctuation ending a sentence is rarely part of the URL
+			match := strings.TrimRight(text[loc[0]:loc[1]], ".,;:!?)]}")
+			spans = append(spans, span{loc[0], loc[0] + len(match)})
+		}
+	}
+	if t.Hashtags {
+		for _, loc := range hashtagPattern.FindAllStringSubmatchIndex(text, -1) {
+			spans = append(spans, span{loc[2], loc[3]})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	return spans
+}
+
+func (t StandardTokenizer) Tokenize(text string) []string {
+	if !t.URLs && !t.Hashtags {
+		return Tokenize(text)
+	}
+
+	tokens := make([]string, 0)
+	var last int
+	for _, s := range t.spans(text) {
+		if s.start < last {
+			continue
+		}
+		tokens = append(tokens, Tokenize(text[last:s.start])...)
+		token := strings.ToLower(text[s.start:s.end])
+		switch {
+		case token[0] == '#' || token[0] == '@':
+			if t.StripSigils {
+				token = token[1:]
+			}
+			tokens = append(tokens, token)
+		case t.URLComponents:
+			tokens = append(append(tokens, token), urlComponents(token)...)
+		default:
+			tokens = append(tokens, token)
+		}
+		last = s.end
+	}
+	return append(tokens, Tokenize(text[last:])...)
+}
+
+// urlComponents returns the local part and domain of an email address, or the host and the
+// words of the path, query and fragment of a URL.
+func urlComponents(token string) []string {
+	if local, domain, ok := strings.Cut(token, "@"); ok && !strings.Contains(token, "/") {
+		return []string{local, domain}
+	}
+
+	if !strings.Contains(token, "://") {
+		token = "http://" + token
+	}
+	u, err := url.Parse(token)
+	if err != nil {
+		return nil
+	}
+	components := make([]string, 0)
+	if host := strings.TrimPrefix(u.Hostname(), "www."); host != "" {
+		components = append(components, host)
+	}
+	return append(components, Tokenize(u.Path+" "+u.RawQuery+" "+u.Fragment)...)
+}
+
+// SetTokenizer replaces the tokenizer of the analyzer, which defaults to Tokenize.
+func (a *Analyzer) SetTokenizer(tokenizer Tokenizer) {
+	a.tokenizer = tokenizer
+}
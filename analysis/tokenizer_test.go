@@ -0,0 +1,63 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStandardTokenizer(t *testing.T) {
+	inputs := []struct {
+		tokenizer StandardTokenizer
+		text      string
+		tokens    []string
+	}{
+		{StandardTokenizer{}, "Mail foo@bar.com", []string{"mail", "foo", "bar", "com"}},
+		{StandardTokenizer{URLs: true}, "Mail Foo@Bar.com.", []string{"mail", "foo@bar.com"}},
+		{
+			StandardTokenizer{URLs: true},
+			"See https://example.com/path?q=1, or www.go.dev)",
+			[]string{"see", "https://example.com/path?q=1", "or", "www.go.dev"},
+		},
+		{
+			StandardTokenizer{URLs: true, URLComponents: true},
+			"https://www.example.com/search/docs?q=trie and foo.bar@mail.org",
+			[]string{
+				"https://www.example.com/search/docs?q=trie", "example.com", "search", "docs", "q", "trie",
+				"and", "foo.bar@mail.org", "foo.bar", "mail.org",
+			},
+		},
+		{
+			StandardTokenizer{Hashtags: true},
+			"#Go rocks @gopher_team, a#b #c++ x@y",
+			[]string{"#go", "rocks", "@gopher_team", "a", "b", "#c", "x", "y"},
+		},
+		{
+			StandardTokenizer{Hashtags: true, StripSigils: true},
+			"#state_of_the_union with @potus",
+			[]string{"state_of_the_union", "with", "potus"},
+		},
+		{
+			StandardTokenizer{URLs: true, Hashtags: true},
+			"@dev see https://go.dev/doc#intro or mail dev@go.dev #golang",
+			[]string{"@dev", "see", "https://go.dev/doc#intro", "or", "mail", "dev@go.dev", "#golang"},
+		},
+	}
+	for _, input := range inputs {
+		if tokens := input.tokenizer.Tokenize(input.text); !slices.Equal(tokens, input.tokens) {
+			t.Errorf("tokens %v different from expected %v for %s", tokens, input.tokens, input.text)
+		}
+	}
+
+	analyzer, err := NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	analyzer.SetTokenizer(StandardTokenizer{URLs: true})
+	tokens, err := analyzer.Analyze("Write to the team at team@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(tokens, []string{"write", "team", "team@example.com"}) {
+		t.Errorf("wrong tokens %v", tokens)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKey is a client of a shared deployment, with its daily quotas. A quota of 0 means no limit.
+type apiKey struct {
+	Name              string `json:"name"`
+	Key               string `json:"key"`
+	DailyQueries      uint64 `json:"daily_queries"`
+	DailyIndexedBytes int64  `json:"daily_indexed_bytes"`
+
+	usage keyUsage
+	lock  sync.Mutex
+}
+
+// keyUsage is the volume of requests of an API key during a day, in UTC.
+type keyUsage struct {
+	Day          string `json:"day"`
+	Queries      uint64 `json:"queries"`
+	IndexedBytes int64  `json:"indexed_bytes"`
+}
+
+// today returns the usage of the current day, resetting it when a new day starts. It must be
+// called with the key lock held.
+func (k *apiKey) today(now time.Time) *keyUsage {
+	if day := now.UTC().Format(time.DateOnly); k.usage.Day != day {
+		k.usage = keyUsage{Day: day}
+	}
+	return &k.usage
+}
+
+// loadAPIKeys reads the API keys from a JSON array of objects with a name, a key and optional
+// daily_queries and daily_indexed_bytes quotas.
+func loadAPIKeys(path string) (map[string]*apiKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*apiKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid API keys file: %w", err)
+	}
+	keys := make(map[string]*apiKey, len(list))
+	names := make(map[string]bool, len(list))
+	for _, key := range list {
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf("every API key needs a name and a key")
+		}
+		if key.DailyIndexedBytes < 0 {
+			return nil, fmt.Errorf("API key %s has a negative daily_indexed_bytes", key.Name)
+		}
+		if names[key.Name] || keys[key.Key] != nil {
+			return nil, fmt.Errorf("API key %s is not unique", key.Name)
+		}
+		names[key.Name] = true
+		keys[key.Key] = key
+	}
+	return keys, nil
+}
+
+type apiKeyContextKey struct{}
+
+// requestAPIKey returns the API key of a request, or nil if API keys are disabled.
+func requestAPIKey(r *http.Request) *apiKey {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*apiKey)
+	return key
+}
+
+// authenticate rejects requests without a valid API key when API keys are enabled. The key is read
+// from an "Authorization: Bearer" or an X-API-Key header. The search page is served to anyone, but
+// needs a key for its own requests.
+func (a *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.apiKeys == nil || r.URL.Path == "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			secret = bearer
+		}
+		key, ok := a.apiKeys[secret]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errUnauthorized, "a valid API key is required")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// meterQueries counts the searches of a handler against the daily query quota of their API key,
+// rejecting them once it is used up.
+func (a *App) meterQueries(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := requestAPIKey(r)
+		if key == nil {
+			handler(w, r)
+			return
+		}
+		key.lock.Lock()
+		now := time.Now()
+		usage := key.today(now)
+		exceeded := key.DailyQueries > 0 && usage.Queries >= key.DailyQueries
+		if !exceeded {
+			usage.Queries++
+		}
+		key.lock.Unlock()
+
+		if exceeded {
+			quotaExceeded(w, now, fmt.Sprintf("the daily quota of %d queries of %s is used up", key.DailyQueries, key.Name))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// meterIndexing counts size bytes submitted for indexing against the daily quota of the API key of
+// the request. It writes a 429 response and returns false if they would exceed the quota.
+func (a *App) meterIndexing(w http.ResponseWriter, r *http.Request, size int64) bool {
+	key := requestAPIKey(r)
+	if key == nil {
+		return true
+	}
+	key.lock.Lock()
+	now := time.Now()
+	usage := key.today(now)
+	exceeded := key.DailyIndexedBytes > 0 && usage.IndexedBytes+size > key.DailyIndexedBytes
+	if !exceeded {
+		usage.IndexedBytes += size
+	}
+	remaining := key.DailyIndexedBytes - usage.IndexedBytes
+	key.lock.Unlock()
+
+	if exceeded {
+		quotaExceeded(w, now, fmt.Sprintf(
+			"indexing %s would exceed the daily quota of %s, %s remain today",
+			formatBytes(size), key.Name, formatBytes(max(remaining, 0)),
+		))
+		return false
+	}
+	return true
+}
+
+// refundIndexing gives back bytes metered by meterIndexing that were not indexed after all, such
+// as the part of the worst-case size of a URL upload the pages did not take.
+func refundIndexing(r *http.Request, size int64) {
+	key := requestAPIKey(r)
+	if key == nil {
+		return
+	}
+	key.lock.Lock()
+	defer key.lock.Unlock()
+	usage := key.today(time.Now())
+	usage.IndexedBytes = max(usage.IndexedBytes-size, 0)
+}
+
+// quotaExceeded writes a 429 response asking to retry once quotas reset, at midnight UTC.
+func quotaExceeded(w http.ResponseWriter, now time.Time, message string) {
+	midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(midnight.Sub(now).Seconds()))))
+	writeError(w, http.StatusTooManyRequests, errQuotaExceeded, message)
+}
+
+type usageResponse struct {
+	Name              string `json:"name"`
+	DailyQueries      uint64 `json:"daily_queries"`
+	DailyIndexedBytes int64  `json:"daily_indexed_bytes"`
+	keyUsage
+}
+
+// usage returns the usage of the API key of the request for the current day.
+func (a *App) usage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	key := requestAPIKey(r)
+	if key == nil {
+		writeError(w, http.StatusNotFound, errNotFound, "API keys are not enabled")
+		return
+	}
+	key.lock.Lock()
+	response := usageResponse{
+		Name:              key.Name,
+		DailyQueries:      key.DailyQueries,
+		DailyIndexedBytes: key.DailyIndexedBytes,
+		keyUsage:          *key.today(time.Now()),
+	}
+	key.lock.Unlock()
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAPIKeyQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys := `[
+		{"name": "search", "key": "s3cret", "daily_queries": 2},
+		{"name": "ingest", "key": "1ngest", "daily_indexed_bytes": 400}
+	]`
+	if err := os.WriteFile(path, []byte(keys), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp()
+	var err error
+	if app.apiKeys, err = loadAPIKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	handler := app.Routes()
+	serve := func(r *http.Request, key string) *httptest.ResponseRecorder {
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(httptest.NewRequest(http.MethodGet, "/v1/indexes", nil), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request without key to be rejected, got status %d", w.Code)
+	}
+	if w := serve(uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nblue whale\n"), "1ngest"); w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nblue whale\n"), "1ngest"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the indexing quota to be exceeded, got status %d", w.Code)
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := serve(httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=fox", nil), "s3cret")
+		if w.Code != expected {
+			t.Errorf("search %d returned status %d instead of %d", i, w.Code, expected)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("expected a Retry-After header")
+		}
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/v1/usage", nil), "s3cret")
+	var usage usageResponse
+	if err := json.NewDecoder(w.Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Name != "search" || usage.Queries != 2 || usage.DailyQueries != 2 || usage.Day == "" {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// usage starts over every day
+	app.apiKeys["s3cret"].usage.Day = "2000-01-01"
+	if w := serve(httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=fox", nil), "s3cret"); w.Code != http.StatusOK {
+		t.Errorf("expected the quota to reset on a new day, got status %d", w.Code)
+	}
+	if w := serve(evaluateRequest("/v1/indexes/default/evaluate", "q1 fox\n", "q1 0 0 1\n"), "s3cret"); w.Code != http.StatusOK {
+		t.Errorf("evaluation failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(evaluateRequest("/v1/indexes/default/evaluate", "q1 fox\n", "q1 0 0 1\n"), "s3cret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected evaluations to count against the query quota, got status %d", w.Code)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"name": "a", "key": "k"}, {"name": "b", "key": "k"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAPIKeys(path); err == nil {
+		t.Error("expected an error for a duplicated key")
+	}
+}
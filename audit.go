@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// auditEntry is a line of the audit log, recording a request that changed or tried to change indexes.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Action     string    `json:"action"`
+	Index      string    `json:"index"`
+	ID         string    `json:"id,omitempty"`     // document or upload id
+	Params     string    `json:"params,omitempty"` // encoded query string
+	RemoteAddr string    `json:"remote_addr"`
+	APIKey     string    `json:"api_key,omitempty"` // name of the API key
+	Status     int       `json:"status"`
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// audited records the requests of a handler that can change indexes in the audit log, if it is
+// enabled. Requests that only read, such as the status of an upload, are not recorded.
+func (a *App) audited(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.auditLog == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		remoteAddr := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			remoteAddr = host
+		}
+		var keyName string
+		if key := requestAPIKey(r); key != nil {
+			keyName = key.Name
+		}
+		a.auditLog.log(auditEntry{
+			Time:       start.UTC(),
+			RequestID:  requestID(r),
+			Action:     action,
+			Index:      indexName(r),
+			ID:         r.PathValue("id"),
+			Params:     r.URL.RawQuery,
+			RemoteAddr: remoteAddr,
+			APIKey:     keyName,
+			Status:     recorder.status,
+		})
+	}
+}
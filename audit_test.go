@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := openJSONLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp()
+	app.auditLog = auditLog
+	handler := app.Routes()
+
+	w := httptest.NewRecorder()
+	r := uploadRequest(t, "/v1/indexes/books/corpus?language=english", "red fox\nblue whale\n")
+	r.Header.Set(requestIDHeader, "upload-1")
+	handler.ServeHTTP(w, r)
+	searchIds(t, handler, "/v1/indexes/books/search?query=fox")
+	for _, url := range []string{"/v1/indexes/books/documents/1", "/v1/indexes/books/documents/7"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, url, nil))
+	}
+	auditLog.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []auditEntry
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// searches are not recorded
+	expected := []auditEntry{
+		{RequestID: "upload-1", Action: "upload_corpus", Index: "books", Params: "language=english", Status: http.StatusOK},
+		{Action: "delete_document", Index: "books", ID: "1", Status: http.StatusNoContent},
+		{Action: "delete_document", Index: "books", ID: "7", Status: http.StatusNotFound},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Time.IsZero() || entry.RemoteAddr != "192.0.2.1" || entry.RequestID == "" {
+			t.Errorf("entry %d misses when or who: %+v", i, entry)
+		}
+		entry.Time, entry.RemoteAddr = expected[i].Time, ""
+		if expected[i].RequestID == "" {
+			entry.RequestID = ""
+		}
+		if entry != expected[i] {
+			t.Errorf("entry %d is %+v instead of %+v", i, entry, expected[i])
+		}
+	}
+}
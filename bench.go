@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchSummary is the outcome of a benchmark: the latencies of the successful queries and the
+// time taken to run them all.
+type benchSummary struct {
+	replaySummary
+	elapsed time.Duration
+}
+
+// throughput returns the number of successful queries per second.
+func (s *benchSummary) throughput() float64 {
+	if s.elapsed <= 0 {
+		return 0
+	}
+	return float64(len(s.latencies)) / s.elapsed.Seconds()
+}
+
+func (s *benchSummary) print(w io.Writer, concurrency int) {
+	s.sortLatencies()
+	fmt.Fprintf(w, "queries:     %d\n", s.queries)
+	fmt.Fprintf(w, "errors:      %d\n", s.errors)
+	fmt.Fprintf(w, "concurrency: %d\n", concurrency)
+	fmt.Fprintf(w, "elapsed:     %s\n", s.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput:  %.1f queries/s\n", s.throughput())
+	fmt.Fprintf(w, "mean:        %s\n", s.mean())
+	fmt.Fprintf(w, "p50:         %s\n", s.percentile(50))
+	fmt.Fprintf(w, "p90:         %s\n", s.percentile(90))
+	fmt.Fprintf(w, "p95:         %s\n", s.percentile(95))
+	fmt.Fprintf(w, "p99:         %s\n", s.percentile(99))
+	fmt.Fprintf(w, "max:         %s\n", s.percentile(100))
+}
+
+// readQueries reads a query file, with the text of a query per line.
+func readQueries(r io.Reader) ([]string, error) {
+	queries := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), defaultMaxLineSize)
+	for scanner.Scan() {
+		if query := strings.TrimSpace(scanner.Text()); query != "" {
+			queries = append(queries, query)
+		}
+	}
+	return queries, scanner.Err()
+}
+
+// benchQueries runs each query repeat times against an index of the server at addr, from
+// concurrency clients at once. params are added to every search, and apiKey is sent if set.
+func benchQueries(
+	client *http.Client, addr, index, apiKey string, params url.Values, queries []string, concurrency, repeat int,
+) *benchSummary {
+	endpoint := addr + "/v1/indexes/" + url.PathEscape(index) + "/search?"
+	jobs := make(chan string)
+	go func() {
+		for range repeat {
+			for _, query := range queries {
+				jobs <- query
+			}
+		}
+		close(jobs)
+	}()
+
+	summary := &benchSummary{replaySummary: replaySummary{latencies: make([]time.Duration, 0, repeat*len(queries))}}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for query := range jobs {
+				latency, err := benchQuery(client, endpoint, apiKey, params, query)
+				lock.Lock()
+				summary.queries++
+				if err != nil {
+					summary.errors++
+				} else {
+					summary.latencies = append(summary.latencies, latency)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	summary.elapsed = time.Since(start)
+	return summary
+}
+
+// benchQuery runs a search and returns its latency, including reading the whole response.
+func benchQuery(client *http.Client, endpoint, apiKey string, params url.Values, query string) (time.Duration, error) {
+	values := url.Values{}
+	for key, value := range params {
+		values[key] = value
+	}
+	values.Set("query", query)
+	req, err := http.NewRequest(http.MethodGet, endpoint+values.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return latency, nil
+}
+
+// bench implements the bench subcommand, which measures the latency and throughput of a server
+// under a load of concurrent searches.
+func bench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	addr := flags.String("addr", "http://localhost:8345", "address of the stellr server")
+	index := flags.String("index", "default", "index to search")
+	queriesPath := flags.String("queries", "", "file with the text of a query per line")
+	concurrency := flags.Int("concurrency", 32, "number of searches running at once")
+	repeat := flags.Int("repeat", 1, "number of times each query is run")
+	rawParams := flags.String("params", "", "search parameters added to every query, such as \"type=prefix&size=10\"")
+	apiKey := flags.String("api-key", "", "API key sent with every query")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stellr bench [flags] -queries file")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *queriesPath == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *concurrency < 1 || *repeat < 1 {
+		return fmt.Errorf("concurrency and repeat must be at least 1")
+	}
+	params, err := url.ParseQuery(*rawParams)
+	if err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+
+	file, err := os.Open(*queriesPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	queries, err := readQueries(file)
+	if err != nil {
+		return err
+	}
+	if len(queries) == 0 {
+		return fmt.Errorf("%s has no queries", *queriesPath)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *concurrency
+	client := &http.Client{Timeout: fetchTimeout, Transport: transport}
+	summary := benchQueries(client, strings.TrimSuffix(*addr, "/"), *index, *apiKey, params, queries, *concurrency, *repeat)
+	summary.print(os.Stdout, *concurrency)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	handler := NewApp().Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/books/corpus", "red fox\nred fox jumps high\nblue whale\n"))
+
+	queries, err := readQueries(strings.NewReader("fox\n\n  whale \nred fox\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 3 || queries[1] != "whale" {
+		t.Fatalf("wrong queries %q", queries)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	params := url.Values{"type": {"prefix"}}
+	summary := benchQueries(server.Client(), server.URL, "books", "", params, queries, 4, 5)
+	if summary.queries != 15 || summary.errors != 0 || len(summary.latencies) != 15 || summary.throughput() <= 0 {
+		t.Errorf("wrong bench summary %+v", summary)
+	}
+
+	summary = benchQueries(server.Client(), server.URL, "missing", "", nil, queries, 2, 1)
+	if summary.queries != 3 || summary.errors != 3 {
+		t.Errorf("expected 3 errors, got %+v", summary)
+	}
+}
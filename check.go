@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// checkInvariants are the properties of an index verified by the check subcommand.
+var checkInvariants = []string{
+	"document tokens are found by exact search",
+	"fuzzy search at distance 0 equals exact search",
+	"term document sets match document terms",
+}
+
+// checkReport lists the violations of each invariant found in an index.
+type checkReport struct {
+	documents  int
+	terms      int
+	violations map[string][]string // by invariant
+}
+
+func (r *checkReport) add(invariant string, format string, args ...any) {
+	r.violations[invariant] = append(r.violations[invariant], fmt.Sprintf(format, args...))
+}
+
+// count returns the total number of violations.
+func (r *checkReport) count() int {
+	var n int
+	for _, violations := range r.violations {
+		n += len(violations)
+	}
+	return n
+}
+
+// print writes a line per invariant, followed by at most maxExamples of its violations.
+func (r *checkReport) print(w io.Writer, maxExamples int) {
+	fmt.Fprintf(w, "documents:   %d\n", r.documents)
+	fmt.Fprintf(w, "terms:       %d\n", r.terms)
+	for _, invariant := range checkInvariants {
+		violations := r.violations[invariant]
+		if len(violations) == 0 {
+			fmt.Fprintf(w, "ok       %s\n", invariant)
+			continue
+		}
+		fmt.Fprintf(w, "FAILED   %s: %d violations\n", invariant, len(violations))
+		for _, violation := range violations[:min(maxExamples, len(violations))] {
+			fmt.Fprintf(w, "         %s\n", violation)
+		}
+	}
+}
+
+// checkIndex verifies the invariants of an index built from corpus:
+//   - every token of every document, as analyzed again from its text, has the document in its set
+//   - a fuzzy search at distance 0 finds the same term and documents as an exact search
+//   - the set of each term has the same documents as the terms of documents, and as its df
+func checkIndex(ctx context.Context, t *trieSearchIndex, corpus []document) (*checkReport, error) {
+	report := &checkReport{documents: len(corpus), violations: make(map[string][]string)}
+
+	for id, doc := range corpus {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if id >= len(t.docTerms) || t.docTerms[id].counts == nil {
+			// skipped near-duplicates and vacant ids are not indexed
+			continue
+		}
+		tokens, err := t.options.analyzer.Analyze(doc.text)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			if res := t.invIndex.Search(token); res == nil || !res.set.Contains(uint32(id)) {
+				report.add(checkInvariants[0], "document %d: token %q not found", id, token)
+			}
+		}
+	}
+
+	docCounts := make(map[string]uint64)
+	for _, terms := range t.docTerms {
+		for tokenID := range terms.counts {
+			docCounts[t.vocabulary.token(tokenID)]++
+		}
+	}
+	tokenSets := t.invIndex.Traversal()
+	report.terms = len(tokenSets)
+	for _, tokenSet := range tokenSets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		token, cardinality := tokenSet.token, tokenSet.set.GetCardinality()
+
+		exact := t.invIndex.Search(token)
+		fuzzy := t.invIndex.FuzzySearch(ctx, token, 0, 0)
+		switch {
+		case exact == nil:
+			report.add(checkInvariants[1], "term %q: not found by exact search", token)
+		case fuzzy == nil || !slices.Equal(fuzzy.tokens, []string{token}):
+			report.add(checkInvariants[1], "term %q: fuzzy search found %v", token, fuzzyTokens(fuzzy))
+		case !fuzzy.set.Equals(exact.set):
+			report.add(
+				checkInvariants[1], "term %q: %d documents by fuzzy search, %d by exact search",
+				token, fuzzy.set.GetCardinality(), exact.set.GetCardinality(),
+			)
+		}
+
+		if count := docCounts[token]; count != cardinality {
+			report.add(checkInvariants[2], "term %q: %d documents in its set, %d with the term", token, cardinality, count)
+		}
+		if df := t.docFreq(token); df != cardinality {
+			report.add(checkInvariants[2], "term %q: %d documents in its set, df of %d", token, cardinality, df)
+		}
+		delete(docCounts, token)
+	}
+	for token, count := range docCounts {
+		report.add(checkInvariants[2], "term %q: in %d documents, missing from the index", token, count)
+	}
+	return report, nil
+}
+
+func fuzzyTokens(res *IndexResult) []string {
+	if res == nil {
+		return nil
+	}
+	return res.tokens
+}
+
+// check implements the check subcommand, which indexes a corpus and verifies the invariants of the
+// index, as a safety net for changes to indexing.
+func check(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	rawParams := flags.String("params", "", "index settings, as the parameters of an upload, such as \"language=portuguese&stem=false\"")
+	maxExamples := flags.Int("examples", 10, "maximum number of violations listed per invariant")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stellr check [flags] corpus")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	params, err := url.ParseQuery(*rawParams)
+	if err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	options, err := parseIndexOptions(&http.Request{Form: params})
+	if err != nil {
+		return err
+	}
+
+	path := flags.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	app := NewApp()
+	sources, cleanup, err := app.uploadSources(file, info.Size(), filepath.Base(path), app.limits.maxUploadSize)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	build, err := app.buildCorpus(sources, options, nil)
+	if err != nil {
+		return err
+	}
+
+	report, err := checkIndex(context.Background(), build.builder.Build().(*trieSearchIndex), build.corpus)
+	if err != nil {
+		return err
+	}
+	report.print(os.Stdout, *maxExamples)
+	if n := report.count(); n > 0 {
+		return fmt.Errorf("%d invariant violations", n)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCheckIndex(t *testing.T) {
+	options, err := parseIndexOptions(&http.Request{Form: url.Values{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := newIndexBuild(options)
+	for _, text := range []string{"the quick brown fox", "quick thinking", "brown bears and brown foxes"} {
+		if err := build.add(document{text: text}, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := build.builder.Build().(*trieSearchIndex)
+
+	report, err := checkIndex(context.Background(), index, build.corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.documents != 3 || report.terms == 0 || report.count() != 0 {
+		t.Fatalf("expected no violations, got %+v", report)
+	}
+
+	// losing a document from the set of a term breaks the lookup of its tokens and the counts
+	index.invIndex.Search("quick").set.Remove(1)
+	report, err = checkIndex(context.Background(), index, build.corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.violations[checkInvariants[0]]) != 1 || len(report.violations[checkInvariants[1]]) != 0 ||
+		len(report.violations[checkInvariants[2]]) != 2 {
+		t.Errorf("unexpected violations %v", report.violations)
+	}
+}
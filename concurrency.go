@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxQueuedSearches = 64
+	defaultMaxSearchWait     = 5 * time.Second
+)
+
+// defaultMaxConcurrentSearches leaves a processor free for uploads when all searches are busy.
+var defaultMaxConcurrentSearches = max(1, runtime.GOMAXPROCS(0)-1)
+
+// searchLimiter bounds the number of searches running at the same time. Searches over the limit
+// wait in a queue for a running one to finish. When the queue is full or a search waits too long,
+// the search is rejected so that latency stays bounded.
+type searchLimiter struct {
+	slots    chan struct{}
+	maxQueue int
+	maxWait  time.Duration
+	queued   atomic.Int64
+	shed     atomic.Uint64 // searches rejected since the server started
+}
+
+func newSearchLimiter(maxConcurrent int, maxQueue int, maxWait time.Duration) *searchLimiter {
+	return &searchLimiter{slots: make(chan struct{}, maxConcurrent), maxQueue: maxQueue, maxWait: maxWait}
+}
+
+// limitSearches runs a search handler once the limiter has a free slot. Requests whose client
+// disconnects while waiting are dropped, and requests that cannot be queued or wait too long get
+// a 503 response.
+func (a *App) limitSearches(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := a.searchLimiter
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if l.queued.Add(1) > int64(l.maxQueue) {
+				l.queued.Add(-1)
+				l.reject(w, "too many searches are waiting")
+				return
+			}
+			timer := time.NewTimer(l.maxWait)
+			select {
+			case l.slots <- struct{}{}:
+			case <-timer.C:
+				l.queued.Add(-1)
+				l.reject(w, "timed out waiting for other searches to finish")
+				return
+			case <-r.Context().Done():
+				l.queued.Add(-1)
+				timer.Stop()
+				return
+			}
+			l.queued.Add(-1)
+			timer.Stop()
+		}
+		defer func() { <-l.slots }()
+		handler(w, r)
+	}
+}
+
+func (l *searchLimiter) reject(w http.ResponseWriter, message string) {
+	l.shed.Add(1)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.maxWait.Seconds()))))
+	writeError(w, http.StatusServiceUnavailable, errOverloaded, "server overloaded, "+message)
+}
+
+type metricsResponse struct {
+	SearchesRunning int    `json:"searches_running"`
+	SearchesQueued  int64  `json:"searches_queued"`
+	SearchesShed    uint64 `json:"searches_shed"`
+	MemoryUsed      int64  `json:"memory_used"`            // estimated bytes used by indexes, uploads and searches
+	MemoryLimit     int64  `json:"memory_limit,omitempty"` // 0 if memory is not limited
+}
+
+// metrics reports the load of the server.
+func (a *App) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	l := a.searchLimiter
+	writeResponse(w, r, metricsResponse{
+		SearchesRunning: len(l.slots),
+		SearchesQueued:  l.queued.Load(),
+		SearchesShed:    l.shed.Load(),
+		MemoryUsed:      a.memory.used(),
+		MemoryLimit:     a.memory.limit,
+	})
+}
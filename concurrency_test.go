@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitSearches(t *testing.T) {
+	app := NewApp()
+	app.searchLimiter = newSearchLimiter(1, 1, time.Minute)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls int
+	handler := app.limitSearches(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		started <- struct{}{}
+		<-release
+	})
+
+	done := make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search", nil))
+		close(done)
+	}()
+	<-started
+
+	// the only slot is taken, so a request whose client gives up never runs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search", nil).WithContext(ctx))
+
+	close(release)
+	<-done
+	if calls != 1 {
+		t.Errorf("expected 1 search to run, got %d", calls)
+	}
+}
+
+func TestShedSearches(t *testing.T) {
+	app := NewApp()
+	routes := app.Routes()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := app.limitSearches(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+
+	for _, limiter := range []*searchLimiter{newSearchLimiter(1, 0, time.Minute), newSearchLimiter(1, 1, time.Millisecond)} {
+		app.searchLimiter = limiter
+		done := make(chan struct{})
+		go func() {
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search", nil))
+			close(done)
+		}()
+		<-started
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Errorf("expected status 503 with Retry-After, got %d", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/metrics", nil))
+		var metrics metricsResponse
+		if err := json.NewDecoder(w.Body).Decode(&metrics); err != nil {
+			t.Fatal(err)
+		}
+		if metrics.SearchesRunning != 1 || metrics.SearchesQueued != 0 || metrics.SearchesShed != 1 {
+			t.Errorf("wrong metrics %+v", metrics)
+		}
+
+		release <- struct{}{}
+		<-done
+	}
+}
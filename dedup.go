@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	minHashSize           = 128
+	lshBands              = 32
+	lshRows               = minHashSize / lshBands
+	shingleSize           = 3
+	defaultDedupThreshold = 0.9
+)
+
+type DedupMode int
+
+const (
+	NoDedup DedupMode = iota
+	FlagDuplicates
+	SkipDuplicates
+)
+
+func parseDedupMode(s string) (DedupMode, error) {
+	switch s {
+	case "", "none":
+		return NoDedup, nil
+	case "flag":
+		return FlagDuplicates, nil
+	case "skip":
+		return SkipDuplicates, nil
+	}
+	return NoDedup, fmt.Errorf("invalid dedup %q, must be one of none, flag or skip", s)
+}
+
+type DedupOptions struct {
+	mode      DedupMode
+	threshold float64
+}
+
+// parseDedupOptions reads the dedup and dedup_threshold settings.
+func parseDedupOptions(get func(string) string) (DedupOptions, error) {
+	mode, err := parseDedupMode(get("dedup"))
+	if err != nil {
+		return DedupOptions{}, err
+	}
+	if mode == NoDedup && get("dedup_threshold") != "" {
+		return DedupOptions{}, fmt.Errorf("dedup_threshold is only valid with dedup set to flag or skip")
+	}
+	threshold, err := parseFloatParam("dedup_threshold", get("dedup_threshold"), defaultDedupThreshold)
+	if err != nil {
+		return DedupOptions{}, err
+	}
+	if threshold > 1 {
+		return DedupOptions{}, fmt.Errorf("invalid dedup_threshold %v, must be between 0 and 1", threshold)
+	}
+	return DedupOptions{mode: mode, threshold: threshold}, nil
+}
+
+// minHashSeeds are fixed so that signatures are reproducible across runs.
+var minHashSeeds = func() [minHashSize]uint64 {
+	var seeds [minHashSize]uint64
+	rng := rand.New(rand.NewSource(1))
+	for i := range seeds {
+		seeds[i] = rng.Uint64()
+	}
+	return seeds
+}()
+
+// mix is the splitmix64 finalizer, used to derive one independent hash function per seed.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// shingles hashes every run of shingleSize consecutive tokens. Documents shorter than
+// shingleSize produce a single shingle with all their tokens.
+func shingles(tokens []string) []uint64 {
+	if len(tokens) == 0 {
+		return nil
+	}
+	n := max(len(tokens)-shingleSize+1, 1)
+	hashes := make([]uint64, n)
+	for i := range hashes {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:min(i+shingleSize, len(tokens))], " ")))
+		hashes[i] = h.Sum64()
+	}
+	return hashes
+}
+
+// minHash returns the MinHash signature of the shingles of tokens, or nil if there are none.
+func minHash(tokens []string) []uint64 {
+	hashes := shingles(tokens)
+	if len(hashes) == 0 {
+		return nil
+	}
+	signature := make([]uint64, minHashSize)
+	for i, seed := range minHashSeeds {
+		minValue := ^uint64(0)
+		for _, h := range hashes {
+			minValue = min(minValue, mix(h^seed))
+		}
+		signature[i] = minValue
+	}
+	return signature
+}
+
+// signatureSimilarity estimates the Jaccard similarity of the shingle sets of two signatures.
+func signatureSimilarity(a, b []uint64) float64 {
+	var equal int
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(a))
+}
+
+type lshBucket struct {
+	band int
+	hash uint64
+}
+
+// duplicateCluster is a document together with the near-duplicates found after it.
+type duplicateCluster struct {
+	Id         uint32   `json:"id"`
+	Duplicates []uint32 `json:"duplicates"`
+	Skipped    int      `json:"skipped"`
+}
+
+// deduplicator finds near-duplicate documents as they are added to an index build. Candidates
+// are found with locality-sensitive hashing over bands of the MinHash signatures, then
+// confirmed by comparing the full signatures.
+type deduplicator struct {
+	options        DedupOptions
+	signatures     [][]uint64 // by document id, nil for documents without tokens
+	buckets        map[lshBucket][]uint32
+	representative map[uint32]uint32 // flagged duplicate -> first document of its cluster
+	clusters       map[uint32]*duplicateCluster
+}
+
+func newDeduplicator(options DedupOptions) *deduplicator {
+	return &deduplicator{
+		options:        options,
+		buckets:        make(map[lshBucket][]uint32),
+		representative: make(map[uint32]uint32),
+		clusters:       make(map[uint32]*duplicateCluster),
+	}
+}
+
+func bandBuckets(signature []uint64) []lshBucket {
+	buckets := make([]lshBucket, lshBands)
+	for band := range buckets {
+		h := fnv.New64a()
+		var buf []byte
+		for _, v := range signature[band*lshRows : (band+1)*lshRows] {
+			buf = binary.LittleEndian.AppendUint64(buf, v)
+		}
+		h.Write(buf)
+		buckets[band] = lshBucket{band: band, hash: h.Sum64()}
+	}
+	return buckets
+}
+
+// find returns the first document of the cluster the signature is a near-duplicate of.
+func (d *deduplicator) find(signature []uint64) (uint32, bool) {
+	if signature == nil {
+		return 0, false
+	}
+
+	var best uint32
+	bestSimilarity := -1.0
+	seen := make(map[uint32]bool)
+	for _, bucket := range bandBuckets(signature) {
+		for _, id := range d.buckets[bucket] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			similarity := signatureSimilarity(signature, d.signatures[id])
+			if similarity >= d.options.threshold && similarity > bestSimilarity {
+				best, bestSimilarity = id, similarity
+			}
+		}
+	}
+	if bestSimilarity < 0 {
+		return 0, false
+	}
+	if first, ok := d.representative[best]; ok {
+		return first, true
+	}
+	return best, true
+}
+
+func (d *deduplicator) cluster(id uint32) *duplicateCluster {
+	c, ok := d.clusters[id]
+	if !ok {
+		c = &duplicateCluster{Id: id, Duplicates: make([]uint32, 0)}
+		d.clusters[id] = c
+	}
+	return c
+}
+
+// add records the signature of an indexed document so later documents can be compared to it.
+func (d *deduplicator) add(id uint32, signature []uint64) {
+	d.signatures = append(d.signatures, signature)
+	if signature == nil {
+		return
+	}
+	for _, bucket := range bandBuckets(signature) {
+		d.buckets[bucket] = append(d.buckets[bucket], id)
+	}
+}
+
+// flag records that the indexed document id is a near-duplicate of first.
+func (d *deduplicator) flag(first uint32, id uint32) {
+	d.representative[id] = first
+	c := d.cluster(first)
+	c.Duplicates = append(c.Duplicates, id)
+}
+
+// skip records that a near-duplicate of first was left out of the index.
+func (d *deduplicator) skip(first uint32) {
+	d.cluster(first).Skipped++
+}
+
+// result returns the clusters ordered by the id of their first document.
+func (d *deduplicator) result() []duplicateCluster {
+	clusters := make([]duplicateCluster, 0, len(d.clusters))
+	for _, c := range d.clusters {
+		clusters = append(clusters, *c)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Id < clusters[j].Id })
+	return clusters
+}
+
+// check looks for a near-duplicate of a document about to be indexed as id. It returns false if
+// the document should be skipped, and otherwise the document to index, flagged with a
+// duplicate_of field when needed.
+func (d *deduplicator) check(doc document, tokens []string, id uint32) (document, bool) {
+	signature := minHash(tokens)
+	first, found := d.find(signature)
+	if found && d.options.mode == SkipDuplicates {
+		d.skip(first)
+		return doc, false
+	}
+
+	if found {
+		doc.fields = maps.Clone(doc.fields)
+		if doc.fields == nil {
+			doc.fields = make(map[string]string, 1)
+		}
+		doc.fields["duplicate_of"] = strconv.FormatUint(uint64(first), 10)
+		d.flag(first, id)
+	}
+	d.add(id, signature)
+	return doc, true
+}
+
+func (a *App) duplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if idx.index == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+
+	clusters := idx.duplicates
+	if clusters == nil {
+		clusters = make([]duplicateCluster, 0)
+	}
+	writeResponse(w, r, clusters)
+}
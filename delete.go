@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// purgeRatio is the fraction of the documents of an index, not counting vacant ids, that can be
+// deleted before they are purged from it.
+const purgeRatio = 0.1
+
+// PurgeDocuments returns a copy of an index without the postings and weights of the deleted
+// documents. Deleted ids stay allocated as empty documents, so the ids of other documents do not
+// change, and weights are recomputed for the remaining documents.
+func PurgeDocuments(index SearchIndex, deleted *roaring.Bitmap) (SearchIndex, error) {
+	t, ok := index.(*trieSearchIndex)
+	if !ok {
+		return nil, fmt.Errorf("cannot purge index of type %T", index)
+	}
+
+	builder := NewTrieIndex(t.options).(*trieIndexBuilder)
+	for _, tokenSet := range t.invIndex.Traversal() {
+		if set := roaring.AndNot(tokenSet.set, deleted); !set.IsEmpty() {
+			builder.invIndex.Insert(tokenSet.token, set)
+		}
+	}
+	// token ids stay valid, so the vocabulary is shared and the weights of documents can be reused
+	builder.vocabulary, builder.base = t.vocabulary, t
+	builder.docTerms = slices.Clone(t.docTerms)
+	for it := deleted.Iterator(); it.HasNext(); {
+		if id := it.Next(); int(id) < len(builder.docTerms) && builder.docTerms[id].counts != nil {
+			builder.docTerms[id] = docTerms{}
+			builder.changed++
+		}
+	}
+	return builder.Build(), nil
+}
+
+// withoutDeleted removes the deleted and expired documents from a set of results, which must not
+// be shared.
+func (idx *namedIndex) withoutDeleted(set *roaring.Bitmap) {
+	if set == nil {
+		return
+	}
+	if idx.deleted != nil {
+		set.AndNot(idx.deleted)
+	}
+	for _, id := range idx.expired(time.Now()) {
+		set.Remove(id)
+	}
+}
+
+// exists reports whether a document is part of the corpus of the index: its id is neither vacant,
+// like those of purged documents, nor deleted, and it has not expired.
+func (idx *namedIndex) exists(id uint32) bool {
+	return id < uint32(len(idx.corpus)) && !idx.corpus[id].vacant && !idx.isDeleted(id)
+}
+
+// isDeleted reports whether a document was deleted and not yet purged, or has expired.
+func (idx *namedIndex) isDeleted(id uint32) bool {
+	if idx.deleted != nil && idx.deleted.Contains(id) {
+		return true
+	}
+	expires := idx.corpus[id].expires
+	return !expires.IsZero() && !expires.After(time.Now())
+}
+
+// purge removes the deleted documents from a version of the index and its corpus. It runs in the
+// background: searches use the current version meanwhile, and documents deleted during the purge
+// stay marked as deleted. r is the request that started the purge, nil for background sweeps.
+func (idx *namedIndex) purge(r *http.Request, index SearchIndex, corpus []document, deleted *roaring.Bitmap) {
+	defer func() {
+		idx.lock.Lock()
+		idx.purging = false
+		idx.lock.Unlock()
+	}()
+
+	purged, err := PurgeDocuments(index, deleted)
+	if err != nil {
+		logf(r, "Error purging index %s: %s", idx.name, err)
+		return
+	}
+	corpus = slices.Clone(corpus)
+	for it := deleted.Iterator(); it.HasNext(); {
+		corpus[it.Next()] = document{vacant: true}
+	}
+	fields := newFieldIndex(corpus)
+	memory := estimateMemory(purged, corpus)
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if idx.index != index {
+		// the index was replaced during the purge, along with its deleted documents
+		return
+	}
+	idx.index = purged
+	idx.corpus = corpus
+	idx.fields = fields
+	idx.setMemory(memory)
+	if idx.deleted != nil {
+		idx.deleted.AndNot(deleted)
+	}
+	idx.expirations = slices.DeleteFunc(idx.expirations, func(e expiration) bool { return deleted.Contains(e.id) })
+}
+
+// deleteDocument marks a document as deleted. It stops matching searches immediately, and is
+// removed from the index once enough documents have been deleted.
+func (a *App) deleteDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	idString := r.PathValue("id")
+	id, err := strconv.ParseUint(idString, 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "invalid document id "+strconv.Quote(idString))
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if idx.index == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+	if !idx.exists(uint32(id)) {
+		writeError(w, http.StatusNotFound, errDocumentNotFound, "document "+idString+" does not exist")
+		return
+	}
+
+	idx.markDeleted(r, uint32(id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// markDeleted excludes documents from searches and starts a purge of the current version if too
+// many documents are deleted. r is the request deleting them, nil for background sweeps. It must be
+// called with the index lock held.
+func (idx *namedIndex) markDeleted(r *http.Request, ids ...uint32) {
+	if idx.deleted == nil {
+		idx.deleted = roaring.New()
+	}
+	idx.deleted.AddMany(ids)
+	if !idx.purging && float64(idx.deleted.GetCardinality()) > purgeRatio*float64(idx.index.NumDocs()) {
+		idx.purging = true
+		go idx.purge(r, idx.index, idx.corpus, idx.deleted.Clone())
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+
+	"stellr/analysis"
+)
+
+func TestPurgeDocuments(t *testing.T) {
+	analyzer, err := analysis.NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexOptions{
+		language:   "english",
+		analyzer:   analyzer,
+		similarity: BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf},
+	}
+	corpus := []string{"the cat sat on the mat", "cat cat cat dog", "a dog and a cat", "dog", "bird"}
+
+	purged, err := PurgeDocuments(buildTestIndex(t, options, corpus), roaring.BitmapOf(1, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// deleted documents keep their ids, as vacant ids that are not part of the collection
+	builder := NewTrieIndex(options)
+	for i, text := range corpus {
+		if i == 1 || i == 3 {
+			builder.AddVacant(uint32(i))
+			continue
+		}
+		tokens, _ := analyzer.Analyze(text)
+		builder.Add(tokens, uint32(i))
+	}
+	expected := builder.Build()
+	if stats := purged.Stats(); stats.Documents != 3 {
+		t.Errorf("expected 3 documents after purging, got %d", stats.Documents)
+	}
+
+	for _, query := range []string{"cat", "dog", "bird cat"} {
+		res, _ := purged.Search(context.Background(), query, ExactSearch, Or, 0, searchLimits{})
+		expectedRes, _ := expected.Search(context.Background(), query, ExactSearch, Or, 0, searchLimits{})
+		ranked, _ := purged.Rank(context.Background(), res.tokens, res.DocIds())
+		expectedRanked, _ := expected.Rank(context.Background(), expectedRes.tokens, expectedRes.DocIds())
+		if len(ranked) != len(expectedRanked) {
+			t.Fatalf("purged index returned %v, expected %v for %s", ranked, expectedRanked, query)
+		}
+		for i := range ranked {
+			if ranked[i].id != expectedRanked[i].id || math.Abs(ranked[i].score-expectedRanked[i].score) > 1e-9 {
+				t.Errorf("purged index returned %v, expected %v for %s", ranked, expectedRanked, query)
+				break
+			}
+		}
+	}
+}
+
+func TestDeleteDocument(t *testing.T) {
+	app := NewApp()
+	handler := app.Routes()
+	corpus := strings.Repeat("red fox\n", 20)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.txt": corpus}, []string{"docs.txt"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	deleteDocument := func(id string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/indexes/default/documents/"+id, nil))
+		return w.Code
+	}
+	if code := deleteDocument("3"); code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", code)
+	}
+	if code := deleteDocument("3"); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a deleted document, got %d", code)
+	}
+	if code := deleteDocument("20"); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing document, got %d", code)
+	}
+	for _, url := range []string{"/v1/indexes/default/search?query=fox", "/v1/indexes/default/search?query=fox&size=5"} {
+		for _, res := range searchResults(t, handler, url) {
+			if res.Id == 3 {
+				t.Errorf("deleted document returned by %s", url)
+			}
+		}
+	}
+
+	idx, _ := app.getIndex(defaultIndexName)
+	idx.lock.Lock()
+	idx.purging = true
+	index, docs, deleted := idx.index, idx.corpus, idx.deleted.Clone()
+	idx.lock.Unlock()
+	idx.purge(nil, index, docs, deleted)
+
+	if idx.deleted.Contains(3) || !idx.corpus[3].vacant || idx.purging {
+		t.Errorf("document 3 was not purged")
+	}
+	if results := searchResults(t, handler, "/v1/indexes/default/search?query=fox"); len(results) != 19 {
+		t.Errorf("expected 19 results after purging, got %d", len(results))
+	}
+	// the id of a purged document stays vacant
+	if code := deleteDocument("3"); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a purged document, got %d", code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/documents/3/keywords", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for the keywords of a purged document, got %d", w.Code)
+	}
+
+	// a purge started before the deleted documents were reset, as an upload does, must not fail
+	if code := deleteDocument("4"); code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", code)
+	}
+	idx.lock.Lock()
+	index, docs, deleted = idx.index, idx.corpus, idx.deleted.Clone()
+	idx.deleted = nil
+	idx.lock.Unlock()
+	idx.purge(nil, index, docs, deleted)
+	if !idx.corpus[4].vacant {
+		t.Errorf("document 4 was not purged")
+	}
+}
+
+func TestEmptyLineDocuments(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/books/corpus", "red fox\n\nblue whale\n"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	// the empty line is a document without text, not a vacant id
+	if ids := esSearchIds(t, handler, `{"query": {"match_all": {}}}`); !slices.Equal(ids, []string{"0", "1", "2"}) {
+		t.Errorf("expected match_all to return every document, got %v", ids)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/indexes/books/documents/1", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204 deleting an empty document, got %d: %s", w.Code, w.Body.String())
+	}
+}
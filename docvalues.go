@@ -0,0 +1,307 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// docValues is the value of a field for every document, stored by document id so that results
+// can be sorted, filtered by range and counted by value without looking up the stored documents.
+type docValues struct {
+	values  []string
+	present []bool
+	numbers []float64 // parsed values, nil unless every present value is a number
+}
+
+// newDocValues builds the column of every field of the corpus.
+func newDocValues(corpus []document) map[string]*docValues {
+	columns := make(map[string]*docValues)
+	for id, doc := range corpus {
+		for key, value := range doc.fields {
+			column, ok := columns[key]
+			if !ok {
+				column = &docValues{values: make([]string, len(corpus)), present: make([]bool, len(corpus))}
+				columns[key] = column
+			}
+			column.values[id] = value
+			column.present[id] = true
+		}
+	}
+
+	for _, column := range columns {
+		numbers := make([]float64, len(corpus))
+		for id, value := range column.values {
+			if !column.present[id] {
+				continue
+			}
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				numbers = nil
+				break
+			}
+			numbers[id] = number
+		}
+		column.numbers = numbers
+	}
+	return columns
+}
+
+// less reports whether document a sorts before document b, comparing numbers when every value of
+// the field is numeric and strings otherwise.
+func (c *docValues) less(a, b uint32) bool {
+	if c.numbers != nil {
+		return c.numbers[a] < c.numbers[b]
+	}
+	return c.values[a] < c.values[b]
+}
+
+// fieldRange bounds the values of a field. A bound without an operator is open.
+type fieldRange struct {
+	lower, upper     string
+	lowerOp, upperOp string // > or >=, < or <=
+}
+
+// withBound returns r with the bound of op, an error if r already has a bound on that side.
+func (r fieldRange) withBound(op string, value string) (fieldRange, error) {
+	if strings.HasPrefix(op, ">") {
+		if r.lowerOp != "" {
+			return r, errors.New("more than one lower bound")
+		}
+		r.lower, r.lowerOp = value, op
+	} else {
+		if r.upperOp != "" {
+			return r, errors.New("more than one upper bound")
+		}
+		r.upper, r.upperOp = value, op
+	}
+	return r, nil
+}
+
+// clauses returns the bounds of r as filter clauses on key.
+func (r fieldRange) clauses(key string) []string {
+	var clauses []string
+	if r.lowerOp != "" {
+		clauses = append(clauses, key+r.lowerOp+r.lower)
+	}
+	if r.upperOp != "" {
+		clauses = append(clauses, key+r.upperOp+r.upper)
+	}
+	return clauses
+}
+
+// admits reports whether a value is within r, given its comparisons with the lower and upper bounds.
+func (r fieldRange) admits(lower, upper int) bool {
+	switch {
+	case r.lowerOp == ">" && lower <= 0, r.lowerOp == ">=" && lower < 0:
+		return false
+	case r.upperOp == "<" && upper >= 0, r.upperOp == "<=" && upper > 0:
+		return false
+	}
+	return true
+}
+
+// matchRange returns the documents whose value is within r. Values are compared as numbers when
+// every value of the field and the bounds are numbers, and as strings otherwise.
+func (c *docValues) matchRange(r fieldRange) *roaring.Bitmap {
+	numeric := c.numbers != nil
+	var lower, upper float64
+	var err error
+	if numeric && r.lowerOp != "" {
+		lower, err = strconv.ParseFloat(r.lower, 64)
+		numeric = err == nil
+	}
+	if numeric && r.upperOp != "" {
+		upper, err = strconv.ParseFloat(r.upper, 64)
+		numeric = err == nil
+	}
+
+	set := roaring.New()
+	for id, present := range c.present {
+		if !present {
+			continue
+		}
+		var admitted bool
+		if numeric {
+			admitted = r.admits(cmp.Compare(c.numbers[id], lower), cmp.Compare(c.numbers[id], upper))
+		} else {
+			admitted = r.admits(strings.Compare(c.values[id], r.lower), strings.Compare(c.values[id], r.upper))
+		}
+		if admitted {
+			set.Add(uint32(id))
+		}
+	}
+	return set
+}
+
+// counts returns the number of documents of set with each value of the field.
+func (c *docValues) counts(set *roaring.Bitmap) map[string]uint64 {
+	counts := make(map[string]uint64)
+	for it := set.Iterator(); it.HasNext(); {
+		if id := it.Next(); int(id) < len(c.present) && c.present[id] {
+			counts[c.values[id]]++
+		}
+	}
+	return counts
+}
+
+// sortOrder orders results by the value of a field instead of by score.
+type sortOrder struct {
+	field      string
+	descending bool
+}
+
+// parseSortOrder reads a field name, prefixed with - for descending order.
+func parseSortOrder(s string) (*sortOrder, error) {
+	if s == "" {
+		return nil, nil
+	}
+	order := &sortOrder{field: strings.TrimPrefix(s, "-"), descending: strings.HasPrefix(s, "-")}
+	if order.field == "" {
+		return nil, fmt.Errorf("invalid sort %q, must be a field name optionally prefixed with -", s)
+	}
+	return order, nil
+}
+
+// sortResults orders results by a field. Documents without the field come last, and documents with
+// equal values keep their relative order.
+func (f *fieldIndex) sortResults(results []RankResult, order *sortOrder) {
+	column, ok := f.columns[order.field]
+	if !ok {
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].id, results[j].id
+		if column.present[a] != column.present[b] {
+			return column.present[a]
+		}
+		if !column.present[a] {
+			return false
+		}
+		if order.descending {
+			return column.less(b, a)
+		}
+		return column.less(a, b)
+	})
+}
+
+const (
+	defaultFacetValues = 10
+	maxFacetValues     = 1000
+	maxFacetFields     = 20
+)
+
+type facetCount struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+type facetsResponse struct {
+	Facets map[string][]facetCount `json:"facets"`
+}
+
+// facets counts the documents matching a query and a filter by the values of each field, returning
+// the top most frequent values of each. Without a query or a filter every document is counted.
+func (idx *namedIndex) facets(
+	ctx context.Context, query string, operator Operator, filter *docFilter, fields []string, top int, limits searchLimits,
+) (map[string][]facetCount, error) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if idx.index == nil {
+		return nil, errEmptyIndex
+	}
+	set := roaring.New()
+	if query != "" {
+		res, err := idx.index.Search(ctx, query, ExactSearch, operator, 0, limits)
+		if err != nil {
+			return nil, err
+		}
+		if res.set != nil {
+			set = res.set
+		}
+	} else {
+		set.AddRange(0, uint64(len(idx.corpus)))
+	}
+	if filter != nil {
+		set.And(idx.fields.match(filter))
+	}
+	idx.withoutDeleted(set)
+
+	facets := make(map[string][]facetCount, len(fields))
+	for _, field := range fields {
+		values := make([]facetCount, 0)
+		if column, ok := idx.fields.columns[field]; ok {
+			for value, count := range column.counts(set) {
+				values = append(values, facetCount{Value: value, Count: count})
+			}
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Count != values[j].Count {
+				return values[i].Count > values[j].Count
+			}
+			return values[i].Value < values[j].Value
+		})
+		facets[field] = values[:min(len(values), top)]
+	}
+	return facets, nil
+}
+
+// facetCounts returns the number of matching documents with each value of the requested fields,
+// for the facets of a search form.
+func (a *App) facetCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	query := r.URL.Query()
+	fields := query["field"]
+	if len(fields) == 0 || len(fields) > maxFacetFields {
+		writeError(
+			w, http.StatusBadRequest, errInvalidParameter, fmt.Sprintf("between 1 and %d fields must be given", maxFacetFields),
+		)
+		return
+	}
+	operator, err := parseOperator(query.Get("operator"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	filter, err := parseFilter(query.Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	top := defaultFacetValues
+	if s := query.Get("top"); s != "" {
+		if top, err = strconv.Atoi(s); err != nil || top < 1 || top > maxFacetValues {
+			writeError(
+				w, http.StatusBadRequest, errInvalidParameter,
+				"invalid top "+strconv.Quote(s)+", must be an integer between 1 and "+strconv.Itoa(maxFacetValues),
+			)
+			return
+		}
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+	facets, err := idx.facets(r.Context(), query.Get("query"), operator, filter, fields, top, a.searchLimits)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		writeSearchError(w, err)
+		return
+	}
+	writeResponse(w, r, facetsResponse{Facets: facets})
+}
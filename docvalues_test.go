@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSortResults(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := `{"text": "red chair", "fields": {"price": "120", "name": "b"}}
+{"text": "red red table", "fields": {"price": "9.5", "name": "c"}}
+{"text": "red lamp", "fields": {"name": "a"}}
+{"text": "red sofa", "fields": {"price": "35", "name": "d"}}
+`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	inputs := map[string][]uint32{
+		"sort=price":          {1, 3, 0, 2}, // numeric order, missing values last
+		"sort=-price":         {0, 3, 1, 2},
+		"sort=name":           {2, 0, 1, 3},
+		"sort=-name&size=2":   {3, 1},
+		"sort=price&size=1":   {1},
+		"sort=missing&size=1": {0}, // unknown fields keep the score order
+	}
+	for params, expected := range inputs {
+		ids := searchIds(t, handler, "/v1/indexes/default/search?query=red&"+params)
+		if !slices.Equal(ids, expected) {
+			t.Errorf("ids %v different from expected %v for %s", ids, expected, params)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red&sort=-", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid sort error, got status %d", w.Code)
+	}
+}
+
+func TestRangeFilter(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := `{"text": "red chair", "fields": {"price": "120", "date": "2024-03-01"}}
+{"text": "red red table", "fields": {"price": "9.5", "date": "2023-11-20"}}
+{"text": "red lamp", "fields": {"date": "2024-01-15"}}
+{"text": "red sofa", "fields": {"price": "35", "date": "2024-02-29"}}
+`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	inputs := map[string][]uint32{
+		"price>=35":                        {0, 3}, // numeric, so 120 is above 35
+		"price>9.5,price<120":              {3},
+		"price<=9.5":                       {1},
+		"date>=2024-01-01,date<2024-03-01": {2, 3},
+		"date>2024-02-29,price:120":        {0},
+		"price>1000":                       {},
+		"missing>1":                        {},
+	}
+	for filter, expected := range inputs {
+		ids := searchIds(t, handler, "/v1/indexes/default/search?query=red&filter="+url.QueryEscape(filter))
+		slices.Sort(ids)
+		if !slices.Equal(ids, expected) {
+			t.Errorf("ids %v different from expected %v for filter %q", ids, expected, filter)
+		}
+	}
+
+	for _, filter := range []string{"price>", "price>1,price>=2", ">1"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red&filter="+url.QueryEscape(filter), nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected filter %q to be rejected, got status %d", filter, w.Code)
+		}
+	}
+}
+
+func TestFacetCounts(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := `{"text": "red fox", "fields": {"source": "wiki", "lang": "en"}}
+{"text": "red wine", "fields": {"source": "news", "lang": "en"}}
+{"text": "blue whale", "fields": {"source": "wiki", "lang": "en"}}
+{"text": "red vinho", "fields": {"source": "wiki", "lang": "pt"}}
+`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	facets := func(params string) map[string][]facetCount {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/facets?"+params, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("facets failed with status %d: %s", w.Code, w.Body.String())
+		}
+		var response facetsResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Facets
+	}
+
+	inputs := map[string]map[string][]facetCount{
+		"field=source": {"source": {{"wiki", 3}, {"news", 1}}},
+		"field=source&field=lang&query=red": {
+			"source": {{"wiki", 2}, {"news", 1}},
+			"lang":   {{"en", 2}, {"pt", 1}},
+		},
+		"field=lang&filter=source:wiki&top=1": {"lang": {{"en", 2}}},
+		"field=missing":                       {"missing": {}},
+	}
+	for params, expected := range inputs {
+		if got := facets(params); !reflect.DeepEqual(got, expected) {
+			t.Errorf("facets %v different from expected %v for %s", got, expected, params)
+		}
+	}
+
+	for _, params := range []string{"", "field=lang&top=0", "field=lang&filter=lang"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/facets?"+params, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %q to be rejected, got status %d", params, w.Code)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxDOTNodes is the size of the largest trie that can be exported to DOT, which is meant to
+// visualize small indexes.
+const maxDOTNodes = 10000
+
+// ExportDOT writes the trie as a Graphviz DOT graph. Edges are labeled with their part of the keys,
+// with the end of a key shown as $, and the nodes at which keys end with the number of documents
+// they are in.
+func (f *flatTrie) ExportDOT(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("digraph trie {\n\tnode [shape=point];\n")
+	for n := range f.nodes {
+		node := &f.nodes[n]
+		if node.value >= 0 {
+			fmt.Fprintf(b, "\tn%d [shape=box, label=\"%d\"];\n", n, f.sets[node.value].GetCardinality())
+		}
+		for c := node.firstChild; c < node.firstChild+node.numChildren; c++ {
+			label := strings.ReplaceAll(f.label(c), "\x00", "$")
+			fmt.Fprintf(b, "\tn%d -> n%d [label=%s];\n", n, c, strconv.Quote(label))
+		}
+	}
+	b.WriteString("}\n")
+	return b.Flush()
+}
+
+// ExportDOT writes the trie as a Graphviz DOT graph, as flatTrie.ExportDOT does.
+func (t *PatriciaTrie) ExportDOT(w io.Writer) error {
+	return newFlatTrie(t).ExportDOT(w)
+}
+
+// ExportDOT writes the trie of the index as a Graphviz DOT graph. It fails if the trie has more
+// than maxNodes nodes.
+func (t *trieSearchIndex) ExportDOT(w io.Writer, maxNodes int) error {
+	if len(t.invIndex.nodes) > maxNodes {
+		return fmt.Errorf("the trie has %d nodes, at most %d can be exported", len(t.invIndex.nodes), maxNodes)
+	}
+	return t.invIndex.ExportDOT(w)
+}
+
+// exportTrie returns the trie of an index as a Graphviz DOT graph, for debugging.
+func (a *App) exportTrie(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	searchIndex := idx.index
+	idx.lock.RUnlock()
+
+	if searchIndex == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+	t, ok := searchIndex.(*trieSearchIndex)
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound, fmt.Sprintf("cannot export index of type %T", searchIndex))
+		return
+	}
+
+	var dot strings.Builder
+	if err := t.ExportDOT(&dot, maxDOTNodes); err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	io.WriteString(w, dot.String())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes returned in the error envelope. Clients should match on these rather than on messages.
+const (
+	errMethodNotAllowed   = "method_not_allowed"
+	errInvalidRequest     = "invalid_request"
+	errInvalidParameter   = "invalid_parameter"
+	errNotFound           = "not_found"
+	errIndexNotFound      = "index_not_found"
+	errDocumentNotFound   = "document_not_found"
+	errUploadNotFound     = "upload_not_found"
+	errNoCorpus           = "no_corpus"
+	errIncompatible       = "incompatible_indexes"
+	errPayloadTooLarge    = "payload_too_large"
+	errFetchFailed        = "fetch_failed"
+	errOverloaded         = "overloaded"
+	errInsufficientMemory = "insufficient_memory"
+	errQueryTooBroad      = "query_too_broad"
+	errUnauthorized       = "unauthorized"
+	errQuotaExceeded      = "quota_exceeded"
+	errInternal           = "internal_error"
+)
+
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// writeError sends an error response using the JSON error envelope, which includes the request id
+// set by withRequestID.
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{
+		Error: apiError{Code: code, Message: message, RequestID: w.Header().Get(requestIDHeader)},
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed, use "+allowed)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type searchErrorTest struct {
+	url    string
+	status int
+	code   string
+}
+
+func TestSearchErrors(t *testing.T) {
+	handler := NewApp().Routes()
+	inputs := []searchErrorTest{
+		{"/search?query=foo", http.StatusConflict, errNoCorpus},
+		{"/search?query=foo&type=regex", http.StatusBadRequest, errInvalidParameter},
+		{"/search?query=foo&operator=xor", http.StatusBadRequest, errInvalidParameter},
+		{"/search?query=foo&type=fuzzy&distance=two", http.StatusBadRequest, errInvalidParameter},
+		{"/search?query=foo&type=fuzzy&distance=-1", http.StatusBadRequest, errInvalidParameter},
+		{"/search?query=foo&distance=1", http.StatusBadRequest, errInvalidParameter},
+		{"/v1/indexes/missing/search?query=foo", http.StatusNotFound, errIndexNotFound},
+		{"/v2/search?query=foo", http.StatusNotFound, errNotFound},
+	}
+	for _, input := range inputs {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, input.url, nil))
+
+		if w.Code != input.status {
+			t.Errorf("status %d different from expected %d for %s", w.Code, input.status, input.url)
+		}
+		var resp errorResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid error envelope for %s: %s", input.url, err)
+		}
+		if resp.Error.Code != input.code || resp.Error.Message == "" {
+			t.Errorf("wrong error %+v for %s", resp.Error, input.url)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/search", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("expected 405 with Allow header, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	handler := NewApp().Routes()
+	for sent, kept := range map[string]bool{"abc-123": true, "": false, "not valid": false} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/indexes/missing/search?query=foo", nil)
+		if sent != "" {
+			r.Header.Set(requestIDHeader, sent)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		id := w.Header().Get(requestIDHeader)
+		if kept != (id == sent) || id == "" {
+			t.Errorf("request id %q sent, got %q", sent, id)
+		}
+		var resp errorResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error.RequestID != id {
+			t.Errorf("error envelope has request id %q instead of %q", resp.Error.RequestID, id)
+		}
+	}
+}
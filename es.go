@@ -0,0 +1,904 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// The Elasticsearch compatibility layer serves a subset of the _search and _bulk APIs, so that
+// clients and tools written for Elasticsearch can be pointed at stellr. The text of documents is
+// their "text" field, and their other fields are stored as the fields of stellr documents.
+
+const (
+	esTextField      = "text"
+	esDefaultSize    = 10
+	esProductHeader  = "X-Elastic-Product"
+	esFuzzinessAuto  = 1 // edit distance of fuzziness AUTO, as fuzzy searches use a single distance
+	esMaxFuzziness   = 2
+	esMaxPhraseCheck = 10000 // documents whose text is checked for a phrase before giving up
+)
+
+// errInvalidQuery is returned for queries of the Query DSL that are malformed or not supported.
+var errInvalidQuery = errors.New("invalid query")
+
+// esQuery is a query of the Query DSL. Exactly one of its fields is set.
+type esQuery struct {
+	MatchAll    *struct{}          `json:"match_all"`
+	Match       map[string]esMatch `json:"match"`
+	MatchPhrase map[string]esMatch `json:"match_phrase"`
+	Term        map[string]esMatch `json:"term"`
+	Range       map[string]esRange `json:"range"`
+	Bool        *esBool            `json:"bool"`
+}
+
+// esMatch is the value a field is matched against, given either as a scalar or as an object with
+// a query or value and options.
+type esMatch struct {
+	Query     string
+	Operator  Operator
+	Fuzziness int
+}
+
+func (m *esMatch) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var options struct {
+			Query     json.RawMessage `json:"query"`
+			Value     json.RawMessage `json:"value"`
+			Operator  string          `json:"operator"`
+			Fuzziness json.RawMessage `json:"fuzziness"`
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&options); err != nil {
+			return err
+		}
+		value := options.Query
+		if value == nil {
+			value = options.Value
+		}
+		if err := m.UnmarshalJSON(value); err != nil {
+			return err
+		}
+		var err error
+		if m.Operator, err = parseOperator(options.Operator); err != nil {
+			return err
+		}
+		if m.Fuzziness, err = parseFuzziness(options.Fuzziness); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	query, ok := esScalar(value)
+	if !ok {
+		return fmt.Errorf("query value must be a string, number or boolean")
+	}
+	m.Query = query
+	return nil
+}
+
+func parseFuzziness(data json.RawMessage) (int, error) {
+	if data == nil {
+		return 0, nil
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return 0, err
+	}
+	s, _ := esScalar(value)
+	if s == "AUTO" {
+		return esFuzzinessAuto, nil
+	}
+	fuzziness, err := strconv.Atoi(s)
+	if err != nil || fuzziness < 0 || fuzziness > esMaxFuzziness {
+		return 0, fmt.Errorf("invalid fuzziness %s, must be AUTO or an integer between 0 and %d", data, esMaxFuzziness)
+	}
+	return fuzziness, nil
+}
+
+// esScalar formats a JSON string, number or boolean as a string.
+func esScalar(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// esRange bounds the terms of a range query. At most one lower and one upper bound can be set.
+type esRange struct {
+	Gte json.RawMessage `json:"gte"`
+	Gt  json.RawMessage `json:"gt"`
+	Lte json.RawMessage `json:"lte"`
+	Lt  json.RawMessage `json:"lt"`
+}
+
+// bounds returns the range as the terms from from, inclusive, to to, exclusive, and whether it is
+// bounded above. The smallest string greater than s is s followed by \x00, which turns gt and lte
+// into the bounds of a half-open range.
+func (r esRange) bounds() (from, to string, bounded bool, err error) {
+	bound := func(name string, data json.RawMessage) (string, error) {
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			return "", fmt.Errorf("%w: invalid %s: %s", errInvalidQuery, name, err)
+		}
+		s, ok := esScalar(value)
+		if !ok {
+			return "", fmt.Errorf("%w: %s must be a string, number or boolean", errInvalidQuery, name)
+		}
+		return s, nil
+	}
+	if r.Gte != nil && r.Gt != nil || r.Lte != nil && r.Lt != nil {
+		return "", "", false, fmt.Errorf("%w: a range query can have only one lower and one upper bound", errInvalidQuery)
+	}
+	switch {
+	case r.Gte != nil:
+		from, err = bound("gte", r.Gte)
+	case r.Gt != nil:
+		from, err = bound("gt", r.Gt)
+		from += "\x00"
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	switch {
+	case r.Lt != nil:
+		to, err = bound("lt", r.Lt)
+	case r.Lte != nil:
+		to, err = bound("lte", r.Lte)
+		to += "\x00"
+	}
+	return from, to, r.Lt != nil || r.Lte != nil, err
+}
+
+type esBool struct {
+	Must    esQueries `json:"must"`
+	Filter  esQueries `json:"filter"`
+	Should  esQueries `json:"should"`
+	MustNot esQueries `json:"must_not"`
+}
+
+// esQueries are the clauses of a bool query, given as a single query or as an array of them.
+type esQueries []esQuery
+
+func (q *esQueries) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, (*[]esQuery)(q))
+	}
+	var query esQuery
+	if err := json.Unmarshal(data, &query); err != nil {
+		return err
+	}
+	*q = esQueries{query}
+	return nil
+}
+
+// esEvaluator evaluates queries on an index. It must be used with the index lock held.
+type esEvaluator struct {
+	ctx    context.Context
+	idx    *namedIndex
+	limits searchLimits
+}
+
+// eval returns the documents matching q, which the caller owns, and the terms they are scored with.
+func (s *esEvaluator) eval(q esQuery) (*roaring.Bitmap, []string, error) {
+	var n int
+	for _, present := range []bool{q.MatchAll != nil, q.Match != nil, q.MatchPhrase != nil, q.Term != nil, q.Range != nil, q.Bool != nil} {
+		if present {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, nil, fmt.Errorf("%w: expected exactly one of match_all, match, match_phrase, term, range or bool", errInvalidQuery)
+	}
+
+	var kind string
+	var clause map[string]esMatch
+	switch {
+	case q.MatchAll != nil:
+		return s.all(), nil, nil
+	case q.Bool != nil:
+		return s.evalBool(q.Bool)
+	case q.Range != nil:
+		return s.evalRange(q.Range)
+	case q.Match != nil:
+		kind, clause = "match", q.Match
+	case q.MatchPhrase != nil:
+		kind, clause = "match_phrase", q.MatchPhrase
+	default:
+		kind, clause = "term", q.Term
+	}
+	if len(clause) != 1 {
+		return nil, nil, fmt.Errorf("%w: a %s query must have exactly one field", errInvalidQuery, kind)
+	}
+	var field string
+	var match esMatch
+	for f, m := range clause {
+		field, match = f, m
+	}
+
+	if field != esTextField {
+		// other fields are only stored, so they match their exact value
+		return s.idx.fields.match(&docFilter{values: map[string][]string{field: {match.Query}}}).Clone(), nil, nil
+	}
+	switch kind {
+	case "match":
+		return s.match(match)
+	case "match_phrase":
+		return s.matchPhrase(match)
+	default:
+		// terms are not analyzed by Elasticsearch, but the tokens of the index can only be looked up
+		// through the analyzer
+		return s.match(esMatch{Query: match.Query, Operator: And})
+	}
+}
+
+// evalRange returns the documents with a term in the range. Only the text field is indexed, and
+// ranges are not scored, like in Elasticsearch where every match of a range query scores the same.
+func (s *esEvaluator) evalRange(clause map[string]esRange) (*roaring.Bitmap, []string, error) {
+	if len(clause) != 1 {
+		return nil, nil, fmt.Errorf("%w: a range query must have exactly one field", errInvalidQuery)
+	}
+	var field string
+	var r esRange
+	for f, bounds := range clause {
+		field, r = f, bounds
+	}
+	if field != esTextField {
+		return nil, nil, fmt.Errorf("%w: range queries are only supported on the %s field", errInvalidQuery, esTextField)
+	}
+	from, to, bounded, err := r.bounds()
+	if err != nil {
+		return nil, nil, err
+	}
+	if bounded && to == "" {
+		// no term is below the empty string
+		return roaring.New(), nil, nil
+	}
+	res, err := s.idx.index.SearchRange(s.ctx, from, to, s.limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.set, nil, nil
+}
+
+// all returns every document of the corpus. Vacant ids, of purged documents or kept free for keyed
+// documents missing from the last upload, are left out.
+func (s *esEvaluator) all() *roaring.Bitmap {
+	set := roaring.New()
+	for id, doc := range s.idx.corpus {
+		if !doc.vacant {
+			set.Add(uint32(id))
+		}
+	}
+	return set
+}
+
+func (s *esEvaluator) match(m esMatch) (*roaring.Bitmap, []string, error) {
+	searchType := ExactSearch
+	if m.Fuzziness > 0 {
+		searchType = FuzzySearch
+	}
+	res, err := s.idx.index.Search(s.ctx, m.Query, searchType, m.Operator, m.Fuzziness, s.limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.set == nil {
+		return roaring.New(), nil, nil
+	}
+	return res.set, res.tokens, nil
+}
+
+// matchPhrase returns the documents with all the tokens of the phrase next to each other. Tokens
+// have no positions in the index, so the text of the documents with all the tokens is analyzed.
+func (s *esEvaluator) matchPhrase(m esMatch) (*roaring.Bitmap, []string, error) {
+	set, terms, err := s.match(esMatch{Query: m.Query, Operator: And})
+	if err != nil || set.IsEmpty() {
+		return set, terms, err
+	}
+	phrase, err := s.idx.options.queryAnalyzer().Analyze(m.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n := set.GetCardinality(); n > esMaxPhraseCheck {
+		return nil, nil, fmt.Errorf("%w: checking the phrase in %d documents, narrow the query", errTooManyMatches, n)
+	}
+
+	matches := roaring.New()
+	for it := set.Iterator(); it.HasNext(); {
+		id := it.Next()
+		if err := s.ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		tokens, err := s.idx.options.analyzer.Analyze(s.idx.corpus[id].text)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i+len(phrase) <= len(tokens); i++ {
+			if slices.Equal(tokens[i:i+len(phrase)], phrase) {
+				matches.Add(id)
+				break
+			}
+		}
+	}
+	return matches, terms, nil
+}
+
+// evalBool combines the clauses of a bool query. Documents must match every must and filter
+// clause and no must_not clause, and at least one should clause if there are no must or filter
+// clauses. Only must and should clauses are scored.
+func (s *esEvaluator) evalBool(b *esBool) (*roaring.Bitmap, []string, error) {
+	var set *roaring.Bitmap // nil until a clause restricts the documents
+	var terms []string
+	intersect := func(clauses esQueries, scored bool) error {
+		for _, clause := range clauses {
+			matches, clauseTerms, err := s.eval(clause)
+			if err != nil {
+				return err
+			}
+			if set == nil {
+				set = matches
+			} else {
+				set.And(matches)
+			}
+			if scored {
+				terms = append(terms, clauseTerms...)
+			}
+		}
+		return nil
+	}
+	if err := intersect(b.Must, true); err != nil {
+		return nil, nil, err
+	}
+	if err := intersect(b.Filter, false); err != nil {
+		return nil, nil, err
+	}
+
+	if len(b.Should) > 0 {
+		should := roaring.New()
+		for _, clause := range b.Should {
+			matches, clauseTerms, err := s.eval(clause)
+			if err != nil {
+				return nil, nil, err
+			}
+			should.Or(matches)
+			terms = append(terms, clauseTerms...)
+		}
+		if set == nil {
+			set = should
+		}
+	}
+
+	if set == nil {
+		set = s.all()
+	}
+	for _, clause := range b.MustNot {
+		matches, _, err := s.eval(clause)
+		if err != nil {
+			return nil, nil, err
+		}
+		set.AndNot(matches)
+	}
+	return set, terms, nil
+}
+
+type esSearchRequest struct {
+	Query *esQuery `json:"query"`
+	From  int      `json:"from"`
+	Size  *int     `json:"size"`
+}
+
+type esSearchResponse struct {
+	Took     int64    `json:"took"`
+	TimedOut bool     `json:"timed_out"`
+	Shards   esShards `json:"_shards"`
+	Hits     esHits   `json:"hits"`
+}
+
+type esShards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+type esHits struct {
+	Total    esTotal  `json:"total"`
+	MaxScore *float64 `json:"max_score"`
+	Hits     []esHit  `json:"hits"`
+}
+
+type esTotal struct {
+	Value    uint64 `json:"value"`
+	Relation string `json:"relation"`
+}
+
+type esHit struct {
+	Index  string         `json:"_index"`
+	ID     string         `json:"_id"`
+	Score  *float64       `json:"_score"`
+	Source map[string]any `json:"_source"`
+}
+
+// esID returns the Elasticsearch id of a document, which is its key if it has one.
+func esID(doc document, id uint32) string {
+	if doc.key != "" {
+		return doc.key
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// parseESSearch reads a search from the request body, or from the q, from and size parameters of
+// the URL if there is no body.
+func parseESSearch(r *http.Request) (esSearchRequest, error) {
+	req := esSearchRequest{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid search: %w", err)
+		}
+	}
+
+	query := r.URL.Query()
+	if q := query.Get("q"); q != "" {
+		req.Query = &esQuery{Match: map[string]esMatch{esTextField: {Query: q}}}
+	}
+	if s := query.Get("from"); s != "" {
+		if req.From, err = strconv.Atoi(s); err != nil {
+			return req, fmt.Errorf("invalid from %q, must be an integer", s)
+		}
+	}
+	if s := query.Get("size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil {
+			return req, fmt.Errorf("invalid size %q, must be an integer", s)
+		}
+		req.Size = &size
+	}
+	if req.From < 0 || (req.Size != nil && *req.Size < 0) {
+		return req, errors.New("from and size must not be negative")
+	}
+	if req.Query == nil {
+		req.Query = &esQuery{MatchAll: &struct{}{}}
+	}
+	return req, nil
+}
+
+// esSearch serves the _search endpoint of an index.
+func (a *App) esSearch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, "GET, POST")
+		return
+	}
+	w.Header().Set(esProductHeader, "Elasticsearch")
+
+	a.limitBody(w, r)
+	req, err := parseESSearch(r)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+	params := searchParams{size: esDefaultSize}
+	if req.Size != nil {
+		params.size = *req.Size
+	}
+	a.limitSearch(&params)
+	if params.size > 0 {
+		if maxSize := a.limitSize(&params, idx); maxSize > 0 {
+			w.Header().Set(maxSizeHeader, strconv.Itoa(maxSize))
+		}
+	}
+
+	response, err := idx.esSearch(r.Context(), req, params)
+	if r.Context().Err() != nil {
+		return
+	}
+	if errors.Is(err, errInvalidQuery) {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	if err != nil {
+		writeSearchError(w, err)
+		return
+	}
+	response.Took = time.Since(start).Milliseconds()
+	writeResponse(w, r, response)
+	a.logQuery(r, indexName(r), start, len(response.Hits.Hits))
+}
+
+// esSearch runs a search and returns the hits from req.From to req.From+params.size.
+func (idx *namedIndex) esSearch(ctx context.Context, req esSearchRequest, params searchParams) (esSearchResponse, error) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	response := esSearchResponse{
+		Shards: esShards{Total: 1, Successful: 1},
+		Hits:   esHits{Total: esTotal{Relation: "eq"}, Hits: make([]esHit, 0)},
+	}
+	if idx.index == nil {
+		return response, errEmptyIndex
+	}
+
+	s := &esEvaluator{ctx: ctx, idx: idx, limits: params.limits}
+	set, terms, err := s.eval(*req.Query)
+	if err != nil {
+		return response, err
+	}
+	idx.withoutDeleted(set)
+	response.Hits.Total.Value = set.GetCardinality()
+	if params.size == 0 {
+		return response, nil
+	}
+
+	k := req.From + params.size
+	reserved, err := idx.reserveResults(set, searchParams{size: k})
+	if err != nil {
+		return response, err
+	}
+	defer idx.breaker.release(reserved)
+
+	var ranked []RankResult
+	unscored := set
+	if len(terms) > 0 {
+		if ranked, err = idx.index.RankTop(ctx, terms, set, k); err != nil {
+			return response, err
+		}
+		unscored = set.Clone()
+		for _, res := range ranked {
+			unscored.Remove(res.id)
+		}
+	}
+	// documents that are only filtered, or match no scored term, rank last with the same score
+	score := 1.0
+	if len(terms) > 0 {
+		score = 0
+	}
+	for it := unscored.Iterator(); it.HasNext() && len(ranked) < k; {
+		ranked = append(ranked, RankResult{id: it.Next(), score: score})
+	}
+	if len(ranked) > 0 {
+		response.Hits.MaxScore = &ranked[0].score
+	}
+
+	for _, res := range ranked[min(req.From, len(ranked)):] {
+		doc := idx.corpus[res.id]
+		source := make(map[string]any, len(doc.fields)+1)
+		for field, value := range doc.fields {
+			source[field] = value
+		}
+		source[esTextField] = doc.text
+		score := res.score
+		response.Hits.Hits = append(response.Hits.Hits, esHit{Index: idx.name, ID: esID(doc, res.id), Score: &score, Source: source})
+	}
+	return response, nil
+}
+
+// esBulkAction is an action of a bulk request, along with the document of index and create actions.
+type esBulkAction struct {
+	kind  string // index, create or delete
+	index string
+	id    string
+	doc   document
+	err   error // why the action cannot be applied, set while parsing
+}
+
+type esBulkItem struct {
+	Index  string       `json:"_index"`
+	ID     string       `json:"_id,omitempty"`
+	Status int          `json:"status"`
+	Result string       `json:"result,omitempty"`
+	Error  *esBulkError `json:"error,omitempty"`
+}
+
+type esBulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type esBulkResponse struct {
+	Took   int64                   `json:"took"`
+	Errors bool                    `json:"errors"`
+	Items  []map[string]esBulkItem `json:"items"`
+}
+
+// parseESBulk reads the actions of a bulk request, each an action line followed by a document line
+// for index and create actions. defaultIndex is the index of actions without an _index.
+func parseESBulk(body io.Reader, defaultIndex string, maxLineSize int) ([]esBulkAction, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, min(64<<10, maxLineSize)), maxLineSize)
+	actions := make([]esBulkAction, 0)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var line map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || len(line) != 1 {
+			return nil, fmt.Errorf("line %d: expected an action such as {\"index\": {\"_id\": \"1\"}}", lineNumber)
+		}
+		for kind, meta := range line {
+			action := esBulkAction{kind: kind, index: meta.Index, id: meta.ID}
+			if action.index == "" {
+				action.index = defaultIndex
+			}
+			switch {
+			case kind != "index" && kind != "create" && kind != "delete":
+				action.err = fmt.Errorf("action %s is not supported", kind)
+			case action.index == "":
+				action.err = errors.New("no index given")
+			case !indexNamePattern.MatchString(action.index):
+				action.err = errors.New("invalid index name, use up to 64 lowercase letters, digits, '-' or '_'")
+			case kind == "delete" && action.id == "":
+				action.err = errors.New("delete needs an _id")
+			}
+			if kind == "index" || kind == "create" || kind == "update" {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: missing document of %s action", lineNumber, kind)
+				}
+				lineNumber++
+				if action.err == nil {
+					action.doc, action.err = parseESDocument(scanner.Bytes())
+					action.doc.key = action.id
+				}
+			}
+			actions = append(actions, action)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// parseESDocument reads the source of a document. Its text is the text field, and the other fields
+// must be strings, numbers or booleans.
+func parseESDocument(line []byte) (document, error) {
+	var source map[string]any
+	if err := json.Unmarshal(line, &source); err != nil {
+		return document{}, fmt.Errorf("invalid document: %w", err)
+	}
+	text, ok := source[esTextField].(string)
+	if !ok || text == "" {
+		return document{}, fmt.Errorf("missing %s field", esTextField)
+	}
+	doc := document{text: text}
+	for field, value := range source {
+		if field == esTextField {
+			continue
+		}
+		s, ok := esScalar(value)
+		if !ok {
+			return document{}, fmt.Errorf("field %s must be a string, number or boolean", field)
+		}
+		if doc.fields == nil {
+			doc.fields = make(map[string]string)
+		}
+		doc.fields[field] = s
+	}
+	return doc, nil
+}
+
+// esBulk serves the _bulk endpoint, which indexes and deletes documents of one or more indexes.
+// Indexing a document with the _id of an existing one replaces it.
+func (a *App) esBulk(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		methodNotAllowed(w, "POST, PUT")
+		return
+	}
+	w.Header().Set(esProductHeader, "Elasticsearch")
+	if name := r.PathValue("name"); name != "" && !validIndexName(w, r) {
+		return
+	}
+
+	a.limitBody(w, r)
+	body, err := io.ReadAll(r.Body)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error reading request body: "+err.Error())
+		return
+	}
+	size := int64(len(body))
+	if !a.reserveUpload(w, size) {
+		return
+	}
+	defer a.memory.release(size * uploadMemoryFactor)
+	if !a.meterIndexing(w, r, size) {
+		return
+	}
+
+	actions, err := parseESBulk(bytes.NewReader(body), r.PathValue("name"), a.limits.maxLineSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+	// new indexes are created with the settings given in the URL
+	options, err := parseIndexOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+
+	items := make([]esBulkItem, len(actions))
+	byIndex := make(map[string][]int)
+	var names []string
+	for i, action := range actions {
+		if action.err != nil {
+			items[i] = esBulkItem{Index: action.index, ID: action.id, Status: http.StatusBadRequest, Error: esError(action.err)}
+			continue
+		}
+		if byIndex[action.index] == nil {
+			names = append(names, action.index)
+		}
+		byIndex[action.index] = append(byIndex[action.index], i)
+	}
+	for _, name := range names {
+		if err := a.getOrCreateIndex(name).bulk(r, actions, byIndex[name], items, options); err != nil {
+			writeError(w, http.StatusBadRequest, errInvalidRequest, "index "+name+": "+err.Error())
+			return
+		}
+	}
+
+	response := esBulkResponse{Items: make([]map[string]esBulkItem, len(actions))}
+	for i, item := range items {
+		response.Items[i] = map[string]esBulkItem{actions[i].kind: item}
+		response.Errors = response.Errors || item.Error != nil
+	}
+	response.Took = time.Since(start).Milliseconds()
+	writeResponse(w, r, response)
+}
+
+func esError(err error) *esBulkError {
+	return &esBulkError{Type: "illegal_argument_exception", Reason: err.Error()}
+}
+
+// bulk applies the actions of a bulk request at positions of actions to the index, in order, and
+// fills their items. A new version of the index is built with the new documents merged after the
+// current ones, and replaced and deleted documents are marked as deleted. options are the settings
+// of the index if it is empty, and r is the bulk request.
+func (idx *namedIndex) bulk(r *http.Request, actions []esBulkAction, positions []int, items []esBulkItem, options IndexOptions) error {
+	for {
+		idx.lock.RLock()
+		index, corpus, duplicates := idx.index, idx.corpus, idx.duplicates
+		deleted := roaring.New()
+		if idx.deleted != nil {
+			deleted = idx.deleted.Clone()
+		}
+		if index != nil {
+			options = idx.options
+		}
+		idx.lock.RUnlock()
+
+		// ids of the live documents by _id
+		ids := make(map[string]uint32)
+		for id, doc := range corpus {
+			if !deleted.Contains(uint32(id)) {
+				ids[esID(doc, uint32(id))] = uint32(id)
+			}
+		}
+		build := newIndexBuild(options)
+		offset := uint32(len(corpus))
+		for _, i := range positions {
+			action := actions[i]
+			item := esBulkItem{Index: idx.name, ID: action.id}
+			id, exists := ids[action.id]
+			switch {
+			case action.kind == "delete" && !exists:
+				item.Status, item.Result = http.StatusNotFound, "not_found"
+			case action.kind == "delete":
+				deleted.Add(id)
+				delete(ids, action.id)
+				item.Status, item.Result = http.StatusOK, "deleted"
+			case action.kind == "create" && exists:
+				item.Status = http.StatusConflict
+				item.Error = &esBulkError{Type: "version_conflict_engine_exception", Reason: "document " + action.id + " already exists"}
+			default:
+				if err := build.add(action.doc, action.doc.text); err != nil {
+					return err
+				}
+				newID := offset + uint32(len(build.corpus)-1)
+				item.Status, item.Result = http.StatusCreated, "created"
+				if exists {
+					deleted.Add(id)
+					item.Status, item.Result = http.StatusOK, "updated"
+				}
+				if action.id == "" {
+					item.ID = strconv.FormatUint(uint64(newID), 10)
+				} else {
+					ids[action.id] = newID
+				}
+			}
+			items[i] = item
+		}
+
+		updated := index
+		if len(build.corpus) > 0 {
+			if uint64(offset)+uint64(len(build.corpus)) > maxDocuments {
+				return tooManyDocumentsError()
+			}
+			added := build.builder.Build()
+			if index == nil {
+				updated = added
+			} else {
+				var err error
+				if updated, err = MergeIndexes(index, added); err != nil {
+					return err
+				}
+			}
+		}
+		if updated == nil {
+			// only deletions of documents that do not exist
+			return nil
+		}
+		corpus = append(slices.Clone(corpus), build.corpus...)
+		// deleted documents keep their id until they are purged, but not their _id
+		for it := deleted.Iterator(); it.HasNext(); {
+			corpus[it.Next()] = document{vacant: true}
+		}
+		fields := newFieldIndex(corpus)
+		expirations := newExpirations(corpus)
+		memory := estimateMemory(updated, corpus)
+
+		idx.lock.Lock()
+		if idx.index != index {
+			// the index was replaced meanwhile, so the actions are applied to the new version
+			idx.lock.Unlock()
+			continue
+		}
+		idx.index = updated
+		idx.corpus = corpus
+		idx.fields = fields
+		idx.duplicates = duplicates
+		idx.expirations = expirations
+		idx.options = options
+		idx.setMemory(memory)
+		idx.deleted = nil
+		if idx.clicks == nil {
+			idx.clicks = newClickFeedback()
+		}
+		if !deleted.IsEmpty() {
+			idx.markDeleted(r, deleted.ToArray()...)
+		}
+		idx.lock.Unlock()
+		return nil
+	}
+}
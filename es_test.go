@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func esRequest(t *testing.T, handler http.Handler, method, url, body string, v any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+func esSearchIds(t *testing.T, handler http.Handler, query string) []string {
+	t.Helper()
+	var response esSearchResponse
+	if code := esRequest(t, handler, http.MethodPost, "/books/_search", query, &response); code != http.StatusOK {
+		t.Fatalf("search %s failed with status %d", query, code)
+	}
+	ids := make([]string, len(response.Hits.Hits))
+	for i, hit := range response.Hits.Hits {
+		ids[i] = hit.ID
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestESBulkAndSearch(t *testing.T) {
+	handler := NewApp().Routes()
+	bulk := `{"index": {"_id": "1"}}
+{"text": "the quick brown fox", "lang": "en"}
+{"index": {"_id": "2"}}
+{"text": "brown bear in the woods", "lang": "en"}
+{"create": {"_id": "3"}}
+{"text": "le renard brun", "lang": "fr"}
+`
+	var response esBulkResponse
+	if code := esRequest(t, handler, http.MethodPost, "/books/_bulk", bulk, &response); code != http.StatusOK {
+		t.Fatalf("bulk failed with status %d", code)
+	}
+	if response.Errors || len(response.Items) != 3 || response.Items[2]["create"].Status != http.StatusCreated {
+		t.Fatalf("unexpected bulk response %+v", response)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{`{"query": {"match_all": {}}}`, []string{"1", "2", "3"}},
+		{`{"query": {"match": {"text": "brown"}}}`, []string{"1", "2"}},
+		{`{"query": {"match": {"text": {"query": "brown woods", "operator": "and"}}}}`, []string{"2"}},
+		{`{"query": {"match": {"text": {"query": "bown", "fuzziness": "AUTO"}}}}`, []string{"1", "2"}},
+		{`{"query": {"term": {"lang": "fr"}}}`, []string{"3"}},
+		{`{"query": {"match_phrase": {"text": "brown fox"}}}`, []string{"1"}},
+		{`{"query": {"match_phrase": {"text": "fox brown"}}}`, []string{}},
+		{`{"query": {"bool": {"must": {"match": {"text": "brown"}}, "must_not": [{"match": {"text": "fox"}}]}}}`, []string{"2"}},
+		{`{"query": {"bool": {"should": [{"match": {"text": "fox"}}, {"term": {"lang": "fr"}}]}}}`, []string{"1", "3"}},
+		{`{"query": {"bool": {"filter": {"term": {"lang": "en"}}, "should": {"match": {"text": "woods"}}}}}`, []string{"1", "2"}},
+	}
+	for _, test := range tests {
+		if ids := esSearchIds(t, handler, test.query); !slices.Equal(ids, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.expected, ids)
+		}
+	}
+
+	var search esSearchResponse
+	esRequest(t, handler, http.MethodGet, "/books/_search?q=fox", "", &search)
+	if search.Hits.Total.Value != 1 || search.Hits.Hits[0].Source["text"] != "the quick brown fox" {
+		t.Errorf("unexpected search response %+v", search)
+	}
+	if code := esRequest(t, handler, http.MethodPost, "/books/_search", `{"query": {"fuzzy": {}}}`, &search); code != http.StatusBadRequest {
+		t.Errorf("expected an unsupported query to be rejected, got status %d", code)
+	}
+
+	bulk = `{"index": {"_index": "books", "_id": "1"}}
+{"text": "a red fox"}
+{"delete": {"_index": "books", "_id": "2"}}
+{"create": {"_index": "books", "_id": "3"}}
+{"text": "duplicate"}
+{"delete": {"_index": "books", "_id": "9"}}
+`
+	// a new response, as decoding into the previous one would merge its items
+	var updates esBulkResponse
+	if code := esRequest(t, handler, http.MethodPost, "/_bulk", bulk, &updates); code != http.StatusOK {
+		t.Fatalf("bulk failed with status %d", code)
+	}
+	statuses := make([]int, len(updates.Items))
+	for i, item := range updates.Items {
+		for _, result := range item {
+			statuses[i] = result.Status
+		}
+	}
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusConflict, http.StatusNotFound}
+	if !updates.Errors || !slices.Equal(statuses, expected) {
+		t.Errorf("expected statuses %v, got %v", expected, statuses)
+	}
+	if ids := esSearchIds(t, handler, `{"query": {"match": {"text": "brown"}}}`); len(ids) != 0 {
+		t.Errorf("expected replaced and deleted documents to be gone, got %v", ids)
+	}
+	if ids := esSearchIds(t, handler, `{"query": {"match": {"text": "red"}}}`); !slices.Equal(ids, []string{"1"}) {
+		t.Errorf("expected the replaced document, got %v", ids)
+	}
+}
+
+func TestESRangeQuery(t *testing.T) {
+	handler := NewApp().Routes()
+	bulk := `{"index": {"_id": "1"}}
+{"text": "released in 1999"}
+{"index": {"_id": "2"}}
+{"text": "released in 2004"}
+{"index": {"_id": "3"}}
+{"text": "released in 2011"}
+{"index": {"_id": "4"}}
+{"text": "released in 2024"}
+`
+	var response esBulkResponse
+	if code := esRequest(t, handler, http.MethodPost, "/books/_bulk", bulk, &response); code != http.StatusOK {
+		t.Fatalf("bulk failed with status %d", code)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{`{"query": {"range": {"text": {"gte": "2000", "lt": "2020"}}}}`, []string{"2", "3"}},
+		{`{"query": {"range": {"text": {"gt": 2004, "lte": 2024}}}}`, []string{"3", "4"}},
+		{`{"query": {"range": {"text": {"lt": "2000"}}}}`, []string{"1"}},
+		{`{"query": {"range": {"text": {"lt": ""}}}}`, []string{}},
+		{`{"query": {"bool": {"filter": {"range": {"text": {"gte": "2000", "lt": "3000"}}}, "must_not": {"match": {"text": "2024"}}}}}`, []string{"2", "3"}},
+	}
+	for _, test := range tests {
+		if ids := esSearchIds(t, handler, test.query); !slices.Equal(ids, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.expected, ids)
+		}
+	}
+
+	for _, query := range []string{
+		`{"query": {"range": {"lang": {"gte": "en"}}}}`,
+		`{"query": {"range": {"text": {"gte": "2000", "gt": "2000"}}}}`,
+		`{"query": {"range": {"text": {"gte": ["2000"]}}}}`,
+	} {
+		var search esSearchResponse
+		if code := esRequest(t, handler, http.MethodPost, "/books/_search", query, &search); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
+
+func TestESMatchAllAfterKeyedUpload(t *testing.T) {
+	handler := NewApp().Routes()
+	upload := func(corpus string) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/books/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	upload(`{"key": "a", "text": "red fox"}
+{"key": "b", "text": "blue whale"}
+{"key": "c", "text": "red bird"}
+`)
+	// a and b keep their ids, vacant, until they are uploaded again
+	upload(`{"key": "c", "text": "red bird"}` + "\n")
+
+	for _, query := range []string{
+		`{"query": {"match_all": {}}}`,
+		`{"query": {"bool": {"must_not": {"match": {"text": "whale"}}}}}`,
+	} {
+		if ids := esSearchIds(t, handler, query); !slices.Equal(ids, []string{"c"}) {
+			t.Errorf("%s: expected only the uploaded document, got %v", query, ids)
+		}
+	}
+}
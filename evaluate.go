@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultEvalDepth = 10
+	maxEvalDepth     = 1000
+)
+
+// qrels maps a query id to the relevance grade of each judged document.
+type qrels map[string]map[uint32]int
+
+// parseQrels reads relevance judgments in the TREC qrels format: one "query_id iteration doc_id relevance"
+// line per judgment. Document ids are the ids returned by search.
+func parseQrels(r io.Reader) (qrels, error) {
+	judgments := make(qrels)
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("qrels line %d: expected 4 fields, got %d", lineNumber, len(fields))
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("qrels line %d: invalid document id %q", lineNumber, fields[2])
+		}
+		relevance, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("qrels line %d: invalid relevance %q", lineNumber, fields[3])
+		}
+		if judgments[fields[0]] == nil {
+			judgments[fields[0]] = make(map[uint32]int)
+		}
+		judgments[fields[0]][uint32(id)] = relevance
+	}
+	return judgments, scanner.Err()
+}
+
+type evalQuery struct {
+	id   string
+	text string
+}
+
+// parseEvalQueries reads one "query_id query text" line per query.
+func parseEvalQueries(r io.Reader) ([]evalQuery, error) {
+	queries := make([]evalQuery, 0)
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		id := strings.Fields(line)[0]
+		text := strings.TrimSpace(line[len(id):])
+		if text == "" {
+			return nil, fmt.Errorf("queries line %d: expected a query id followed by the query text", lineNumber)
+		}
+		queries = append(queries, evalQuery{id: id, text: text})
+	}
+	return queries, scanner.Err()
+}
+
+type evalMetrics struct {
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	ReciprocalRank float64 `json:"reciprocal_rank"`
+	NDCG           float64 `json:"ndcg"`
+}
+
+type queryEvaluation struct {
+	Id string `json:"id"`
+	evalMetrics
+}
+
+type evaluationResponse struct {
+	K        int               `json:"k"`
+	Queries  int               `json:"queries"`
+	Mean     evalMetrics       `json:"mean"`
+	PerQuery []queryEvaluation `json:"per_query"`
+}
+
+// evaluateRanking computes precision and NDCG at depth k, recall at depth k and the reciprocal rank
+// of the first relevant document. Documents with a relevance grade above zero are relevant.
+func evaluateRanking(ranking []uint32, judgments map[uint32]int, k int) evalMetrics {
+	var metrics evalMetrics
+	var relevantDocs int
+	grades := make([]int, 0, len(judgments))
+	for _, grade := range judgments {
+		if grade > 0 {
+			relevantDocs++
+			grades = append(grades, grade)
+		}
+	}
+	if relevantDocs == 0 {
+		return metrics
+	}
+
+	var retrieved int
+	var dcg float64
+	for i, id := range ranking[:min(len(ranking), k)] {
+		grade := judgments[id]
+		if grade <= 0 {
+			continue
+		}
+		retrieved++
+		dcg += gain(grade, i)
+		if metrics.ReciprocalRank == 0 {
+			metrics.ReciprocalRank = 1 / float64(i+1)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(grades)))
+	var idealDcg float64
+	for i, grade := range grades[:min(len(grades), k)] {
+		idealDcg += gain(grade, i)
+	}
+
+	metrics.Precision = float64(retrieved) / float64(k)
+	metrics.Recall = float64(retrieved) / float64(relevantDocs)
+	metrics.NDCG = dcg / idealDcg
+	return metrics
+}
+
+// gain is the discounted gain of a document with the given grade at a 0-based rank.
+func gain(grade int, rank int) float64 {
+	return (math.Pow(2, float64(grade)) - 1) / math.Log2(float64(rank+2))
+}
+
+func (a *App) evaluate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	k := defaultEvalDepth
+	if s := r.URL.Query().Get("k"); s != "" {
+		var err error
+		k, err = strconv.Atoi(s)
+		if err != nil || k < 1 || k > maxEvalDepth {
+			writeError(
+				w, http.StatusBadRequest, errInvalidParameter,
+				"invalid k "+strconv.Quote(s)+", must be an integer between 1 and "+strconv.Itoa(maxEvalDepth),
+			)
+			return
+		}
+	}
+
+	searchType, err := parseSearchType(r.URL.Query().Get("type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	operator, err := parseOperator(r.URL.Query().Get("operator"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	dist, err := parseDistance(r.URL.Query().Get("distance"), searchType)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+
+	a.limitBody(w, r)
+	err = r.ParseMultipartForm(maxFormMemory)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error parsing form: "+err.Error())
+		return
+	}
+
+	qrelsFile, _, err := r.FormFile("qrels")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error retrieving the qrels file: "+err.Error())
+		return
+	}
+	defer qrelsFile.Close()
+	judgments, err := parseQrels(qrelsFile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+
+	queriesFile, _, err := r.FormFile("queries")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error retrieving the queries file: "+err.Error())
+		return
+	}
+	defer queriesFile.Close()
+	queries, err := parseEvalQueries(queriesFile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if idx.index == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+
+	result := evaluationResponse{K: k, PerQuery: make([]queryEvaluation, 0, len(queries))}
+	for _, query := range queries {
+		// queries without judgments cannot be evaluated
+		if _, ok := judgments[query.id]; !ok {
+			continue
+		}
+
+		searchResult, err := idx.index.Search(r.Context(), query.text, searchType, operator, dist, a.searchLimits)
+		if err != nil {
+			writeSearchError(w, err)
+			return
+		}
+		idx.withoutDeleted(searchResult.set)
+		// weighted like the results of the search endpoint
+		multipliers := searchResult.expansionMultipliers(defaultExpansionPenalty)
+		ranked, err := idx.index.RankWeighted(r.Context(), searchResult.tokens, multipliers, searchResult.set, 0)
+		if err != nil {
+			writeSearchError(w, err)
+			return
+		}
+		ranking := make([]uint32, len(ranked))
+		for i, res := range ranked {
+			ranking[i] = res.id
+		}
+
+		metrics := evaluateRanking(ranking, judgments[query.id], k)
+		result.PerQuery = append(result.PerQuery, queryEvaluation{Id: query.id, evalMetrics: metrics})
+		result.Mean.Precision += metrics.Precision
+		result.Mean.Recall += metrics.Recall
+		result.Mean.ReciprocalRank += metrics.ReciprocalRank
+		result.Mean.NDCG += metrics.NDCG
+	}
+
+	result.Queries = len(result.PerQuery)
+	if result.Queries > 0 {
+		n := float64(result.Queries)
+		result.Mean.Precision /= n
+		result.Mean.Recall /= n
+		result.Mean.ReciprocalRank /= n
+		result.Mean.NDCG /= n
+	}
+	writeResponse(w, r, result)
+}
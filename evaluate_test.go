@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvaluateRanking(t *testing.T) {
+	judgments := map[uint32]int{1: 2, 3: 1, 5: 0}
+	metrics := evaluateRanking([]uint32{4, 3, 1, 5}, judgments, 2)
+	if metrics.Precision != 0.5 || metrics.Recall != 0.5 || metrics.ReciprocalRank != 0.5 {
+		t.Errorf("wrong metrics %+v", metrics)
+	}
+	// DCG of grade 1 at rank 2, ideal DCG of grades 2 and 1 at ranks 1 and 2
+	expectedNDCG := (1 / math.Log2(3)) / (3 + 1/math.Log2(3))
+	if math.Abs(metrics.NDCG-expectedNDCG) > 1e-9 {
+		t.Errorf("wrong NDCG %v, expected %v", metrics.NDCG, expectedNDCG)
+	}
+
+	if metrics = evaluateRanking([]uint32{1, 3}, judgments, 10); metrics.NDCG != 1 || metrics.Recall != 1 {
+		t.Errorf("expected a perfect ranking, got %+v", metrics)
+	}
+	if metrics = evaluateRanking([]uint32{1}, map[uint32]int{1: 0}, 10); metrics != (evalMetrics{}) {
+		t.Errorf("expected zero metrics without relevant documents, got %+v", metrics)
+	}
+}
+
+func evaluateRequest(target string, queries string, qrels string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("qrels", "qrels.txt")
+	part.Write([]byte(qrels))
+	part, _ = writer.CreateFormFile("queries", "queries.txt")
+	part.Write([]byte(queries))
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestEvaluate(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred fox jumps high\nblue whale\n"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, evaluateRequest(
+		"/v1/indexes/default/evaluate?k=2", "q1 red fox\nq2\tblue\nq3 unjudged query\n", "q1 0 0 1\nq1 0 1 1\nq2 0 2 1\n",
+	))
+	if w.Code != http.StatusOK {
+		t.Fatalf("evaluation failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	var result evaluationResponse
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Queries != 2 || len(result.PerQuery) != 2 {
+		t.Fatalf("expected 2 evaluated queries, got %+v", result)
+	}
+	if result.Mean.Recall != 1 || result.Mean.ReciprocalRank != 1 || result.Mean.NDCG != 1 {
+		t.Errorf("wrong mean metrics %+v", result.Mean)
+	}
+	if result.PerQuery[1].Id != "q2" || result.PerQuery[1].Precision != 0.5 {
+		t.Errorf("wrong metrics for q2 %+v", result.PerQuery[1])
+	}
+}
+
+func TestEvaluateShortPrefix(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus?min_prefix_length=3", "red fox\nblue whale\n"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, evaluateRequest("/v1/indexes/default/evaluate?type=prefix", "q1 r\n", "q1 0 0 1\n"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a short prefix, got %d: %s", w.Code, w.Body.String())
+	}
+}
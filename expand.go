@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+const (
+	defaultExpandDocs   = 10
+	defaultExpandTerms  = 10
+	defaultExpandWeight = 0.5
+	maxExpandDocs       = 100
+	maxExpandTerms      = 100
+)
+
+// Expand returns the n terms with the highest weight in the documents of top, other than tokens, as
+// the tokens of a result with the documents containing any of them. It returns nil if there are no
+// such terms. The weights of each document are divided by their norm, so that long documents do not
+// choose most terms.
+func (t *trieSearchIndex) Expand(tokens []string, top []RankResult, n int) *IndexResult {
+	query := make(map[uint32]bool, len(tokens))
+	for _, token := range tokens {
+		if id, ok := t.vocabulary.id(token); ok {
+			query[id] = true
+		}
+	}
+	sums := make(map[uint32]float64)
+	for _, res := range top {
+		norm := t.weightNorm(res.id)
+		if norm == 0 {
+			continue
+		}
+		doc := t.docEntries[res.id]
+		for i, id := range doc.ids {
+			if !query[id] {
+				sums[id] += doc.weights[i] / norm
+			}
+		}
+	}
+	if len(sums) == 0 {
+		return nil
+	}
+
+	terms := make([]keyword, 0, len(sums))
+	for id, sum := range sums {
+		terms = append(terms, keyword{Term: t.vocabulary.token(id), Weight: sum})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Weight != terms[j].Weight {
+			return terms[i].Weight > terms[j].Weight
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	terms = terms[:min(len(terms), n)]
+
+	result := &IndexResult{tokens: make([]string, len(terms))}
+	sets := make([]*roaring.Bitmap, 0, len(terms))
+	for i, term := range terms {
+		result.tokens[i] = term.Term
+		if res := t.invIndex.Search(term.Term); res != nil {
+			sets = append(sets, res.set)
+		}
+	}
+	result.set = roaring.FastOr(sets...)
+	return result
+}
+
+// expand runs the pseudo-relevance feedback of a search: it returns the best terms of its top
+// params.expandDocs results, with their documents left after filters. With the Or operator, these
+// documents are added to the matches of the search, so that documents using other words for the
+// same topic can be found.
+func (idx *namedIndex) expand(ctx context.Context, params searchParams, result *IndexResult) (*IndexResult, error) {
+	top, err := idx.index.RankTop(ctx, result.tokens, result.set, params.expandDocs)
+	if err != nil {
+		return nil, err
+	}
+	expansion := idx.index.Expand(result.tokens, top, params.expandTerms)
+	if expansion == nil {
+		return nil, nil
+	}
+	if params.operator == Or {
+		if params.filter != nil {
+			expansion.set.And(idx.fields.match(params.filter))
+		}
+		idx.withoutDeleted(expansion.set)
+		result.set.Or(expansion.set)
+	}
+	return expansion, nil
+}
+
+// rankingTerms returns the tokens a search is ranked with, along with the multipliers of the weights
+// of those that are expansions: the prefix and fuzzy expansions of query tokens, by the expansion
+// penalty, and the terms added by pseudo-relevance feedback, by the expand weight. The multipliers
+// are nil if there are no expansions to weigh.
+func rankingTerms(result *IndexResult, expansion *IndexResult, params searchParams) ([]string, map[string]float64) {
+	multipliers := result.expansionMultipliers(params.expansionPenalty)
+	if expansion == nil {
+		return result.tokens, multipliers
+	}
+	if multipliers == nil {
+		multipliers = make(map[string]float64, len(expansion.tokens))
+	}
+	for _, term := range expansion.tokens {
+		multipliers[term] = params.expandWeight
+	}
+	return append(result.tokens[:len(result.tokens):len(result.tokens)], expansion.tokens...), multipliers
+}
+
+// parseExpansion reads the expand, expand_docs, expand_terms and expand_weight parameters of a search.
+func parseExpansion(get func(string) string, params *searchParams) error {
+	var err error
+	if params.expand, err = parseBool("expand", get("expand")); err != nil {
+		return err
+	}
+	if !params.expand {
+		if get("expand_docs") != "" || get("expand_terms") != "" || get("expand_weight") != "" {
+			return fmt.Errorf("expand_docs, expand_terms and expand_weight are only valid with expand=true")
+		}
+		return nil
+	}
+
+	params.expandDocs, params.expandTerms, params.expandWeight = defaultExpandDocs, defaultExpandTerms, defaultExpandWeight
+	if s := get("expand_docs"); s != "" {
+		if params.expandDocs, err = strconv.Atoi(s); err != nil || params.expandDocs < 1 || params.expandDocs > maxExpandDocs {
+			return fmt.Errorf("invalid expand_docs %q, must be an integer between 1 and %d", s, maxExpandDocs)
+		}
+	}
+	if s := get("expand_terms"); s != "" {
+		if params.expandTerms, err = strconv.Atoi(s); err != nil || params.expandTerms < 1 || params.expandTerms > maxExpandTerms {
+			return fmt.Errorf("invalid expand_terms %q, must be an integer between 1 and %d", s, maxExpandTerms)
+		}
+	}
+	if s := get("expand_weight"); s != "" {
+		if params.expandWeight, err = strconv.ParseFloat(s, 64); err != nil || !(params.expandWeight > 0 && params.expandWeight <= 1) {
+			return fmt.Errorf("invalid expand_weight %q, must be a number greater than 0 and at most 1", s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestQueryExpansion(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := "car engine repair\ncar automobile dealer\nautomobile sales\nbanana bread\n"
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", corpus))
+
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=car"); len(ids) != 2 {
+		t.Fatalf("expected 2 results without expansion, got %v", ids)
+	}
+	// automobile is among the best terms of the results for car, so it finds the last document
+	ids := searchIds(t, handler, "/v1/indexes/default/search?query=car&expand=true&expand_docs=2")
+	if len(ids) != 3 || ids[2] != 2 {
+		t.Errorf("expected the document found by expansion to rank last, got %v", ids)
+	}
+	// with the and operator, expansion only ranks the matches again
+	ids = searchIds(t, handler, "/v1/indexes/default/search?query=car+engine&operator=and&expand=true")
+	if !reflect.DeepEqual(ids, []uint32{0}) {
+		t.Errorf("expected expansion not to add matches, got %v", ids)
+	}
+
+	for _, params := range []string{"expand_docs=5", "expand=true&expand_terms=0", "expand=true&expand_weight=0", "expand=true&expand_docs=101"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=car&"+params, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got status %d", params, w.Code)
+		}
+	}
+}
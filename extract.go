@@ -0,0 +1,214 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Extractor converts a binary file into plain text before it is tokenized.
+type Extractor interface {
+	Extract(r io.ReaderAt, size int64) ([]extractedText, error)
+}
+
+type extractedText struct {
+	text string
+	page int // 1-based page number, 0 if the format has no pages
+}
+
+// extractors maps lowercase file extensions to the extractor used for them.
+// Files with any other extension are read as plain text, one document per line.
+var extractors = map[string]Extractor{
+	".pdf":  pdfExtractor{},
+	".docx": docxExtractor{},
+}
+
+func extractorFor(filename string) (Extractor, bool) {
+	extractor, ok := extractors[strings.ToLower(filepath.Ext(filename))]
+	return extractor, ok
+}
+
+// extractDocuments runs the extractor and turns each extracted piece of text into a document,
+// storing the filename and the page number (when known) as fields.
+func extractDocuments(extractor Extractor, r io.ReaderAt, size int64, filename string) ([]document, error) {
+	extracted, err := extractor.Extract(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]document, 0, len(extracted))
+	for _, e := range extracted {
+		fields := map[string]string{"filename": filename}
+		if e.page > 0 {
+			fields["page"] = strconv.Itoa(e.page)
+		}
+		docs = append(docs, document{text: e.text, fields: fields})
+	}
+	return docs, nil
+}
+
+const (
+	// maxPDFPageNodes is the number of nodes of the page tree of a PDF visited before extraction
+	// gives up.
+	maxPDFPageNodes = 1 << 20
+	// maxPDFTreeDepth is the deepest a page of a PDF can be in its page tree, counting the parents
+	// it inherits resources from.
+	maxPDFTreeDepth = 64
+)
+
+// pdfExtractor extracts the text of every non-empty page as a separate document.
+type pdfExtractor struct{}
+
+func (pdfExtractor) Extract(r io.ReaderAt, size int64) (result []extractedText, err error) {
+	// the PDF library panics on many malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := pdfPages(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	result = make([]extractedText, 0, len(pages))
+	fonts := make(map[string]*pdf.Font)
+	for i, page := range pages {
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				font := page.Font(name)
+				fonts[name] = &font
+			}
+		}
+
+		text, err := page.GetPlainText(fonts)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+		text = strings.Join(strings.Fields(text), " ")
+		if text == "" {
+			continue
+		}
+		result = append(result, extractedText{text: text, page: i + 1})
+	}
+	return result, nil
+}
+
+// pdfPages returns the pages of a PDF in order. Reader.Page loops forever on page trees whose Kids
+// are not arrays of pages, or that contain cycles, and so does the lookup of the resources a page
+// inherits from its parents, so the tree is walked here with bounds on its size and depth.
+func pdfPages(reader *pdf.Reader) ([]pdf.Page, error) {
+	var pages []pdf.Page
+	var nodes int
+	var walk func(node pdf.Value, depth int) error
+	walk = func(node pdf.Value, depth int) error {
+		if nodes++; nodes > maxPDFPageNodes {
+			return fmt.Errorf("page tree has more than %d nodes", maxPDFPageNodes)
+		}
+		if depth > maxPDFTreeDepth {
+			return fmt.Errorf("page tree is deeper than %d levels", maxPDFTreeDepth)
+		}
+		switch node.Key("Type").Name() {
+		case "Pages":
+			kids := node.Key("Kids")
+			if kids.Kind() != pdf.Array {
+				return fmt.Errorf("invalid page tree, Kids is not an array")
+			}
+			for i := 0; i < kids.Len(); i++ {
+				if err := walk(kids.Index(i), depth+1); err != nil {
+					return err
+				}
+			}
+		case "Page":
+			parents := 0
+			for parent := node.Key("Parent"); !parent.IsNull(); parent = parent.Key("Parent") {
+				if parents++; parents > maxPDFTreeDepth {
+					return fmt.Errorf("page %d has more than %d parents", len(pages)+1, maxPDFTreeDepth)
+				}
+			}
+			pages = append(pages, pdf.Page{V: node})
+		}
+		return nil
+	}
+	if err := walk(reader.Trailer().Key("Root").Key("Pages"), 0); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// docxExtractor extracts the body text of a Word document as a single document.
+type docxExtractor struct{}
+
+func (docxExtractor) Extract(r io.ReaderAt, size int64) ([]extractedText, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range archive.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		text, err := docxText(rc)
+		if err != nil {
+			return nil, err
+		}
+		if text == "" {
+			return []extractedText{}, nil
+		}
+		return []extractedText{{text: text}}, nil
+	}
+	return nil, fmt.Errorf("word/document.xml not found in archive")
+}
+
+// docxText collects the contents of the <w:t> runs of a WordprocessingML document.
+func docxText(r io.Reader) (string, error) {
+	decoder := xml.NewDecoder(r)
+	var parts []string
+	var inText bool
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab", "br", "p":
+				parts = append(parts, " ")
+			}
+		case xml.EndElement:
+			if t.Name.Local == "t" {
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				parts = append(parts, string(t))
+			}
+		}
+	}
+	return strings.Join(strings.Fields(strings.Join(parts, "")), " "), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDocxExtractor(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	f, err := archive.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:body>
+<w:p><w:r><w:t>Hello</w:t></w:r><w:r><w:t xml:space="preserve"> wor</w:t></w:r><w:r><w:t>ld</w:t></w:r></w:p>
+<w:p><w:r><w:t>Second</w:t><w:tab/><w:t>paragraph</w:t></w:r></w:p>
+</w:body>
+</w:document>`))
+	archive.Close()
+
+	extractor, ok := extractorFor("Report.DOCX")
+	if !ok {
+		t.Fatal("no extractor found for .docx files")
+	}
+	docs, err := extractDocuments(extractor, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "Report.DOCX")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 document, got %d", len(docs))
+	}
+	if docs[0].text != "Hello world Second paragraph" {
+		t.Errorf("wrong text extracted: %q", docs[0].text)
+	}
+	if docs[0].fields["filename"] != "Report.DOCX" {
+		t.Errorf("wrong filename field: %v", docs[0].fields)
+	}
+	if _, ok := docs[0].fields["page"]; ok {
+		t.Errorf("docx documents should not have a page field: %v", docs[0].fields)
+	}
+
+	if _, ok := extractorFor("corpus.txt"); ok {
+		t.Error("plain text files should not use an extractor")
+	}
+}
+
+// buildPDF returns a PDF file with the given objects, numbered from 1, and object 1 as its catalog.
+func buildPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestMalformedPDF(t *testing.T) {
+	const catalog = "<< /Type /Catalog /Pages 2 0 R >>"
+	const contents = "<< /Length 0 >>\nstream\n\nendstream"
+	tests := []struct {
+		name    string
+		file    []byte
+		invalid bool
+	}{
+		{"kids not an array", buildPDF(catalog, "<< /Type /Pages /Kids 5 /Count 1 >>"), true},
+		{"cyclic page tree", buildPDF(catalog, "<< /Type /Pages /Kids [2 0 R] /Count 1 >>"), true},
+		{
+			"cyclic parents",
+			buildPDF(catalog, "<< /Type /Pages /Kids [3 0 R] /Count 1 /Parent 2 0 R >>", "<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>", contents),
+			true,
+		},
+		// kids that are not pages are skipped
+		{"kids not references", buildPDF(catalog, "<< /Type /Pages /Kids [5] /Count 1 >>"), false},
+		{
+			"empty page",
+			buildPDF(catalog, "<< /Type /Pages /Kids [3 0 R] /Count 1 >>", "<< /Type /Page /Parent 2 0 R /Resources << >> /Contents 4 0 R >>", contents),
+			false,
+		},
+	}
+	for _, test := range tests {
+		done := make(chan error, 1)
+		go func() {
+			docs, err := extractDocuments(pdfExtractor{}, bytes.NewReader(test.file), int64(len(test.file)), "malformed.pdf")
+			if err == nil && len(docs) != 0 {
+				err = fmt.Errorf("extracted %d documents", len(docs))
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if (err != nil) != test.invalid {
+				t.Errorf("%s: unexpected result %v", test.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: extraction did not finish", test.name)
+		}
+	}
+}
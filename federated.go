@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// federatedSearch runs a query on several indexes concurrently and merges the results. Scores are
+// normalized per index so that results from indexes with different corpora or similarities can be
+// ordered together.
+func (a *App) federatedSearch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	params, err := parseSearchParams(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	params.normalize = true
+	if params.sort != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "sort is not supported when searching several indexes")
+		return
+	}
+	if params.rankingProfile != "" {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "ranking profiles are not supported when searching several indexes")
+		return
+	}
+	if params.profile {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "profile is not supported when searching several indexes")
+		return
+	}
+	if params.diversify {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "diversify is not supported when searching several indexes")
+		return
+	}
+	if params.rerankModel != "" {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "rerank is not supported when searching several indexes")
+		return
+	}
+
+	names := make([]string, 0)
+	for _, name := range strings.Split(r.URL.Query().Get("indexes"), ",") {
+		if name = strings.TrimSpace(name); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "indexes must list at least one index")
+		return
+	}
+
+	indexes := make([]*namedIndex, len(names))
+	for i, name := range names {
+		idx, ok := a.getIndex(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, errIndexNotFound, "index "+name+" does not exist")
+			return
+		}
+		indexes[i] = idx
+	}
+
+	a.limitSearch(&params)
+	maxSize := a.limitSize(&params, nil)
+	results := make([][]searchResponse, len(indexes))
+	truncated := make([]bool, len(indexes))
+	errs := make([]error, len(indexes))
+	var wg sync.WaitGroup
+	for i, idx := range indexes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], truncated[i], errs[i] = idx.search(r.Context(), params)
+		}()
+	}
+	wg.Wait()
+	if r.Context().Err() != nil {
+		return
+	}
+
+	merged := make([]searchResponse, 0)
+	for i, err := range errs {
+		if errors.Is(err, errEmptyIndex) {
+			writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded to index "+names[i])
+			return
+		}
+		if errors.Is(err, errShortPrefix) {
+			writeError(w, http.StatusBadRequest, errInvalidParameter, "index "+names[i]+": "+err.Error())
+			return
+		}
+		if errors.Is(err, errTooManyMatches) {
+			writeError(w, http.StatusTooManyRequests, errQueryTooBroad, "index "+names[i]+": "+err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errInternal, err.Error())
+			return
+		}
+		for _, res := range results[i] {
+			res.Index = names[i]
+			merged = append(merged, res)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return *merged[i].NormalizedScore > *merged[j].NormalizedScore
+	})
+	if params.size > 0 {
+		merged = merged[:min(params.size, len(merged))]
+	}
+
+	if slices.Contains(truncated, true) {
+		w.Header().Set(truncatedHeader, "true")
+	}
+	if maxSize > 0 {
+		w.Header().Set(maxSizeHeader, strconv.Itoa(maxSize))
+	}
+	writeResponse(w, r, merged)
+	a.logQuery(r, "", start, len(merged))
+}
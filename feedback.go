@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+const (
+	maxPopularity      = 10    // highest weight of clicks in the scores of a search
+	maxClickQueries    = 10000 // queries an index records clicks for
+	maxClickedPerQuery = 100   // documents recorded as chosen for a query
+)
+
+// clickFeedback counts the documents chosen by users among the results of each query. Queries are
+// keyed by their analyzed terms, so that "Red fox" and "fox red" share their clicks.
+type clickFeedback struct {
+	lock    sync.Mutex
+	queries map[string]map[uint32]int
+}
+
+func newClickFeedback() *clickFeedback {
+	return &clickFeedback{queries: make(map[string]map[uint32]int)}
+}
+
+// queryKey returns the key of a query in clickFeedback, empty if it has no terms.
+func queryKey(tokens []string) string {
+	tokens = slices.Clone(tokens)
+	slices.Sort(tokens)
+	return strings.Join(slices.Compact(tokens), " ")
+}
+
+// add records a click on a document for a query. It returns false, recording nothing, if the click
+// would add a query beyond maxClickQueries or a document beyond maxClickedPerQuery, so that clients
+// cannot grow the clicks of an index without limit.
+func (c *clickFeedback) add(query string, id uint32) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	clicks := c.queries[query]
+	if clicks == nil {
+		if len(c.queries) >= maxClickQueries {
+			return false
+		}
+		clicks = make(map[uint32]int)
+		c.queries[query] = clicks
+	}
+	if _, ok := clicks[id]; !ok && len(clicks) >= maxClickedPerQuery {
+		return false
+	}
+	clicks[id]++
+	return true
+}
+
+// of returns the clicks of the documents chosen for a query by document id, nil if there are none.
+func (c *clickFeedback) of(query string) map[uint32]int {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if clicks := c.queries[query]; len(clicks) > 0 {
+		return maps.Clone(clicks)
+	}
+	return nil
+}
+
+// remap returns the clicks of c with document ids mapped by f, dropping those of documents f does
+// not keep. It returns nil if no clicks are left.
+func (c *clickFeedback) remap(f func(id uint32) (uint32, bool)) *clickFeedback {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	remapped := newClickFeedback()
+	for query, clicks := range c.queries {
+		for id, n := range clicks {
+			if newId, ok := f(id); ok {
+				if remapped.queries[query] == nil {
+					remapped.queries[query] = make(map[uint32]int)
+				}
+				remapped.queries[query][newId] += n
+			}
+		}
+	}
+	if len(remapped.queries) == 0 {
+		return nil
+	}
+	return remapped
+}
+
+// merge adds the clicks of other to c, with ids shifted by offset.
+func (c *clickFeedback) merge(other *clickFeedback, offset uint32) {
+	shifted := other.remap(func(id uint32) (uint32, bool) { return id + offset, true })
+	if shifted == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for query, clicks := range shifted.queries {
+		if c.queries[query] == nil {
+			c.queries[query] = make(map[uint32]int)
+		}
+		for id, n := range clicks {
+			c.queries[query][id] += n
+		}
+	}
+}
+
+// clicksByKey returns the clicks of an index being replaced, mapped to the documents of the new
+// corpus with the same key. Ids are positions in the corpus, so clicks on documents without a key
+// cannot follow them to another version and are dropped. It must be called with the index lock held.
+func (idx *namedIndex) clicksByKey(corpus []document) *clickFeedback {
+	if idx.clicks == nil {
+		return newClickFeedback()
+	}
+	ids := make(map[string]uint32)
+	for id, doc := range corpus {
+		if doc.key != "" {
+			ids[doc.key] = uint32(id)
+		}
+	}
+	clicks := idx.clicks.remap(func(id uint32) (uint32, bool) {
+		key := idx.corpus[id].key
+		if key == "" {
+			return 0, false
+		}
+		newId, ok := ids[key]
+		return newId, ok
+	})
+	if clicks == nil {
+		return newClickFeedback()
+	}
+	return clicks
+}
+
+// popularityBoost multiplies the score of each document chosen for the query by
+// 1 + popularity * log(1 + clicks), so that it rises gradually as users keep choosing it, and sorts
+// the results again. Documents chosen for the query that are among candidates but missing from
+// ranked, because only the top results were ranked, are ranked first, with the tokens and
+// multipliers ranked was. It must be called with the index lock held.
+func (idx *namedIndex) popularityBoost(
+	ctx context.Context, ranked []RankResult, tokens []string, multipliers map[string]float64, candidates *roaring.Bitmap,
+	params searchParams,
+) ([]RankResult, error) {
+	queryTokens, err := idx.options.queryAnalyzer().Analyze(params.query)
+	if err != nil {
+		return nil, err
+	}
+	clicks := idx.clicks.of(queryKey(queryTokens))
+	if clicks == nil || candidates == nil {
+		return ranked, nil
+	}
+
+	missing := roaring.New()
+	for id := range clicks {
+		missing.Add(id)
+	}
+	missing.And(candidates)
+	for _, res := range ranked {
+		missing.Remove(res.id)
+	}
+	if !missing.IsEmpty() {
+		more, err := idx.index.RankWeighted(ctx, tokens, multipliers, missing, 0)
+		if err != nil {
+			return nil, err
+		}
+		ranked = append(ranked, more...)
+	}
+
+	for i, res := range ranked {
+		if n := clicks[res.id]; n > 0 {
+			ranked[i].score *= 1 + params.popularity*math.Log1p(float64(n))
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	return ranked, nil
+}
+
+// parsePopularity reads the popularity parameter of a search, the weight of the clicks recorded
+// for its query. It is 0, leaving scores unchanged, by default.
+func parsePopularity(get func(string) string, params *searchParams) error {
+	s := get("popularity")
+	if s == "" {
+		return nil
+	}
+	var err error
+	if params.popularity, err = strconv.ParseFloat(s, 64); err != nil || !(params.popularity >= 0 && params.popularity <= maxPopularity) {
+		return fmt.Errorf("invalid popularity %q, must be a number between 0 and %d", s, maxPopularity)
+	}
+	return nil
+}
+
+type feedbackRequest struct {
+	Query string  `json:"query"`
+	Id    *uint32 `json:"id"`
+}
+
+// feedback records that a user chose a document among the results of a query.
+func (a *App) feedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	a.limitBody(w, r)
+	var req feedbackRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error parsing request body: "+err.Error())
+		return
+	}
+	if req.Id == nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "no document id provided")
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	// clicks have their own lock, so recording one does not block searches
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if idx.index == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+	tokens, err := idx.options.queryAnalyzer().Analyze(req.Query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error analyzing query: "+err.Error())
+		return
+	}
+	query := queryKey(tokens)
+	if query == "" {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "the query has no terms")
+		return
+	}
+	if !idx.exists(*req.Id) {
+		writeError(w, http.StatusNotFound, errDocumentNotFound, fmt.Sprintf("document %d does not exist", *req.Id))
+		return
+	}
+
+	if !idx.clicks.add(query, *req.Id) {
+		writeError(
+			w, http.StatusInsufficientStorage, errInsufficientMemory,
+			fmt.Sprintf("clicks are recorded for at most %d queries and %d documents per query", maxClickQueries, maxClickedPerQuery),
+		)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestClickFeedback(t *testing.T) {
+	handler := NewApp().Routes()
+	upload := func(corpus string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	feedback := func(body string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/feedback", strings.NewReader(body)))
+		return w.Code
+	}
+
+	upload(`{"key": "a", "text": "red fox"}
+{"key": "b", "text": "red fox jumps high"}
+{"key": "c", "text": "blue whale"}
+`)
+	for range 3 {
+		// queries with the same terms share their clicks
+		if code := feedback(`{"query": "Fox red", "id": 1}`); code != http.StatusNoContent {
+			t.Fatalf("expected feedback to be recorded, got status %d", code)
+		}
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox"); !reflect.DeepEqual(ids, []uint32{0, 1}) {
+		t.Errorf("expected clicks to be ignored without popularity, got %v", ids)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox&popularity=2"); !reflect.DeepEqual(ids, []uint32{1, 0}) {
+		t.Errorf("expected the chosen document first, got %v", ids)
+	}
+	// the chosen document is ranked even if it is not among the top results
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox&popularity=2&size=1"); !reflect.DeepEqual(ids, []uint32{1}) {
+		t.Errorf("expected the chosen document, got %v", ids)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red&popularity=2"); !reflect.DeepEqual(ids, []uint32{0, 1}) {
+		t.Errorf("expected clicks of other queries to be ignored, got %v", ids)
+	}
+
+	// clicks follow the keys of the documents to a new version of the index
+	upload(`{"key": "b", "text": "red fox jumps high"}
+{"key": "a", "text": "red fox"}
+`)
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox&popularity=2"); !reflect.DeepEqual(ids, []uint32{0, 1}) {
+		t.Errorf("expected the chosen document first after a new upload, got %v", ids)
+	}
+
+	for body, status := range map[string]int{
+		`{"query": "red", "id": 7}`:  http.StatusNotFound,
+		`{"query": "red"}`:           http.StatusBadRequest,
+		`{"query": "", "id": 0}`:     http.StatusBadRequest,
+		`{"query": "red", "id": -1}`: http.StatusBadRequest,
+	} {
+		if code := feedback(body); code != status {
+			t.Errorf("expected status %d for %s, got %d", status, body, code)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red&popularity=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a negative popularity to be rejected, got status %d", w.Code)
+	}
+}
+
+func TestClickFeedbackLimits(t *testing.T) {
+	clicks := newClickFeedback()
+	for i := range maxClickQueries {
+		if !clicks.add(strconv.Itoa(i), 0) {
+			t.Fatalf("expected the click of query %d to be recorded", i)
+		}
+	}
+	if clicks.add("fox", 0) {
+		t.Errorf("expected a query beyond the limit to be rejected")
+	}
+	for id := range uint32(maxClickedPerQuery) {
+		if !clicks.add("0", id) {
+			t.Fatalf("expected the click on document %d to be recorded", id)
+		}
+	}
+	if clicks.add("0", maxClickedPerQuery) {
+		t.Errorf("expected a document beyond the limit to be rejected")
+	}
+	// documents already chosen for a query keep counting
+	if !clicks.add("0", 0) || clicks.of("0")[0] != 3 {
+		t.Errorf("expected clicks on a recorded document to be counted, got %v", clicks.of("0")[0])
+	}
+}
+
+func TestFeedbackIsAdminEndpoint(t *testing.T) {
+	app := NewApp()
+	w := httptest.NewRecorder()
+	app.Routes().ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "red fox\n"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	body := `{"query": "fox", "id": 0}`
+	w = httptest.NewRecorder()
+	app.SearchRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/feedback", strings.NewReader(body)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected feedback to be left out of the search listener, got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	app.AdminRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/feedback", strings.NewReader(body)))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected feedback to be recorded on the admin listener, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+const filterCacheSize = 256
+
+// fieldIndex maps every value of every document field to the documents having it, and holds the
+// value of each field by document. It is never modified once built, so the filters it caches stay
+// valid until the index is replaced.
+type fieldIndex struct {
+	values  map[string]map[string]*roaring.Bitmap
+	columns map[string]*docValues
+	cache   *filterCache
+}
+
+func newFieldIndex(corpus []document) *fieldIndex {
+	index := &fieldIndex{
+		values:  make(map[string]map[string]*roaring.Bitmap),
+		columns: newDocValues(corpus),
+		cache:   newFilterCache(filterCacheSize),
+	}
+	for id, doc := range corpus {
+		for key, value := range doc.fields {
+			values, ok := index.values[key]
+			if !ok {
+				values = make(map[string]*roaring.Bitmap)
+				index.values[key] = values
+			}
+			set, ok := values[value]
+			if !ok {
+				set = roaring.New()
+				values[value] = set
+			}
+			set.Add(uint32(id))
+		}
+	}
+	for _, values := range index.values {
+		for _, set := range values {
+			set.RunOptimize()
+		}
+	}
+	return index
+}
+
+// docFilter restricts a search to documents whose fields have one of the given values or are within
+// the given ranges. Different fields must all match, values of the same field are alternatives.
+type docFilter struct {
+	values map[string][]string
+	ranges map[string]fieldRange
+}
+
+// parseFilter reads a comma-separated list of key:value pairs and of bounds such as key>=value.
+func parseFilter(s string) (*docFilter, error) {
+	if s == "" {
+		return nil, nil
+	}
+	filter := &docFilter{values: make(map[string][]string), ranges: make(map[string]fieldRange)}
+	for _, clause := range strings.Split(s, ",") {
+		i := strings.IndexAny(clause, ":<>")
+		if i <= 0 {
+			return nil, fmt.Errorf(
+				"invalid filter %q, must be a comma-separated list of key:value pairs and bounds such as key>=value", s,
+			)
+		}
+		key, op, value := clause[:i], clause[i:i+1], clause[i+1:]
+		if op == ":" {
+			filter.values[key] = append(filter.values[key], value)
+			continue
+		}
+		if strings.HasPrefix(value, "=") {
+			op, value = op+"=", value[1:]
+		}
+		if value == "" {
+			return nil, fmt.Errorf("invalid filter %q, the bound of %s%s is empty", s, key, op)
+		}
+		r, err := filter.ranges[key].withBound(op, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q, %s has %w", s, key, err)
+		}
+		filter.ranges[key] = r
+	}
+	return filter, nil
+}
+
+// String returns the filter in a canonical form, with fields and values sorted, so that equivalent
+// filters share a cache entry.
+func (f *docFilter) String() string {
+	clauses := make([]string, 0, len(f.values)+len(f.ranges))
+	for key, values := range f.values {
+		for _, value := range values {
+			clauses = append(clauses, key+":"+value)
+		}
+	}
+	for key, r := range f.ranges {
+		clauses = append(clauses, r.clauses(key)...)
+	}
+	sort.Strings(clauses)
+	return strings.Join(slices.Compact(clauses), ",")
+}
+
+// match returns the documents that pass the filter. The result is shared with later searches
+// using the same filter and must not be modified.
+func (f *fieldIndex) match(filter *docFilter) *roaring.Bitmap {
+	key := filter.String()
+	if set, ok := f.cache.get(key); ok {
+		return set
+	}
+	set := f.compute(filter)
+	f.cache.add(key, set)
+	return set
+}
+
+func (f *fieldIndex) compute(filter *docFilter) *roaring.Bitmap {
+	var result *roaring.Bitmap
+	intersect := func(matching *roaring.Bitmap) {
+		if result == nil {
+			result = matching
+		} else {
+			result.And(matching)
+		}
+	}
+	for key, values := range filter.values {
+		matching := roaring.New()
+		for _, value := range values {
+			if set, ok := f.values[key][value]; ok {
+				matching.Or(set)
+			}
+		}
+		intersect(matching)
+	}
+	for key, r := range filter.ranges {
+		if column, ok := f.columns[key]; ok {
+			intersect(column.matchRange(r))
+		} else {
+			intersect(roaring.New())
+		}
+	}
+	return result
+}
+
+// filterCache keeps the documents matching the most recently used filters. It is safe for
+// concurrent use.
+type filterCache struct {
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+	lock    sync.Mutex
+}
+
+type filterCacheEntry struct {
+	key string
+	set *roaring.Bitmap
+}
+
+func newFilterCache(size int) *filterCache {
+	return &filterCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *filterCache) get(key string) (*roaring.Bitmap, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*filterCacheEntry).set, true
+}
+
+func (c *filterCache) add(key string, set *roaring.Bitmap) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&filterCacheEntry{key: key, set: set})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*filterCacheEntry).key)
+	}
+}
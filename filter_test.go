@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	filter, err := parseFilter("source:wiki,lang:en,lang:pt,url:http://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(filter.values) != 3 || !slices.Equal(filter.values["lang"], []string{"en", "pt"}) || filter.values["url"][0] != "http://example.com" {
+		t.Errorf("wrong filter %v", filter)
+	}
+
+	for _, s := range []string{"source", ":wiki", "source:wiki,"} {
+		if _, err := parseFilter(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestMetadataFilter(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := `{"text": "red fox", "fields": {"source": "wiki", "lang": "en"}}
+{"text": "red wine", "fields": {"source": "news", "lang": "en"}}
+
+{"text": "red vinho", "fields": {"source": "wiki", "lang": "pt"}}
+`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	inputs := map[string][]uint32{
+		"":                        {0, 1, 2},
+		"source:wiki":             {0, 2},
+		"source:wiki,lang:en":     {0},
+		"lang:en,lang:pt":         {0, 1, 2},
+		"source:blog":             {},
+		"source:news,missing:key": {},
+	}
+	for filter, expected := range inputs {
+		ids := searchIds(t, handler, "/v1/indexes/default/search?query=red&filter="+filter)
+		slices.Sort(ids)
+		if !slices.Equal(ids, expected) {
+			t.Errorf("ids %v different from expected %v for filter %q", ids, expected, filter)
+		}
+	}
+
+	// without a query, filtered documents are returned in id order and unscored
+	results := searchResults(t, handler, "/v1/indexes/default/search?filter=source:wiki")
+	if len(results) != 2 || results[0].Id != 0 || results[1].Id != 2 || results[0].Score != 0 {
+		t.Errorf("wrong filter-only results %+v", results)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"bad.ndjson": "{\"text\": 1}\n"}, []string{"bad.ndjson"}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid document error, got status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFilterCache(t *testing.T) {
+	corpus := []document{
+		{text: "a", fields: map[string]string{"lang": "en"}},
+		{text: "b", fields: map[string]string{"lang": "pt"}},
+	}
+	index := newFieldIndex(corpus)
+
+	first, _ := parseFilter("lang:pt,lang:en")
+	second, _ := parseFilter("lang:en,lang:pt")
+	set := index.match(first)
+	if set.GetCardinality() != 2 {
+		t.Errorf("wrong documents %v", set.ToArray())
+	}
+	if index.match(second) != set {
+		t.Error("equivalent filters should share a cache entry")
+	}
+
+	cache := newFilterCache(2)
+	for _, key := range []string{"a", "b", "a", "c"} {
+		cache.add(key, nil)
+	}
+	if _, ok := cache.get("b"); ok {
+		t.Error("least recently used filter should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("filter %s missing from cache", key)
+		}
+	}
+}
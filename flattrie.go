@@ -0,0 +1,267 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// flatTrie is the read-only trie of a built index. It holds the nodes of a PatriciaTrie in a single
+// pointer-free slice indexed by int32, so that searches do not chase pointers and the garbage
+// collector does not scan the nodes. Nodes are numbered in breadth-first order, which keeps the
+// children of each node contiguous, and node 0 is the root. Children are sorted by label, so
+// depth-first walks visit keys in lexicographic order.
+type flatTrie struct {
+	nodes  []flatNode
+	labels string            // edge labels, concatenated
+	sets   []*roaring.Bitmap // postings of the keys, indexed by flatNode.value
+}
+
+// flatNode is a trie node along with the edge leading to it.
+type flatNode struct {
+	label       int32 // offset of the edge label in labels
+	labelLen    int32
+	firstChild  int32 // the children are the nodes firstChild to firstChild+numChildren-1
+	numChildren int32
+	value       int32 // index of the postings in sets, -1 if no key ends at the node
+}
+
+// newFlatTrie copies a trie into its flat layout. The postings are shared with t.
+func newFlatTrie(t *PatriciaTrie) *flatTrie {
+	f := &flatTrie{nodes: []flatNode{{value: -1}}}
+	var labels strings.Builder
+	queue := []*node{t.root}
+	for i := 0; i < len(queue); i++ {
+		children := slices.Clone(queue[i].children)
+		// labels of siblings start with distinct bytes
+		slices.SortFunc(children, func(a, b *node) int {
+			return cmp.Compare(t.labels[a.parent.offset], t.labels[b.parent.offset])
+		})
+		f.nodes[i].firstChild = int32(len(f.nodes))
+		f.nodes[i].numChildren = int32(len(children))
+		for _, child := range children {
+			flat := flatNode{label: int32(labels.Len()), labelLen: int32(child.parent.len), value: -1}
+			labels.Write(t.label(child.parent))
+			if child.value != nil {
+				flat.value = int32(len(f.sets))
+				f.sets = append(f.sets, child.value)
+			}
+			f.nodes = append(f.nodes, flat)
+			queue = append(queue, child)
+		}
+	}
+	f.labels = labels.String()
+	return f
+}
+
+func (f *flatTrie) label(n int32) string {
+	node := &f.nodes[n]
+	return f.labels[node.label : node.label+node.labelLen]
+}
+
+// child returns the child of n whose label starts with b, or -1 if there is none.
+func (f *flatTrie) child(n int32, b byte) int32 {
+	node := &f.nodes[n]
+	for c := node.firstChild; c < node.firstChild+node.numChildren; c++ {
+		if f.labels[f.nodes[c].label] == b {
+			return c
+		}
+	}
+	return -1
+}
+
+// find returns the node at which key ends, and the keys of the trie up to that node, which are
+// longer than key if it ends in the middle of an edge. It returns -1 if no key starts with key.
+func (f *flatTrie) find(key string) (int32, string) {
+	var n int32
+	var found int
+	for found < len(key) {
+		if n = f.child(n, key[found]); n < 0 {
+			return -1, ""
+		}
+		label, rest := f.label(n), key[found:]
+		if len(rest) < len(label) {
+			if !strings.HasPrefix(label, rest) {
+				return -1, ""
+			}
+			return n, key[:found] + label
+		}
+		if !strings.HasPrefix(rest, label) {
+			return -1, ""
+		}
+		found += len(label)
+	}
+	return n, key
+}
+
+func (f *flatTrie) Search(key string) *IndexResult {
+	n, path := f.find(key + string('\x00'))
+	if n < 0 || len(path) != len(key)+1 || f.nodes[n].value < 0 {
+		return nil
+	}
+	return &IndexResult{set: f.sets[f.nodes[n].value], tokens: []string{key}}
+}
+
+// StartsWith returns the keys starting with key, the most frequent first. At most maxExpansions
+// keys are used; 0 means no limit. It stops early, returning the keys found so far, when ctx is
+// done.
+func (f *flatTrie) StartsWith(ctx context.Context, key string, maxExpansions int) *IndexResult {
+	expansions := f.completions(ctx, key)
+	if expansions == nil {
+		return nil
+	}
+	sortExpansions(expansions)
+	return combineExpansions(expansions, maxExpansions)
+}
+
+// completions returns the keys starting with key, or nil if there are none. It stops early,
+// returning the keys found so far, when ctx is done.
+func (f *flatTrie) completions(ctx context.Context, key string) []expansion {
+	n, path := f.find(key)
+	if n < 0 {
+		return nil
+	}
+	return f.mergeChildren(ctx, n, []byte(path), make([]expansion, 0))
+}
+
+// mergeChildren appends the keys under n, whose path from the root is path.
+func (f *flatTrie) mergeChildren(ctx context.Context, n int32, path []byte, expansions []expansion) []expansion {
+	if ctx.Err() != nil {
+		return expansions
+	}
+	node := &f.nodes[n]
+	if node.value >= 0 {
+		expansions = append(expansions, f.expansion(n, path, 0))
+	}
+	for c := node.firstChild; c < node.firstChild+node.numChildren; c++ {
+		expansions = f.mergeChildren(ctx, c, append(path, f.label(c)...), expansions)
+	}
+	return expansions
+}
+
+// FuzzySearch returns the keys within limit edits of key, the closest first and then the most
+// frequent. At most maxExpansions keys are used; 0 means no limit. It stops early, returning the
+// keys found so far, when ctx is done.
+func (f *flatTrie) FuzzySearch(ctx context.Context, key string, limit int, maxExpansions int) *IndexResult {
+	key += string('\x00')
+	expansions := f.fuzzySearch(ctx, 0, nil, key, limit, make([]expansion, 0))
+	sortExpansions(expansions)
+	return combineExpansions(expansions, maxExpansions)
+}
+
+func (f *flatTrie) fuzzySearch(
+	ctx context.Context, n int32, path []byte, key string, limit int, matches []expansion,
+) []expansion {
+	if ctx.Err() != nil {
+		return matches
+	}
+	l := min(len(key), len(path))
+	distance := LevenshteinDistance(string(path), key[:l])
+	node := &f.nodes[n]
+	if distance <= limit {
+		for c := node.firstChild; c < node.firstChild+node.numChildren; c++ {
+			matches = f.fuzzySearch(ctx, c, append(path, f.label(c)...), key, limit, matches)
+		}
+	}
+
+	if node.value >= 0 {
+		if l < len(key) {
+			distance = LevenshteinDistance(string(path), key)
+		}
+		if distance <= limit {
+			matches = append(matches, f.expansion(n, path, distance))
+		}
+	}
+	return matches
+}
+
+// expansion returns the key ending at n, whose path from the root is path.
+func (f *flatTrie) expansion(n int32, path []byte, distance int) expansion {
+	return expansion{token: string(path[:len(path)-1]), set: f.sets[f.nodes[n].value], distance: distance}
+}
+
+// Range returns the keys from from, inclusive, to to, exclusive, in lexicographic order, or nil if
+// there are none. An empty to has no upper bound. At most maxExpansions keys are used; 0 means no
+// limit. Subtrees outside of the range are skipped without being walked, and the walk stops early,
+// returning the keys found so far, when ctx is done.
+func (f *flatTrie) Range(ctx context.Context, from, to string, maxExpansions int) *IndexResult {
+	expansions := f.rangeNode(ctx, 0, nil, from, to, make([]expansion, 0))
+	if len(expansions) == 0 {
+		return nil
+	}
+	return combineExpansions(expansions, maxExpansions)
+}
+
+func (f *flatTrie) rangeNode(ctx context.Context, n int32, path []byte, from, to string, expansions []expansion) []expansion {
+	if ctx.Err() != nil {
+		return expansions
+	}
+	node := &f.nodes[n]
+	if node.value >= 0 {
+		if token := string(path[:len(path)-1]); token >= from && (to == "" || token < to) {
+			expansions = append(expansions, f.expansion(n, path, 0))
+		}
+		return expansions
+	}
+	for c := node.firstChild; c < node.firstChild+node.numChildren; c++ {
+		childPath := append(path, f.label(c)...)
+		// the keys below a node all start with its path, so they all come before from if the path
+		// does, and they all come at or after to if the path does, as do the keys of later siblings
+		if l := min(len(childPath), len(from)); string(childPath[:l]) < from[:l] {
+			continue
+		}
+		if to != "" && strings.TrimSuffix(string(childPath), "\x00") >= to {
+			break
+		}
+		expansions = f.rangeNode(ctx, c, childPath, from, to, expansions)
+	}
+	return expansions
+}
+
+// Traversal returns the keys of the trie in lexicographic order.
+func (f *flatTrie) Traversal() []tokenSet {
+	return f.traversal(0, nil, make([]tokenSet, 0, len(f.sets)))
+}
+
+func (f *flatTrie) traversal(n int32, path []byte, tokenSets []tokenSet) []tokenSet {
+	node := &f.nodes[n]
+	if node.value >= 0 {
+		tokenSets = append(tokenSets, tokenSet{set: f.sets[node.value], token: string(path[:len(path)-1])})
+	}
+	for c := node.firstChild; c < node.firstChild+node.numChildren; c++ {
+		tokenSets = f.traversal(c, append(path, f.label(c)...), tokenSets)
+	}
+	return tokenSets
+}
+
+// keysAfter returns up to limit keys greater than after in lexicographic order, and whether there
+// are more. Subtrees whose keys all come before after are skipped without being walked.
+func (f *flatTrie) keysAfter(after string, limit int) ([]tokenSet, bool) {
+	tokenSets := f.keysAfterNode(0, nil, after, limit+1, make([]tokenSet, 0, min(limit+1, len(f.sets))))
+	if len(tokenSets) > limit {
+		return tokenSets[:limit], true
+	}
+	return tokenSets, false
+}
+
+func (f *flatTrie) keysAfterNode(n int32, path []byte, after string, limit int, tokenSets []tokenSet) []tokenSet {
+	node := &f.nodes[n]
+	if node.value >= 0 {
+		if token := string(path[:len(path)-1]); token > after {
+			tokenSets = append(tokenSets, tokenSet{set: f.sets[node.value], token: token})
+		}
+		return tokenSets
+	}
+	for c := node.firstChild; c < node.firstChild+node.numChildren && len(tokenSets) < limit; c++ {
+		childPath := append(path, f.label(c)...)
+		// the keys below a node all start with its path, so they all come before after if the path does
+		if l := min(len(childPath), len(after)); string(childPath[:l]) < after[:l] {
+			continue
+		}
+		tokenSets = f.keysAfterNode(c, childPath, after, limit, tokenSets)
+	}
+	return tokenSets
+}
@@ -4,10 +4,15 @@ go 1.23
 
 require (
 	github.com/RoaringBitmap/roaring v1.9.4
+	github.com/blevesearch/snowballstem v0.9.0
 	github.com/kljensen/snowball v0.10.0
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/net v0.30.0
 )
 
 require (
 	github.com/bits-and-blooms/bitset v1.20.0 // indirect
 	github.com/mschoch/smat v0.2.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 )
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	maxPageSize  = 10 << 20 // 10 MB
+	fetchTimeout = 10 * time.Second
+	fetchWorkers = 8
+	maxFetchUrls = 100
+)
+
+var errPrivateAddress = errors.New("destination address is not public")
+
+// boilerplateTags are elements whose text is not part of the page content.
+var boilerplateTags = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+}
+
+type htmlPage struct {
+	title string
+	body  string
+}
+
+// extractHTML parses an HTML document and returns its title and visible body text.
+func extractHTML(r io.Reader) (*htmlPage, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var title, body []string
+	var walk func(n *html.Node, inBody bool)
+	walk = func(n *html.Node, inBody bool) {
+		if n.Type == html.ElementNode {
+			if boilerplateTags[n.DataAtom] {
+				return
+			}
+			switch n.DataAtom {
+			case atom.Title:
+				if len(title) == 0 {
+					title = collectText(n, title)
+				}
+				return
+			case atom.Body:
+				inBody = true
+			}
+		}
+
+		if n.Type == html.TextNode && inBody {
+			body = append(body, n.Data)
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inBody)
+		}
+	}
+	walk(root, false)
+
+	return &htmlPage{
+		title: strings.Join(strings.Fields(strings.Join(title, " ")), " "),
+		body:  strings.Join(strings.Fields(strings.Join(body, " ")), " "),
+	}, nil
+}
+
+func collectText(n *html.Node, text []string) []string {
+	if n.Type == html.TextNode {
+		return append(text, n.Data)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text = collectText(c, text)
+	}
+	return text
+}
+
+// fetchHTML downloads the page at url and extracts its text.
+func fetchHTML(ctx context.Context, client *http.Client, url string) (*htmlPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("unsupported content type %s", contentType)
+	}
+
+	return extractHTML(io.LimitReader(resp.Body, maxPageSize))
+}
+
+type fetchResult struct {
+	url  string
+	page *htmlPage
+	err  error
+}
+
+// publicAddress reports whether addr may be fetched on behalf of a client. Loopback,
+// private, link-local (including cloud metadata endpoints) and unspecified addresses
+// are refused so that the server cannot be used to reach its own network.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+// dialPublic is a net.Dialer Control function that refuses non-public destinations.
+// It runs after name resolution, so it also covers redirects and DNS rebinding.
+func dialPublic(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// newFetchClient returns an HTTP client that only connects to public addresses.
+func newFetchClient() *http.Client {
+	dialer := &net.Dialer{Timeout: fetchTimeout, Control: dialPublic}
+	return &http.Client{
+		Timeout: fetchTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: fetchTimeout,
+		},
+	}
+}
+
+// fetchAll fetches the given URLs concurrently. Results are returned in the same order as urls.
+// Fetches still in progress fail when ctx is done.
+func fetchAll(ctx context.Context, urls []string) []fetchResult {
+	client := newFetchClient()
+	results := make([]fetchResult, len(urls))
+
+	jobs := make(chan int)
+	done := make(chan struct{})
+	for w := 0; w < min(fetchWorkers, len(urls)); w++ {
+		go func() {
+			for i := range jobs {
+				page, err := fetchHTML(ctx, client, urls[i])
+				results[i] = fetchResult{url: urls[i], page: page, err: err}
+			}
+			done <- struct{}{}
+		}()
+	}
+
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+
+	for w := 0; w < min(fetchWorkers, len(urls)); w++ {
+		<-done
+	}
+	return results
+}
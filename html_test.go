@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+type extractHTMLTest struct {
+	html  string
+	title string
+	body  string
+}
+
+func TestExtractHTML(t *testing.T) {
+	inputs := []extractHTMLTest{
+		{
+			"<html><head><title> A  title </title></head><body><p>Hello</p><p>world</p></body></html>",
+			"A title",
+			"Hello world",
+		},
+		{
+			"<html><body><nav>Home About</nav><h1>News</h1><script>var x = 1;</script><p>Some &amp; text</p><footer>Copyright</footer></body></html>",
+			"",
+			"News Some & text",
+		},
+		{
+			"<title>Only title</title>",
+			"Only title",
+			"",
+		},
+	}
+	for _, input := range inputs {
+		page, err := extractHTML(strings.NewReader(input.html))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if page.title != input.title {
+			t.Errorf("title %q different from expected %q", page.title, input.title)
+		}
+		if page.body != input.body {
+			t.Errorf("body %q different from expected %q", page.body, input.body)
+		}
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	inputs := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+	}
+	for input, expected := range inputs {
+		if public := publicAddress(netip.MustParseAddr(input)); public != expected {
+			t.Errorf("publicAddress(%s) = %t, expected %t", input, public, expected)
+		}
+	}
+}
+
+func TestFetchRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>secret</title>"))
+	}))
+	defer server.Close()
+
+	results := fetchAll(context.Background(), []string{server.URL})
+	if !errors.Is(results[0].err, errPrivateAddress) {
+		t.Errorf("expected a private address error, got %v", results[0].err)
+	}
+}
+
+func TestUploadUrlsLimit(t *testing.T) {
+	handler := NewApp().Routes()
+
+	urls := make([]string, maxFetchUrls+1)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+	body, _ := json.Marshal(uploadUrlsRequest{Urls: urls})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/urls", strings.NewReader(string(body))))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUploadUrlsReservesWorstCase(t *testing.T) {
+	app := NewApp()
+	handler := app.Routes()
+
+	app.memory.limit = maxPageSize * uploadMemoryFactor
+	body := `{"urls": ["https://example.com/a", "https://example.com/b"]}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/urls", strings.NewReader(body)))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected status 507 before fetching, got %d: %s", w.Code, w.Body.String())
+	}
+	if used := app.memory.used(); used != 0 {
+		t.Errorf("expected the reservation to be released, %d bytes still used", used)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// documentIDs returns the id of every keyed document of an index, so that a new version of the
+// index can keep them.
+func (a *App) documentIDs(name string) map[string]uint32 {
+	idx, ok := a.getIndex(name)
+	if !ok {
+		return nil
+	}
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	ids := make(map[string]uint32)
+	for id, doc := range idx.corpus {
+		if doc.key != "" {
+			ids[doc.key] = uint32(id)
+		}
+	}
+	return ids
+}
+
+// assignIDs orders documents by the id they will get. Documents whose key had an id in the previous
+// version of the index keep it, and the other documents take the lowest free ids in upload order.
+// Ids that are kept free by a removed document are returned as nil slots. Without any key, the
+// documents are returned in upload order.
+func assignIDs(docs []analyzedDocument, previous map[string]uint32) ([]*analyzedDocument, error) {
+	slots := make([]*analyzedDocument, 0, len(docs))
+	keys := make(map[string]bool)
+	var remaining []*analyzedDocument
+	for i := range docs {
+		doc := &docs[i]
+		key := doc.doc.key
+		if key != "" {
+			if keys[key] {
+				return nil, &ingestError{http.StatusBadRequest, errInvalidRequest, fmt.Sprintf("duplicate document key %q", key)}
+			}
+			keys[key] = true
+		}
+
+		id, ok := previous[key]
+		if key == "" || !ok {
+			remaining = append(remaining, doc)
+			continue
+		}
+		for uint32(len(slots)) <= id {
+			slots = append(slots, nil)
+		}
+		slots[id] = doc
+	}
+
+	var next int
+	for _, doc := range remaining {
+		for next < len(slots) && slots[next] != nil {
+			next++
+		}
+		if next == len(slots) {
+			slots = append(slots, nil)
+		}
+		slots[next] = doc
+	}
+	return slots, nil
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// corpusFile is an uploaded corpus. Text files are read sequentially, binary formats are extracted
+// with random access.
+type corpusFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// corpusSource is a file whose documents are added to an index.
+type corpusSource struct {
+	filename string
+	size     int64
+	open     func() (corpusFile, error)
+}
+
+// ingestError is an error reading a corpus, with the response it should produce.
+type ingestError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *ingestError) Error() string {
+	return e.message
+}
+
+func writeIngestError(w http.ResponseWriter, err error) {
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		writeError(w, ingestErr.status, ingestErr.code, ingestErr.message)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, errInternal, err.Error())
+}
+
+// isArchive reports whether a file is an archive of corpus files.
+func isArchive(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar") ||
+		strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// archiveSources lists the regular files of a zip or (optionally gzipped) tar archive, which can hold
+// at most maxSize bytes once decompressed. Zip entries are decompressed when opened. Tar archives can
+// only be read in order, so their entries are decompressed into a temporary file, removed by cleanup.
+func archiveSources(file corpusFile, size int64, filename string, maxSize int64) (sources []corpusSource, cleanup func(), err error) {
+	cleanup = func() {}
+	tooLarge := func() error {
+		return &ingestError{
+			http.StatusRequestEntityTooLarge, errPayloadTooLarge,
+			"archive " + filename + " exceeds the maximum upload size of " + formatBytes(maxSize) + " once decompressed",
+		}
+	}
+
+	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		archive, err := zip.NewReader(file, size)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		var total uint64
+		for _, f := range archive.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			if total += f.UncompressedSize64; total > uint64(maxSize) {
+				return nil, cleanup, tooLarge()
+			}
+			sources = append(sources, corpusSource{
+				filename: f.Name,
+				size:     int64(f.UncompressedSize64),
+				open: func() (corpusFile, error) {
+					rc, err := f.Open()
+					if err != nil {
+						return nil, err
+					}
+					defer rc.Close()
+					// the reservation for the upload is based on the declared size
+					data, err := io.ReadAll(io.LimitReader(rc, int64(f.UncompressedSize64)+1))
+					if err != nil {
+						return nil, err
+					}
+					if uint64(len(data)) > f.UncompressedSize64 {
+						return nil, fmt.Errorf("%s is larger than its declared size", f.Name)
+					}
+					return memoryFile{bytes.NewReader(data)}, nil
+				},
+			})
+		}
+		return sources, cleanup, nil
+	}
+
+	var r io.Reader = file
+	if name := strings.ToLower(filename); strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	entries, err := os.CreateTemp("", "stellr-archive-")
+	if err != nil {
+		return nil, cleanup, err
+	}
+	cleanup = func() {
+		entries.Close()
+		os.Remove(entries.Name())
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	var total int64
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, cleanup, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Size > maxSize-total {
+			return nil, cleanup, tooLarge()
+		}
+		n, err := io.Copy(entries, archive)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		offset := total
+		sources = append(sources, corpusSource{
+			filename: header.Name,
+			size:     n,
+			open:     func() (corpusFile, error) { return sectionFile{io.NewSectionReader(entries, offset, n)}, nil },
+		})
+		total += n
+	}
+	return sources, cleanup, nil
+}
+
+// sectionFile is a part of a file that is closed by its owner.
+type sectionFile struct {
+	*io.SectionReader
+}
+
+func (sectionFile) Close() error { return nil }
+
+// isNDJSON reports whether a file is a corpus of JSON documents, one per line.
+func isNDJSON(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".ndjson")
+}
+
+// jsonDocument is a line of an NDJSON corpus. Fields are stored with the document and can be
+// used to filter searches. The optional key identifies the document across uploads, and the
+// document is deleted once the optional expiry time has passed. The optional boost multiplies
+// the scores of the document.
+type jsonDocument struct {
+	Key     string            `json:"key"`
+	Text    string            `json:"text"`
+	Fields  map[string]string `json:"fields"`
+	Expires time.Time         `json:"expires"`
+	Boost   *float64          `json:"boost"`
+}
+
+// parseJSONDocument reads a line of an NDJSON corpus.
+func parseJSONDocument(line []byte) (document, error) {
+	var parsed jsonDocument
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parsed); err != nil {
+		return document{}, err
+	}
+	if parsed.Text == "" {
+		return document{}, errors.New("missing text")
+	}
+	doc := document{key: parsed.Key, text: parsed.Text, fields: parsed.Fields, expires: parsed.Expires}
+	if parsed.Boost != nil {
+		if *parsed.Boost <= 0 {
+			return document{}, errors.New("boost must be a positive number")
+		}
+		doc.boost = *parsed.Boost
+	}
+	return doc, nil
+}
+
+type analyzedDocument struct {
+	doc    document
+	tokens []string
+}
+
+// readCorpusSafely is readCorpus, with a panic while reading the file turned into an error. It runs
+// on the goroutines of buildCorpus, where a panic would not be recovered by the HTTP server.
+func (a *App) readCorpusSafely(
+	source corpusSource, indexOptions IndexOptions, withFilename bool,
+) (docs []analyzedDocument, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			docs, err = nil, &ingestError{
+				http.StatusInternalServerError, errInternal, fmt.Sprintf("error reading %s: %v", path.Base(source.filename), r),
+			}
+		}
+	}()
+	return a.readCorpus(source, indexOptions, withFilename)
+}
+
+// readCorpus extracts and analyzes the documents of a corpus file. Binary formats are extracted,
+// NDJSON files are read as one JSON document per line and anything else as one text document per
+// line. When withFilename is set, the name of the file is stored in the filename field of the
+// documents that do not have one.
+func (a *App) readCorpus(
+	source corpusSource, indexOptions IndexOptions, withFilename bool,
+) ([]analyzedDocument, error) {
+	file, err := source.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	docs := make([]analyzedDocument, 0)
+	add := func(doc document, text string) error {
+		tokens, err := indexOptions.analyzer.Analyze(text)
+		if err != nil {
+			return &ingestError{http.StatusInternalServerError, errInternal, "error while processing text: " + err.Error()}
+		}
+		docs = append(docs, analyzedDocument{doc: doc, tokens: tokens})
+		return nil
+	}
+
+	if extractor, isBinary := extractorFor(source.filename); isBinary {
+		extractedDocs, err := extractDocuments(extractor, file, source.size, source.filename)
+		if err != nil {
+			return nil, &ingestError{
+				http.StatusBadRequest, errInvalidRequest,
+				"error extracting text from " + path.Base(source.filename) + ": " + err.Error(),
+			}
+		}
+		for _, doc := range extractedDocs {
+			if err = add(doc, doc.text); err != nil {
+				return nil, err
+			}
+		}
+		return docs, nil
+	}
+
+	ndjson := isNDJSON(source.filename)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, min(64<<10, a.limits.maxLineSize)), a.limits.maxLineSize)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		doc := document{text: scanner.Text()}
+		if ndjson {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			if doc, err = parseJSONDocument(scanner.Bytes()); err != nil {
+				return nil, &ingestError{
+					http.StatusBadRequest, errInvalidRequest,
+					fmt.Sprintf("invalid document on line %d of %s: %s", lineNumber, source.filename, err),
+				}
+			}
+		}
+		if withFilename {
+			if doc.fields == nil {
+				doc.fields = make(map[string]string, 1)
+			}
+			if _, ok := doc.fields["filename"]; !ok {
+				doc.fields["filename"] = source.filename
+			}
+		}
+		if err = add(doc, doc.text); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		return nil, &ingestError{
+			http.StatusRequestEntityTooLarge, errPayloadTooLarge,
+			fmt.Sprintf(
+				"line %d of %s exceeds the maximum line size of %s",
+				lineNumber+1, source.filename, formatBytes(int64(a.limits.maxLineSize)),
+			),
+		}
+	} else if err != nil {
+		return nil, &ingestError{http.StatusBadRequest, errInvalidRequest, "error reading " + source.filename + ": " + err.Error()}
+	}
+	return docs, nil
+}
+
+// buildCorpus reads corpus files into a new index build. Files are extracted and analyzed concurrently,
+// then added in order, so the documents of each file get a contiguous range of ids following the
+// previous file. Documents with a key keep the id they had in previous, see assignIDs. With corpus
+// stop words, the stop words found are added to the analyzers of indexOptions.
+func (a *App) buildCorpus(
+	sources []corpusSource, indexOptions IndexOptions, previous map[string]uint32,
+) (*indexBuild, error) {
+	withFilename := len(sources) > 1
+	results := make([][]analyzedDocument, len(sources))
+	errs := make([]error, len(sources))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.GOMAXPROCS(0), len(sources)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = a.readCorpusSafely(sources[i], indexOptions, withFilename)
+			}
+		}()
+	}
+	for i := range sources {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var docs []analyzedDocument
+	var keyed bool
+	for i, fileDocs := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for _, analyzed := range fileDocs {
+			keyed = keyed || analyzed.doc.key != ""
+		}
+		docs = append(docs, fileDocs...)
+	}
+	if indexOptions.stopwords.source == CorpusStopwords {
+		removeCorpusStopwords(docs, &indexOptions)
+	}
+	slots, err := assignIDs(docs, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	build := newIndexBuild(indexOptions)
+	for id, analyzed := range slots {
+		if analyzed != nil {
+			err = build.addTokens(analyzed.doc, analyzed.tokens)
+		}
+		// free ids and skipped near-duplicates stay vacant so that keyed documents keep their id
+		if err == nil && keyed && len(build.corpus) <= id {
+			err = build.addVacant()
+		}
+		if err != nil {
+			return nil, &ingestError{http.StatusRequestEntityTooLarge, errPayloadTooLarge, err.Error()}
+		}
+	}
+	return build, nil
+}
+
+// uploadSources lists the files of an upload, expanding archives into the files they contain, which
+// can take at most maxSize bytes once decompressed. cleanup removes the temporary files of the
+// archive once the files have been read.
+func (a *App) uploadSources(
+	file corpusFile, size int64, filename string, maxSize int64,
+) (sources []corpusSource, cleanup func(), err error) {
+	if isArchive(filename) {
+		sources, cleanup, err := archiveSources(file, size, filename, maxSize)
+		var ingestErr *ingestError
+		if errors.As(err, &ingestErr) {
+			return nil, cleanup, err
+		} else if err != nil {
+			return nil, cleanup, &ingestError{
+				http.StatusBadRequest, errInvalidRequest, "error reading archive " + filename + ": " + err.Error(),
+			}
+		}
+		return sources, cleanup, nil
+	}
+	return []corpusSource{{
+		filename: filename,
+		size:     size,
+		open:     func() (corpusFile, error) { return nopCloser{file}, nil },
+	}}, func() {}, nil
+}
+
+// reserveDecompressed reserves the memory to index the files of archives beyond the size of the
+// upload, which was reserved before the archives were read, and returns the number of bytes
+// reserved. It writes a 413 response and returns false if the decompressed files exceed maxSize,
+// and a 507 one if the memory limit would be exceeded.
+func (a *App) reserveDecompressed(w http.ResponseWriter, sources []corpusSource, size int64, maxSize int64) (int64, bool) {
+	var total int64
+	for _, source := range sources {
+		total += source.size
+	}
+	if total > maxSize {
+		writeError(
+			w, http.StatusRequestEntityTooLarge, errPayloadTooLarge,
+			"the uploaded files exceed the maximum upload size of "+formatBytes(maxSize)+" once decompressed",
+		)
+		return 0, false
+	}
+	if total <= size {
+		return 0, true
+	}
+	return total - size, a.reserveUpload(w, total-size)
+}
+
+// nopCloser leaves closing a file to the handler that opened it.
+type nopCloser struct {
+	corpusFile
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func multiFileRequest(t *testing.T, url string, files map[string]string, order []string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, name := range order {
+		part, err := writer.CreateFormFile("corpus", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(files[name]))
+	}
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, url, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func searchResults(t *testing.T, handler http.Handler, url string) []searchResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	var results []searchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestMultiFileUpload(t *testing.T) {
+	handler := NewApp().Routes()
+	files := map[string]string{
+		"a.txt": "red fox\nblue whale\n",
+		"b.txt": "red bird\n",
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", files, []string{"a.txt", "b.txt"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	// documents of each file get consecutive ids, in the order the files were sent
+	results := searchResults(t, handler, "/v1/indexes/default/search?query=red")
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	for _, res := range results {
+		expected := map[uint32]string{0: "a.txt", 2: "b.txt"}[res.Id]
+		if expected == "" || res.Fields["filename"] != expected {
+			t.Errorf("wrong id or filename for %+v", res)
+		}
+	}
+}
+
+func TestArchiveUpload(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, f := range []struct{ name, text string }{{"logs/monday.txt", "disk full\n"}, {"logs/tuesday.txt", "disk replaced\ncpu hot\n"}} {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(f.text))
+	}
+	zw.Close()
+
+	handler := NewApp().Routes()
+	files := map[string]string{"logs.zip": archive.String()}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", files, []string{"logs.zip"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	results := searchResults(t, handler, "/v1/indexes/default/search?query=disk")
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	for _, res := range results {
+		if res.Id == 1 && res.Fields["filename"] != "logs/tuesday.txt" {
+			t.Errorf("wrong filename for %+v", res)
+		}
+	}
+}
+
+func TestArchiveKeepsDocumentFilename(t *testing.T) {
+	handler := NewApp().Routes()
+	files := map[string]string{
+		"notes.txt":   "disk full\n",
+		"docs.ndjson": `{"text": "disk replaced", "fields": {"filename": "report.pdf"}}` + "\n" + `{"text": "disk slow"}` + "\n",
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", files, []string{"notes.txt", "docs.ndjson"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	expected := map[uint32]string{0: "notes.txt", 1: "report.pdf", 2: "docs.ndjson"}
+	for _, res := range searchResults(t, handler, "/v1/indexes/default/search?query=disk") {
+		if res.Fields["filename"] != expected[res.Id] {
+			t.Errorf("expected filename %q for %+v", expected[res.Id], res)
+		}
+	}
+}
+
+func TestDecompressedArchiveLimit(t *testing.T) {
+	tarGz := func(files map[string]string) string {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for name, text := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(text)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(text))
+		}
+		tw.Close()
+		gz.Close()
+		return buf.String()
+	}
+	zipped := func(text string) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		fw, _ := zw.Create("big.txt")
+		fw.Write([]byte(text))
+		zw.Close()
+		return buf.String()
+	}
+
+	app := NewApp()
+	app.limits.maxUploadSize = 64 << 10
+	handler := app.Routes()
+
+	// well within the limit once compressed, far over it once decompressed
+	big := strings.Repeat("the same line over and over\n", 10000)
+	for name, archive := range map[string]string{"big.tar.gz": tarGz(map[string]string{"big.txt": big}), "big.zip": zipped(big)} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{name: archive}, []string{name}))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected %s to be rejected, got status %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	files := map[string]string{"a.tar.gz": tarGz(map[string]string{"a.txt": "red fox\n", "b.txt": "red bird\nblue whale\n"})}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", files, []string{"a.tar.gz"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if results := searchResults(t, handler, "/v1/indexes/default/search?query=red"); len(results) != 2 {
+		t.Errorf("expected 2 results, got %+v", results)
+	}
+}
+
+func TestStableDocumentIDs(t *testing.T) {
+	handler := NewApp().Routes()
+	upload := func(corpus string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	upload(`{"key": "a", "text": "red fox"}
+{"key": "b", "text": "red bird"}
+{"key": "c", "text": "red whale"}
+`)
+	// b is removed and its id goes to the new document, a and c keep theirs despite the new order
+	upload(`{"key": "c", "text": "red whale"}
+{"key": "d", "text": "red cat"}
+{"key": "a", "text": "red fox"}
+`)
+
+	expected := map[string]uint32{"a": 0, "d": 1, "c": 2}
+	results := searchResults(t, handler, "/v1/indexes/default/search?query=red")
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %+v", len(expected), results)
+	}
+	for _, res := range results {
+		if id, ok := expected[res.Key]; !ok || res.Id != id {
+			t.Errorf("document %q has id %d, expected %d", res.Key, res.Id, id)
+		}
+	}
+
+	// a removed document that is not replaced leaves its id vacant
+	upload(`{"key": "c", "text": "red whale"}
+`)
+	results = searchResults(t, handler, "/v1/indexes/default/search?query=red")
+	if len(results) != 1 || results[0].Key != "c" || results[0].Id != 2 {
+		t.Errorf("expected only document c with id 2, got %+v", results)
+	}
+	// vacant ids are not part of the collection statistics
+	var stats IndexStats
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/stats", nil))
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Documents != 1 || stats.AvgDocumentLength != 2 {
+		t.Errorf("expected 1 document of 2 tokens, got %d of %g", stats.Documents, stats.AvgDocumentLength)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": `{"key": "a", "text": "x"}
+{"key": "a", "text": "y"}
+`}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for duplicate keys, got %d", w.Code)
+	}
+}
+
+func TestDocumentBoost(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := `{"text": "red fox"}
+{"text": "red fox", "boost": 2}
+{"text": "red fox", "boost": 0.5}
+{"text": "blue whale"}
+`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	results := searchResults(t, handler, "/v1/indexes/default/search?query=fox")
+	if len(results) != 3 || results[0].Id != 1 || results[1].Id != 0 || results[2].Id != 2 {
+		t.Fatalf("expected documents 1, 0 and 2, got %+v", results)
+	}
+	if results[0].Score != 2*results[1].Score {
+		t.Errorf("expected the boost to double the score, got %v and %v", results[0].Score, results[1].Score)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": `{"text": "x", "boost": -1}`}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a negative boost, got %d", w.Code)
+	}
+}
+
+func TestLoadCorpus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(path, []byte("red fox\nblue whale\nred bird\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp()
+	if err := app.loadCorpus(path); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIds(t, app.Routes(), "/v1/indexes/default/search?query=red"); len(ids) != 2 {
+		t.Errorf("expected the 2 red documents, got %v", ids)
+	}
+	if err := app.loadCorpus(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected an error loading a missing corpus")
+	}
+}
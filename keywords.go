@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultKeywords = 10
+	maxKeywords     = 100
+)
+
+type keyword struct {
+	Term   string  `json:"term"`
+	Weight float64 `json:"weight"`
+}
+
+// Keywords returns the n terms of a document with the highest weights, in descending order.
+func (t *trieSearchIndex) Keywords(id uint32, n int) []keyword {
+	doc := t.docEntries[id]
+	keywords := make([]keyword, 0, len(doc.ids))
+	for i, id := range doc.ids {
+		keywords = append(keywords, keyword{Term: t.vocabulary.token(id), Weight: doc.weights[i]})
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if keywords[i].Weight != keywords[j].Weight {
+			return keywords[i].Weight > keywords[j].Weight
+		}
+		return keywords[i].Term < keywords[j].Term
+	})
+	return keywords[:min(len(keywords), n)]
+}
+
+func (a *App) documentKeywords(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	n := defaultKeywords
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxKeywords {
+			writeError(
+				w, http.StatusBadRequest, errInvalidParameter,
+				"invalid n "+strconv.Quote(s)+", must be an integer between 1 and "+strconv.Itoa(maxKeywords),
+			)
+			return
+		}
+	}
+
+	idString := r.PathValue("id")
+	id, err := strconv.ParseUint(idString, 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "invalid document id "+strconv.Quote(idString))
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if idx.index == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+	if !idx.exists(uint32(id)) {
+		writeError(w, http.StatusNotFound, errDocumentNotFound, "document "+idString+" does not exist")
+		return
+	}
+
+	writeResponse(w, r, idx.index.Keywords(uint32(id), n))
+}
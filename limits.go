@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	maxFormMemory         = 10 << 20  // multipart files above this size are buffered on disk
+	defaultMaxUploadSize  = 100 << 20 // 100 MB
+	defaultMaxChunkedSize = 10 << 30  // 10 GB, all the chunks of an upload session together
+	defaultMaxLineSize    = 1 << 20   // 1 MB
+	defaultMaxExpansions  = 1000
+)
+
+// uploadLimits bound the size of uploaded request bodies, of the chunks of an upload session
+// altogether, and of each line of a text corpus.
+type uploadLimits struct {
+	maxUploadSize  int64
+	maxChunkedSize int64
+	maxLineSize    int
+}
+
+// searchLimits bound the work done by expensive queries.
+type searchLimits struct {
+	maxExpansions   int // terms a query token can expand to in prefix and fuzzy searches
+	minPrefixLength int // characters of the shortest query token of prefix and fuzzy searches
+	defaultSize     int // results of searches without a size, 0 for every match
+	maxSize         int // results of any search, 0 for no maximum
+}
+
+// limitSearch lowers the limits requested by a search to the server limits.
+func (a *App) limitSearch(params *searchParams) {
+	if params.limits.maxExpansions == 0 || params.limits.maxExpansions > a.searchLimits.maxExpansions {
+		params.limits.maxExpansions = a.searchLimits.maxExpansions
+	}
+	params.limits.minPrefixLength = a.searchLimits.minPrefixLength
+}
+
+// limitSize applies the default and maximum number of results of the server and of idx, which can be
+// nil, to a search. The default of the index takes precedence, and the lower maximum applies. It
+// returns the maximum if the size of the search was lowered to it, and 0 otherwise.
+func (a *App) limitSize(params *searchParams, idx *namedIndex) int {
+	defaultSize, maxSize := a.searchLimits.defaultSize, a.searchLimits.maxSize
+	if idx != nil {
+		idx.lock.RLock()
+		defaultSize = cmp.Or(idx.options.defaultSize, defaultSize)
+		if idx.options.maxSize > 0 && (maxSize == 0 || idx.options.maxSize < maxSize) {
+			maxSize = idx.options.maxSize
+		}
+		idx.lock.RUnlock()
+	}
+	if params.size == 0 {
+		params.size = defaultSize
+	}
+	if maxSize > 0 && (params.size == 0 || params.size > maxSize) {
+		params.size = maxSize
+		return maxSize
+	}
+	return 0
+}
+
+// errShortPrefix is returned by prefix and fuzzy searches for tokens shorter than the minimum prefix length.
+var errShortPrefix = errors.New("query token too short")
+
+// truncatedHeader is set on search responses when some terms a query expanded to were left out.
+const truncatedHeader = "Stellr-Truncated"
+
+// maxSizeHeader is set on search responses to the maximum number of results when the requested
+// size was lowered to it.
+const maxSizeHeader = "Stellr-Max-Size"
+
+// limitBody makes reading more than the maximum upload size from the request body fail.
+func (a *App) limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, a.limits.maxUploadSize)
+}
+
+// bodyTooLarge writes a 413 response and returns true if err was caused by the request body
+// exceeding the maximum upload size.
+func (a *App) bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	writeError(
+		w, http.StatusRequestEntityTooLarge, errPayloadTooLarge,
+		"request body exceeds the maximum upload size of "+formatBytes(maxBytesErr.Limit),
+	)
+	return true
+}
+
+// formatBytes formats a size using the largest binary unit it is a multiple of.
+func formatBytes(n int64) string {
+	for _, unit := range []struct {
+		name string
+		size int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n >= unit.size && n%unit.size == 0 {
+			return fmt.Sprintf("%d %s", n/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultRerankTop = 100
+	maxRerankTop     = 1000
+)
+
+// TermFeatures are the ranking features of a query term in a document.
+type TermFeatures struct {
+	Term   string  `json:"term"`
+	TF     int     `json:"tf"`     // occurrences in the document
+	IDF    float64 `json:"idf"`    // log(N/df)
+	Weight float64 `json:"weight"` // of the term in the document, as computed by the index similarity
+}
+
+// HitFeatures are the ranking features of a search result, exported for training ranking models
+// offline and passed to a Reranker.
+type HitFeatures struct {
+	Score        float64        `json:"score"`  // of the similarity of the index
+	Length       int            `json:"length"` // tokens of the document
+	MatchedTerms int            `json:"matched_terms"`
+	Terms        []TermFeatures `json:"terms"`         // of every distinct query term
+	FieldMatches int            `json:"field_matches"` // query terms found in the fields of the document
+	// Proximity is the number of tokens of the shortest span of the text containing every matched
+	// term, 0 if fewer than two terms match.
+	Proximity int `json:"proximity"`
+}
+
+// featureNames are the names of the features of a hit in linear models. Per-term features are summed
+// over the matched terms.
+var featureNames = []string{"score", "length", "matched_terms", "field_matches", "proximity", "tf", "idf", "weight"}
+
+// Vector returns the features of a hit by name.
+func (f HitFeatures) Vector() map[string]float64 {
+	vector := map[string]float64{
+		"score":         f.Score,
+		"length":        float64(f.Length),
+		"matched_terms": float64(f.MatchedTerms),
+		"field_matches": float64(f.FieldMatches),
+		"proximity":     float64(f.Proximity),
+	}
+	for _, term := range f.Terms {
+		if term.TF > 0 {
+			vector["tf"] += float64(term.TF)
+			vector["idf"] += term.IDF
+			vector["weight"] += term.Weight
+		}
+	}
+	return vector
+}
+
+// Reranker scores search results from their features, to rank them with a model trained offline.
+type Reranker interface {
+	Score(features HitFeatures) float64
+}
+
+// RerankerFunc adapts an ordinary function to the Reranker interface.
+type RerankerFunc func(features HitFeatures) float64
+
+func (f RerankerFunc) Score(features HitFeatures) float64 {
+	return f(features)
+}
+
+// LinearModel scores results with a weighted sum of their features, plus a bias.
+type LinearModel struct {
+	Bias    float64            `json:"bias"`
+	Weights map[string]float64 `json:"weights"` // by feature name
+}
+
+func (m *LinearModel) Score(features HitFeatures) float64 {
+	score := m.Bias
+	for name, value := range features.Vector() {
+		score += m.Weights[name] * value
+	}
+	return score
+}
+
+// loadRankingModels reads linear models from a JSON object mapping model names to a bias and
+// weights by feature name.
+func loadRankingModels(path string) (map[string]Reranker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var models map[string]*LinearModel
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, fmt.Errorf("invalid ranking models file: %w", err)
+	}
+	rerankers := make(map[string]Reranker, len(models))
+	for name, model := range models {
+		if model == nil {
+			return nil, fmt.Errorf("ranking model %s has no weights", name)
+		}
+		for feature := range model.Weights {
+			if !slices.Contains(featureNames, feature) {
+				return nil, fmt.Errorf("ranking model %s has an unknown feature %s", name, feature)
+			}
+		}
+		rerankers[name] = model
+	}
+	return rerankers, nil
+}
+
+// RegisterReranker makes a ranking model available to searches by name, replacing any model with
+// the same name.
+func (a *App) RegisterReranker(name string, reranker Reranker) {
+	if a.rerankers == nil {
+		a.rerankers = make(map[string]Reranker)
+	}
+	a.rerankers[name] = reranker
+}
+
+// Features returns the features of a document for the query tokens that come from the index, all
+// but the score, field matches and proximity.
+func (t *trieSearchIndex) Features(id uint32, tokens []string) HitFeatures {
+	terms := t.docTerms[id]
+	features := HitFeatures{Length: terms.length, Terms: make([]TermFeatures, 0, len(tokens))}
+	seen := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		term := TermFeatures{Term: token}
+		if tokenID, ok := t.vocabulary.id(token); ok {
+			term.TF = terms.counts[tokenID]
+			term.IDF = StandardIdf.idf(t.stats.NumDocs, t.df[tokenID])
+			term.Weight = t.docEntries[id].weight(tokenID)
+		}
+		if term.TF > 0 {
+			features.MatchedTerms++
+		}
+		features.Terms = append(features.Terms, term)
+	}
+	return features
+}
+
+// features returns the features of a result for the query tokens. The text and fields of the
+// document are analyzed again for field matches and proximity.
+func (idx *namedIndex) features(res RankResult, tokens []string) (HitFeatures, error) {
+	features := idx.index.Features(res.id, tokens)
+	features.Score = res.score
+	doc := idx.corpus[res.id]
+
+	query := make(map[string]bool, len(features.Terms))
+	matched := make(map[string]bool, len(features.Terms))
+	for _, term := range features.Terms {
+		query[term.Term] = true
+		if term.TF > 0 {
+			matched[term.Term] = true
+		}
+	}
+	inFields := make(map[string]bool)
+	for _, value := range doc.fields {
+		fieldTokens, err := idx.options.analyzer.Analyze(value)
+		if err != nil {
+			return features, err
+		}
+		for _, token := range fieldTokens {
+			if query[token] {
+				inFields[token] = true
+			}
+		}
+	}
+	features.FieldMatches = len(inFields)
+
+	if len(matched) > 1 {
+		textTokens, err := idx.options.analyzer.Analyze(doc.text)
+		if err != nil {
+			return features, err
+		}
+		features.Proximity = shortestSpan(textTokens, matched)
+	}
+	return features, nil
+}
+
+// shortestSpan returns the number of tokens of the shortest span of tokens containing every term,
+// or 0 if some term is missing.
+func shortestSpan(tokens []string, terms map[string]bool) int {
+	counts := make(map[string]int, len(terms))
+	var found, best, start int
+	for end, token := range tokens {
+		if !terms[token] {
+			continue
+		}
+		if counts[token]++; counts[token] == 1 {
+			found++
+		}
+		for found == len(terms) {
+			if best == 0 || end-start+1 < best {
+				best = end - start + 1
+			}
+			if first := tokens[start]; terms[first] {
+				if counts[first]--; counts[first] == 0 {
+					found--
+				}
+			}
+			start++
+		}
+	}
+	return best
+}
+
+// rerank orders the first params.rerankTop results by the score of params.reranker, returning
+// these scores by document id. Results after them keep their order.
+func (idx *namedIndex) rerank(ranked []RankResult, tokens []string, params searchParams) ([]RankResult, map[uint32]float64, error) {
+	n := min(params.rerankTop, len(ranked))
+	scores := make(map[uint32]float64, n)
+	for _, res := range ranked[:n] {
+		features, err := idx.features(res, tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		scores[res.id] = params.reranker.Score(features)
+	}
+	top := slices.Clone(ranked[:n])
+	// results with equal model scores keep their order
+	sort.SliceStable(top, func(i, j int) bool { return scores[top[i].id] > scores[top[j].id] })
+	return append(top, ranked[n:]...), scores, nil
+}
+
+// parseReranking reads the features, rerank and rerank_top parameters of a search. The model named
+// by rerank is looked up by the caller.
+func parseReranking(get func(string) string, params *searchParams) error {
+	var err error
+	if params.features, err = parseBool("features", get("features")); err != nil {
+		return err
+	}
+	params.rerankModel = get("rerank")
+	if params.rerankModel == "" {
+		if get("rerank_top") != "" {
+			return fmt.Errorf("rerank_top is only valid with rerank")
+		}
+		return nil
+	}
+	if params.sort != nil || params.diversify {
+		return fmt.Errorf("rerank cannot be combined with sort or diversify")
+	}
+	params.rerankTop = defaultRerankTop
+	if s := get("rerank_top"); s != "" {
+		if params.rerankTop, err = strconv.Atoi(s); err != nil || params.rerankTop < 1 || params.rerankTop > maxRerankTop {
+			return fmt.Errorf("invalid rerank_top %q, must be an integer between 1 and %d", s, maxRerankTop)
+		}
+	}
+	return nil
+}
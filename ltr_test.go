@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestShortestSpan(t *testing.T) {
+	tokens := []string{"red", "big", "lazy", "fox", "sleeps", "red", "fox"}
+	if span := shortestSpan(tokens, map[string]bool{"red": true, "fox": true}); span != 2 {
+		t.Errorf("expected a span of 2, got %d", span)
+	}
+	if span := shortestSpan(tokens, map[string]bool{"red": true, "whale": true}); span != 0 {
+		t.Errorf("expected no span for a missing term, got %d", span)
+	}
+}
+
+func TestReranking(t *testing.T) {
+	app := NewApp()
+	// longer documents first
+	app.RegisterReranker("long", RerankerFunc(func(features HitFeatures) float64 { return float64(features.Length) }))
+	handler := app.Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred big lazy fox\nblue whale\n"))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red+fox&features=true", nil))
+	var results []searchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Features == nil || results[1].Features == nil {
+		t.Fatalf("expected 2 results with features, got %+v", results)
+	}
+	if f := results[0].Features; f.Length != 2 || f.MatchedTerms != 2 || f.Proximity != 2 || f.Score <= results[1].Features.Score {
+		t.Errorf("unexpected features of the exact match: %+v", f)
+	}
+	if f := results[1].Features; f.Length != 4 || f.Proximity != 4 || f.Terms[0].TF != 1 || f.Terms[0].IDF <= 0 {
+		t.Errorf("unexpected features of the longer document: %+v", f)
+	}
+
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox&rerank=long"); !reflect.DeepEqual(ids, []uint32{1, 0}) {
+		t.Errorf("expected the longer document first, got %v", ids)
+	}
+	// only the first result is reranked
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox&rerank=long&rerank_top=1"); !reflect.DeepEqual(ids, []uint32{0, 1}) {
+		t.Errorf("expected the order of the similarity, got %v", ids)
+	}
+
+	for _, params := range []string{"rerank=short", "rerank_top=10", "rerank=long&rerank_top=0", "rerank=long&sort=id", "rerank=long&diversify=true"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red&"+params, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got status %d", params, w.Code)
+		}
+	}
+}
+
+func TestLoadRankingModels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	os.WriteFile(path, []byte(`{"short": {"bias": 1, "weights": {"score": 2, "length": -0.5}}}`), 0o600)
+	models, err := loadRankingModels(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := models["short"].Score(HitFeatures{Score: 1, Length: 4}); score != 1 {
+		t.Errorf("expected a score of 1, got %v", score)
+	}
+
+	os.WriteFile(path, []byte(`{"short": {"weights": {"clicks": 1}}}`), 0o600)
+	if _, err := loadRankingModels(path); err == nil {
+		t.Error("expected an unknown feature to be rejected")
+	}
+}
@@ -1,30 +1,29 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"maps"
 	"math"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
-	"unicode"
+	"time"
+	"unicode/utf8"
 
 	"github.com/RoaringBitmap/roaring"
-	"github.com/kljensen/snowball"
-	"github.com/kljensen/snowball/english"
-	"github.com/kljensen/snowball/french"
-	"github.com/kljensen/snowball/hungarian"
-	"github.com/kljensen/snowball/norwegian"
-	"github.com/kljensen/snowball/russian"
-	"github.com/kljensen/snowball/spanish"
-	"github.com/kljensen/snowball/swedish"
+
+	"stellr/analysis"
 )
 
 const (
-	maxLineSize     = 1 << 20 // 1 MB
 	defaultLanguage = "english"
 	defaultStem     = false
 )
@@ -45,74 +44,103 @@ const (
 	And
 )
 
-func tokenize(text string) []string {
-	text = strings.ToLower(text)
-	tokens := strings.FieldsFunc(text, func(r rune) bool {
-		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
-	})
-	return tokens
-}
+const maxDistance = 3
 
-func filterStopWords(tokens []string, language string) []string {
-	stopWordFuncs := map[string]func(string) bool{
-		"english":   english.IsStopWord,
-		"french":    french.IsStopWord,
-		"hungarian": hungarian.IsStopWord,
-		"norwegian": norwegian.IsStopWord,
-		"russian":   russian.IsStopWord,
-		"spanish":   spanish.IsStopWord,
-		"swedish":   swedish.IsStopWord,
+func parseSearchType(s string) (SearchType, error) {
+	switch s {
+	case "", "exact":
+		return ExactSearch, nil
+	case "prefix":
+		return PrefixSearch, nil
+	case "fuzzy":
+		return FuzzySearch, nil
 	}
+	return ExactSearch, fmt.Errorf("invalid type %q, must be one of exact, prefix or fuzzy", s)
+}
 
-	isStopWord, ok := stopWordFuncs[language]
-	if !ok {
-		return tokens
+func parseOperator(s string) (Operator, error) {
+	switch s {
+	case "", "or":
+		return Or, nil
+	case "and":
+		return And, nil
 	}
+	return Or, fmt.Errorf("invalid operator %q, must be either and or or", s)
+}
 
-	var result []string
-	for _, token := range tokens {
-		if !isStopWord(token) {
-			result = append(result, token)
-		}
+func parseDistance(s string, searchType SearchType) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if searchType != FuzzySearch {
+		return 0, fmt.Errorf("distance is only valid for fuzzy search")
 	}
-	return result
-}
 
-func stemTokens(tokens []string, language string) ([]string, error) {
-	for i, token := range tokens {
-		stemmed, err := snowball.Stem(token, language, false)
-		if err != nil {
-			return nil, err
-		}
-		tokens[i] = stemmed
+	dist, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid distance %q, must be an integer", s)
+	}
+	if dist < 0 || dist > maxDistance {
+		return 0, fmt.Errorf("invalid distance %d, must be between 0 and %d", dist, maxDistance)
 	}
-	return tokens, nil
+	return dist, nil
 }
 
-// ProcessText performs tokenization, stop word filtering, and stemming on the given text.
-func ProcessText(text string, language string, stem bool) ([]string, error) {
-	tokens := tokenize(text)
-	tokens = filterStopWords(tokens, language)
+// defaultExpansionPenalty is the default multiplier of the weights of the terms prefix and fuzzy
+// searches expand query tokens to, so that documents with the exact tokens rank first.
+const defaultExpansionPenalty = 0.5
 
-	var err error
-	if stem {
-		tokens, err = stemTokens(tokens, language)
-		if err != nil {
-			return nil, err
+func parseExpansionPenalty(s string, searchType SearchType) (float64, error) {
+	if s == "" {
+		if searchType == ExactSearch {
+			return 1, nil
 		}
+		return defaultExpansionPenalty, nil
 	}
-
-	return tokens, nil
+	if searchType == ExactSearch {
+		return 0, fmt.Errorf("expansion_penalty is only valid for prefix and fuzzy search")
+	}
+	penalty, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(penalty > 0 && penalty <= 1) {
+		return 0, fmt.Errorf("invalid expansion_penalty %q, must be a number greater than 0 and at most 1", s)
+	}
+	return penalty, nil
 }
 
 type IndexBuilder interface {
 	Add(tokens []string, id uint32)
+	// AddVacant reserves an id for a document that is not in this version of the index. Vacant ids
+	// do not count as documents in the collection statistics.
+	AddVacant(id uint32)
+	// Boost multiplies the scores of a document that was already added.
+	Boost(id uint32, boost float64)
 	Build() SearchIndex
 }
 
 type SearchIndex interface {
-	Search(query string, searchType SearchType, operator Operator, distance int) (*IndexResult, error)
-	Rank(tokens []string, docIds []uint32) []RankResult
+	Search(
+		ctx context.Context, query string, searchType SearchType, operator Operator, distance int, limits searchLimits,
+	) (*IndexResult, error)
+	SearchTokens(
+		ctx context.Context, tokens []string, searchType SearchType, operator Operator, distance int, limits searchLimits,
+	) (*IndexResult, error)
+	SearchRange(ctx context.Context, from, to string, limits searchLimits) (*IndexResult, error)
+	Rank(ctx context.Context, tokens []string, docIds []uint32) ([]RankResult, error)
+	RankTop(ctx context.Context, tokens []string, candidates *roaring.Bitmap, k int) ([]RankResult, error)
+	Diversify(ctx context.Context, ranked []RankResult, n int, lambda float64) ([]RankResult, error)
+	Expand(tokens []string, top []RankResult, n int) *IndexResult
+	RankWeighted(
+		ctx context.Context, tokens []string, multipliers map[string]float64, candidates *roaring.Bitmap, k int,
+	) ([]RankResult, error)
+	Features(id uint32, tokens []string) HitFeatures
+	MaxScore(tokens []string) float64
+	NumDocs() int
+	Stats() IndexStats
+	Keywords(id uint32, n int) []keyword
+	TermVector(id uint32) map[string]termVectorEntry
+	Suggest(ctx context.Context, prefix string, limit int, limits searchLimits) ([]termDocFreq, error)
+	Terms(after string, limit int) ([]termDocFreq, bool)
+	EstimatedMemory() int64
 }
 
 type RankResult struct {
@@ -121,60 +149,205 @@ type RankResult struct {
 }
 
 type IndexOptions struct {
-	language string
-	stem     bool
+	language       string
+	stem           bool
+	filters        []string
+	searchFilters  []string // replace filters when analyzing queries, nil to use the same filters
+	tokenizer      analysis.Tokenizer
+	mapping        map[string]string
+	length         analysis.LengthFilter
+	analyzer       *analysis.Analyzer
+	searchAnalyzer *analysis.Analyzer
+	similarity     Similarity
+	dedup          DedupOptions
+	stopwords      StopwordOptions
+	// characters of the shortest query token of prefix and fuzzy searches
+	minPrefixLength int
+	defaultSize     int                   // results of searches without a size, 0 for the server default
+	maxSize         int                   // results of any search, 0 for the server maximum
+	rankingProfiles map[string]url.Values // search parameters by profile name
+}
+
+type docTerms struct {
+	counts   map[uint32]int // by token id
+	maxCount int
+	length   int
+	boost    float64
 }
 
 type trieIndexBuilder struct {
-	invIndex      *PatriciaTrie
-	wordFreqArray []map[string]float64
-	options       IndexOptions
+	invIndex   *PatriciaTrie
+	vocabulary *vocabulary
+	docTerms   []docTerms
+	options    IndexOptions
+	base       *trieSearchIndex // index the documents were copied from, whose weights can be reused
+	changed    int              // documents added or removed since base
 }
 
 type docEntry struct {
-	tfIdf map[string]float64
-	norm  float64
+	ids     []uint32  // of the tokens of the document, sorted
+	weights []float64 // of the tokens of ids
+	norm    float64
+	length  int
+	boost   float64 // multiplies the score of the document
+}
+
+// weight returns the weight of a token in the document, 0 if the document does not contain it.
+func (d *docEntry) weight(id uint32) float64 {
+	if i, ok := slices.BinarySearch(d.ids, id); ok {
+		return d.weights[i]
+	}
+	return 0
 }
 
 type trieSearchIndex struct {
-	invIndex   *PatriciaTrie
-	idf        map[string]float64
+	invIndex   *flatTrie
+	vocabulary *vocabulary
+	df         []uint64   // by token id
+	docTerms   []docTerms // kept so that weights can be recomputed when merging
 	docEntries []*docEntry
+	maxImpact  []float64 // highest scaled weight of each token id, nil if the similarity is not scaled
+	stale      int       // documents added or removed since all weights were computed
 	options    IndexOptions
-	defaultIdf float64
+	stats      CollectionStats
+	statsCache indexStatsCache
 }
 
-func (t *trieSearchIndex) Rank(tokens []string, docIds []uint32) []RankResult {
-	termFreqs := getTermFrequency(tokens)
-	result := make([]RankResult, len(docIds))
+// queryTerms returns the statistics of each distinct query token.
+func (t *trieSearchIndex) queryTerms(tokens []string) map[string]TermStats {
+	counts, maxCount := termCounts(tokens)
+	terms := make(map[string]TermStats, len(counts))
+	for token, count := range counts {
+		terms[token] = TermStats{Count: count, MaxCount: maxCount, Length: len(tokens), DocFreq: t.docFreq(token)}
+	}
+	return terms
+}
 
-	var doc *docEntry
-	for i, id := range docIds {
-		var refValue, invNorm, queryNorm float64
-		doc = t.docEntries[id]
-		for token, value := range termFreqs {
-			tokenIdf, ok := t.idf[token]
-			if !ok {
-				tokenIdf = t.defaultIdf
-			}
-			refValue = doc.tfIdf[token]
-			result[i].id = id
-			result[i].score += value * tokenIdf * refValue
-			queryNorm += value * value * tokenIdf * tokenIdf
+// docFreq returns the number of documents containing a token.
+func (t *trieSearchIndex) docFreq(token string) uint64 {
+	if id, ok := t.vocabulary.id(token); ok {
+		return t.df[id]
+	}
+	return 0
+}
+
+type queryWeight struct {
+	token  string
+	id     uint32
+	weight float64
+}
+
+// queryWeights returns the weight of each distinct query token found in the index, sorted by token
+// so that scores are always summed in the same order, and the norm of the query vector. The weights
+// of the tokens of multipliers, which can be nil, are multiplied by their value.
+func (t *trieSearchIndex) queryWeights(tokens []string, multipliers map[string]float64) ([]queryWeight, float64) {
+	similarity := t.options.similarity
+	sorted := make([]queryWeight, 0, len(tokens))
+	for token, term := range t.queryTerms(tokens) {
+		weight := similarity.TermWeight(term, t.stats, true)
+		if multiplier, ok := multipliers[token]; ok {
+			weight *= multiplier
 		}
+		sorted = append(sorted, queryWeight{token: token, weight: weight})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].token < sorted[j].token })
+	weights := make([]float64, len(sorted))
+	for i, query := range sorted {
+		weights[i] = query.weight
+	}
+	norm := similarity.DocNorm(weights)
+
+	// tokens missing from the index add to the norm but match no document
+	known := sorted[:0]
+	for _, query := range sorted {
+		if id, ok := t.vocabulary.id(query.token); ok {
+			query.id = id
+			known = append(known, query)
+		}
+	}
+	return known, norm
+}
 
-		invNorm = 1 / math.Sqrt(queryNorm*doc.norm+1e-8)
-		result[i].score = result[i].score * invNorm
+func (t *trieSearchIndex) score(id uint32, queryWeights []queryWeight, queryNorm float64) float64 {
+	var dot float64
+	doc := t.docEntries[id]
+	for _, query := range queryWeights {
+		dot += query.weight * doc.weight(query.id)
+	}
+	return t.options.similarity.Combine(dot, queryNorm, doc.norm, t.stats) * doc.boost
+}
+
+// Rank scores the documents and sorts them by descending score. It returns the error of ctx if ctx
+// is done before all documents are scored.
+func (t *trieSearchIndex) Rank(ctx context.Context, tokens []string, docIds []uint32) ([]RankResult, error) {
+	queryWeights, queryNorm := t.queryWeights(tokens, nil)
+	return t.rank(ctx, queryWeights, queryNorm, docIds)
+}
+
+// rank is Rank for a query with the given weights and norm. Large sets of documents are scored by
+// rankWorkers goroutines, each on a contiguous part of docIds.
+func (t *trieSearchIndex) rank(ctx context.Context, queryWeights []queryWeight, queryNorm float64, docIds []uint32) ([]RankResult, error) {
+	result := make([]RankResult, len(docIds))
+	err := inParallel(partitions(len(docIds)), func(_, from, to int) error {
+		for i := from; i < to; i++ {
+			if (i-from)%cancelCheckInterval == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			result[i].id = docIds[i]
+			result[i].score = t.score(docIds[i], queryWeights, queryNorm)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	sort.Slice(result, func(i, j int) bool {
+	// documents with equal scores stay in id order
+	sort.SliceStable(result, func(i, j int) bool {
 		return result[i].score > result[j].score // descending order
 	})
-	return result
+	return result, nil
+}
+
+// MaxScore returns the highest score any document can get for the given query tokens,
+// or 0 if the similarity does not bound its scores.
+func (t *trieSearchIndex) MaxScore(tokens []string) float64 {
+	bounded, ok := t.options.similarity.(BoundedSimilarity)
+	if !ok {
+		return 0
+	}
+	return bounded.MaxScore(t.queryTerms(tokens), t.stats)
+}
+
+// NumDocs returns the number of documents in the collection statistics, which leave out vacant ids.
+func (t *trieSearchIndex) NumDocs() int {
+	return t.stats.NumDocs
 }
 
+// Search returns the documents matching the query, analyzed with the query analyzer of the index,
+// like SearchTokens.
 func (t *trieSearchIndex) Search(
-	query string, searchType SearchType, operator Operator, distance int,
+	ctx context.Context, query string, searchType SearchType, operator Operator, distance int, limits searchLimits,
+) (*IndexResult, error) {
+	start := time.Now()
+	tokens, err := t.options.queryAnalyzer().Analyze(query)
+	if err != nil {
+		return nil, err
+	}
+	if profile := profileOf(ctx); profile != nil {
+		profile.Analysis = millisecondsSince(start)
+	}
+	return t.SearchTokens(ctx, tokens, searchType, operator, distance, limits)
+}
+
+// SearchTokens returns the documents matching the tokens of an analyzed query. Prefix and fuzzy
+// searches can expand a token to many terms, at most limits.maxExpansions of them if it is
+// positive, so the error of ctx is returned if ctx is done before every token is expanded. They
+// fail with errShortPrefix for tokens shorter than the minimum prefix length of the index or of
+// limits. A token matches the documents containing any of its terms, and with the And operator
+// documents must match every token.
+func (t *trieSearchIndex) SearchTokens(
+	ctx context.Context, tokens []string, searchType SearchType, operator Operator, distance int, limits searchLimits,
 ) (*IndexResult, error) {
 	var searchFn func(key string) *IndexResult
 
@@ -182,9 +355,11 @@ func (t *trieSearchIndex) Search(
 	case ExactSearch:
 		searchFn = t.invIndex.Search
 	case PrefixSearch:
-		searchFn = t.invIndex.StartsWith
+		searchFn = func(key string) *IndexResult { return t.invIndex.StartsWith(ctx, key, limits.maxExpansions) }
 	case FuzzySearch:
-		searchFn = func(key string) *IndexResult { return t.invIndex.FuzzySearch(key, distance) }
+		searchFn = func(key string) *IndexResult {
+			return t.invIndex.FuzzySearch(ctx, key, distance, limits.maxExpansions)
+		}
 	}
 
 	var res *IndexResult
@@ -196,46 +371,79 @@ func (t *trieSearchIndex) Search(
 	} else {
 		combineFn = r.CombineOr
 	}
-	tokens, err := ProcessText(query, t.options.language, t.options.stem)
-	if err != nil {
-		return nil, err
+	profile := profileOf(ctx)
+	if searchType != ExactSearch {
+		if err := t.checkPrefixLength(tokens, limits); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, token := range tokens {
-		if res = searchFn(token); res != nil {
-			combineFn(res)
+		start := time.Now()
+		if res = searchFn(token); res == nil {
+			// no document contains the token, which still empties the results of an And search
+			res = &IndexResult{set: roaring.New()}
+		}
+		if profile != nil {
+			profile.Terms = append(profile.Terms, termProfile{
+				Token: token, Expansions: len(res.tokens), Documents: res.set.GetCardinality(), Lookup: millisecondsSince(start),
+			})
+			start = time.Now()
+		}
+		combineFn(res)
+		if profile != nil {
+			profile.Combine += millisecondsSince(start)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if searchType != ExactSearch {
+		r.exact = make(map[string]bool, len(tokens))
+		for _, token := range tokens {
+			r.exact[token] = true
 		}
 	}
 	return r, nil
 }
 
-func NewTrieIndex(opts IndexOptions) IndexBuilder {
-	return &trieIndexBuilder{
-		invIndex:      NewPatriciaTrie(),
-		wordFreqArray: make([]map[string]float64, 0),
-		options:       opts,
+// SearchRange returns the documents containing a term from from, inclusive, to to, exclusive, in
+// lexicographic order. An empty to has no upper bound. A range can span many terms, at most
+// limits.maxExpansions of them if it is positive. The bounds are compared with the terms as indexed,
+// so they are not analyzed.
+func (t *trieSearchIndex) SearchRange(ctx context.Context, from, to string, limits searchLimits) (*IndexResult, error) {
+	res := t.invIndex.Range(ctx, from, to, limits.maxExpansions)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
-}
-
-func computeNorm(tfIdf map[string]float64) float64 {
-	var norm float64
-	for _, value := range tfIdf {
-		norm += value * value
+	if res == nil {
+		res = &IndexResult{set: roaring.New()}
 	}
-	return norm
+	return res, nil
 }
 
-func getTermFrequency(tokens []string) map[string]float64 {
-	termCounts := make(map[string]int)
-	nTokens := float64(len(tokens))
+// checkPrefixLength returns errShortPrefix if a token is shorter than the minimum prefix length of
+// the index or of limits.
+func (t *trieSearchIndex) checkPrefixLength(tokens []string, limits searchLimits) error {
+	minLength := max(limits.minPrefixLength, t.options.minPrefixLength)
 	for _, token := range tokens {
-		termCounts[token]++
+		if utf8.RuneCountInString(token) < minLength {
+			return fmt.Errorf(
+				"%w: prefix and fuzzy searches need tokens of at least %d characters, %q is shorter",
+				errShortPrefix, minLength, token,
+			)
+		}
 	}
-	termFreqs := make(map[string]float64, len(termCounts))
-	for token, count := range termCounts {
-		termFreqs[token] = float64(count) / nTokens
+	return nil
+}
+
+func NewTrieIndex(opts IndexOptions) IndexBuilder {
+	return &trieIndexBuilder{
+		invIndex:   NewPatriciaTrie(),
+		vocabulary: newVocabulary(),
+		docTerms:   make([]docTerms, 0),
+		options:    opts,
 	}
-	return termFreqs
 }
 
 func (index *trieIndexBuilder) Add(tokens []string, id uint32) {
@@ -252,73 +460,207 @@ func (index *trieIndexBuilder) Add(tokens []string, id uint32) {
 		index.invIndex.Insert(token, set)
 	}
 
-	termFreqs := getTermFrequency(tokens)
-	index.wordFreqArray = append(index.wordFreqArray, termFreqs)
+	counts, maxCount := termCounts(tokens)
+	ids := make(map[uint32]int, len(counts))
+	for token, count := range counts {
+		ids[index.vocabulary.intern(token)] = count
+	}
+	index.docTerms = append(index.docTerms, docTerms{counts: ids, maxCount: maxCount, length: len(tokens), boost: 1})
+}
+
+func (index *trieIndexBuilder) AddVacant(id uint32) {
+	// documents without counts are not indexed, like the documents cleared by a purge
+	index.docTerms = append(index.docTerms, docTerms{})
+}
+
+func (index *trieIndexBuilder) Boost(id uint32, boost float64) {
+	index.docTerms[id].boost = boost
 }
 
 func (builder *trieIndexBuilder) Build() SearchIndex {
-	idf := make(map[string]float64, 0)
-	nDocs := len(builder.wordFreqArray)
+	df := make([]uint64, builder.vocabulary.size())
 
+	// postings never change once built, so they are converted to run containers where it saves space
 	tokenSets := builder.invIndex.Traversal()
-	var cardinality uint64
 	for _, tokenSet := range tokenSets {
-		cardinality = tokenSet.set.GetCardinality()
-		idf[tokenSet.token] = math.Log(float64(nDocs) / float64(cardinality))
+		tokenSet.set.RunOptimize()
+		if id, ok := builder.vocabulary.id(tokenSet.token); ok {
+			df[id] = tokenSet.set.GetCardinality()
+		}
+	}
+
+	// vacant ids, of purged documents and reserved for keyed ones, are not part of the collection
+	var nDocs, totalLength int
+	for _, terms := range builder.docTerms {
+		if terms.counts != nil {
+			nDocs++
+			totalLength += terms.length
+		}
+	}
+	stats := CollectionStats{NumDocs: nDocs}
+	if nDocs > 0 {
+		stats.AvgLength = float64(totalLength) / float64(nDocs)
 	}
 
-	docEntries := make([]*docEntry, len(builder.wordFreqArray))
+	similarity := builder.options.similarity
+	docEntries := make([]*docEntry, len(builder.docTerms))
 	var doc *docEntry
-	for i, wordFreq := range builder.wordFreqArray {
-		doc = &docEntry{}
-		for token, freq := range wordFreq {
-			tokenIdf, ok := idf[token]
-			if !ok {
-				panic("error: no IDF found")
+	var totalNorm float64
+	base, stale := builder.incrementalBase()
+	for i, terms := range builder.docTerms {
+		if base != nil && base.reusable(i, terms, df) {
+			docEntries[i] = base.docEntries[i]
+			continue
+		}
+		doc = &docEntry{
+			ids:     slices.Sorted(maps.Keys(terms.counts)),
+			weights: make([]float64, len(terms.counts)),
+			length:  terms.length,
+			boost:   terms.boost,
+		}
+		for i, id := range doc.ids {
+			if df[id] == 0 {
+				panic("error: no document frequency found")
 			}
-			wordFreq[token] = freq * tokenIdf
+			term := TermStats{Count: terms.counts[id], MaxCount: terms.maxCount, Length: terms.length, DocFreq: df[id]}
+			doc.weights[i] = similarity.TermWeight(term, stats, false)
 		}
-		doc.tfIdf = wordFreq
-		doc.norm = computeNorm(doc.tfIdf)
-
+		doc.norm = similarity.DocNorm(doc.weights)
 		docEntries[i] = doc
 	}
+	for i, terms := range builder.docTerms {
+		if terms.counts != nil {
+			totalNorm += math.Sqrt(docEntries[i].norm)
+		}
+	}
+	if nDocs > 0 {
+		stats.AvgNorm = totalNorm / float64(nDocs)
+	}
 
 	return &trieSearchIndex{
-		invIndex:   builder.invIndex,
-		idf:        idf,
+		invIndex:   newFlatTrie(builder.invIndex),
+		vocabulary: builder.vocabulary,
+		df:         df,
+		docTerms:   builder.docTerms,
 		docEntries: docEntries,
-		defaultIdf: math.Log(1 / float64(nDocs+1)),
+		maxImpact:  maxImpacts(similarity, docEntries, stats, len(df)),
+		stale:      stale,
 		options:    builder.options,
+		stats:      stats,
 	}
 }
 
-type App struct {
-	indexBuilder IndexBuilder
-	index        SearchIndex
-	corpus       []string
-	indexLock    sync.RWMutex
+type document struct {
+	key     string // external id, empty if the document has none
+	text    string
+	fields  map[string]string
+	expires time.Time // zero if the document does not expire
+	boost   float64   // score multiplier, zero for the default of 1
+	vacant  bool      // the id is not used by a document, like those of purged documents
 }
 
-func (a *App) uploadCorpus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
+// parseTokenizer reads the tokenizer setting and the settings of the selected tokenizer. It returns
+// nil when the default tokenizer should be used.
+func parseTokenizer(get func(string) string) (analysis.Tokenizer, error) {
+	switch get("tokenizer") {
+	case "", "standard":
+		if get("preserve_case") != "" {
+			return nil, fmt.Errorf("preserve_case is only valid with tokenizer set to code")
+		}
+		return parseStandardTokenizer(get)
+	case "code":
+		for _, name := range []string{"urls", "url_components", "hashtags", "strip_sigils"} {
+			if get(name) != "" {
+				return nil, fmt.Errorf("%s is not valid with tokenizer set to code", name)
+			}
+		}
+		preserveCase, err := parseBool("preserve_case", get("preserve_case"))
+		if err != nil {
+			return nil, err
+		}
+		return analysis.CodeTokenizer{PreserveCase: preserveCase}, nil
 	}
+	return nil, fmt.Errorf("invalid tokenizer %q, must be standard or code", get("tokenizer"))
+}
 
-	err := r.ParseMultipartForm(10 << 20) // 10 MB
-	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
-		return
+// parseStandardTokenizer reads the urls, url_components, hashtags and strip_sigils settings.
+func parseStandardTokenizer(get func(string) string) (analysis.Tokenizer, error) {
+	var tokenizer analysis.StandardTokenizer
+	for _, setting := range []struct {
+		name  string
+		value *bool
+	}{
+		{"urls", &tokenizer.URLs},
+		{"url_components", &tokenizer.URLComponents},
+		{"hashtags", &tokenizer.Hashtags},
+		{"strip_sigils", &tokenizer.StripSigils},
+	} {
+		value, err := parseBool(setting.name, get(setting.name))
+		if err != nil {
+			return nil, err
+		}
+		*setting.value = value
+	}
+	if tokenizer.URLComponents && !tokenizer.URLs {
+		return nil, fmt.Errorf("url_components is only valid with urls set to true")
+	}
+	if tokenizer.StripSigils && !tokenizer.Hashtags {
+		return nil, fmt.Errorf("strip_sigils is only valid with hashtags set to true")
+	}
+	if tokenizer == (analysis.StandardTokenizer{}) {
+		return nil, nil
 	}
+	return tokenizer, nil
+}
 
-	file, fileHeader, err := r.FormFile("corpus")
-	if err != nil {
-		http.Error(w, "Error retrieving the file", http.StatusBadRequest)
-		return
+// parseMapping reads the mapping settings, each replacing text before tokenization as "from=>to".
+func parseMapping(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
 	}
-	defer file.Close()
+	mapping := make(map[string]string, len(values))
+	for _, value := range values {
+		from, to, ok := strings.Cut(value, "=>")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("invalid mapping %q, must be in the form from=>to", value)
+		}
+		mapping[from] = to
+	}
+	return mapping, nil
+}
 
+// parseTokenLength reads the min_token_length, max_token_length and long_tokens settings.
+func parseTokenLength(get func(string) string) (analysis.LengthFilter, error) {
+	var filter analysis.LengthFilter
+	for _, bound := range []struct {
+		name  string
+		value *int
+	}{{"min_token_length", &filter.Min}, {"max_token_length", &filter.Max}} {
+		s := get(bound.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("invalid %s %q, must be a non-negative integer", bound.name, s)
+		}
+		*bound.value = n
+	}
+	if filter.Max > 0 && filter.Min > filter.Max {
+		return filter, fmt.Errorf("min_token_length %d is greater than max_token_length %d", filter.Min, filter.Max)
+	}
+
+	switch get("long_tokens") {
+	case "", "drop":
+	case "truncate":
+		filter.Truncate = true
+	default:
+		return filter, fmt.Errorf("invalid long_tokens %q, must be drop or truncate", get("long_tokens"))
+	}
+	return filter, nil
+}
+
+func parseIndexOptions(r *http.Request) (IndexOptions, error) {
 	indexOptions := IndexOptions{language: defaultLanguage, stem: defaultStem}
 
 	if lang := r.FormValue("language"); lang != "" {
@@ -332,128 +674,852 @@ func (a *App) uploadCorpus(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	a.indexLock.Lock()
-	defer a.indexLock.Unlock()
+	if filters := r.FormValue("filters"); filters != "" {
+		indexOptions.filters = strings.Split(filters, ",")
+	}
 
-	var tokenizedLine []string
-	a.indexBuilder = NewTrieIndex(indexOptions)
-	a.corpus = make([]string, 0)
+	similarity, err := parseSimilarity(r.FormValue)
+	if err != nil {
+		return indexOptions, err
+	}
+	indexOptions.similarity = similarity
 
-	scanner := bufio.NewScanner(file)
-	buf := make([]byte, maxLineSize)
-	scanner.Buffer(buf, maxLineSize)
-	i := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		tokenizedLine, err = ProcessText(line, indexOptions.language, indexOptions.stem)
-		if err != nil {
-			http.Error(w, "Error while processing text\n"+err.Error(), http.StatusInternalServerError)
-			return
+	dedup, err := parseDedupOptions(r.FormValue)
+	if err != nil {
+		return indexOptions, err
+	}
+	indexOptions.dedup = dedup
+
+	stopwords, err := parseStopwordOptions(r.FormValue)
+	if err != nil {
+		return indexOptions, err
+	}
+	indexOptions.stopwords = stopwords
+
+	tokenizer, err := parseTokenizer(r.FormValue)
+	if err != nil {
+		return indexOptions, err
+	}
+	indexOptions.tokenizer = tokenizer
+
+	mapping, err := parseMapping(r.Form["mapping"])
+	if err != nil {
+		return indexOptions, err
+	}
+	indexOptions.mapping = mapping
+
+	length, err := parseTokenLength(r.FormValue)
+	if err != nil {
+		return indexOptions, err
+	}
+	indexOptions.length = length
+
+	if s := r.FormValue("min_prefix_length"); s != "" {
+		if indexOptions.minPrefixLength, err = strconv.Atoi(s); err != nil || indexOptions.minPrefixLength < 0 {
+			return indexOptions, fmt.Errorf("invalid min_prefix_length %q, must be a non-negative integer", s)
+		}
+	}
+	if s := r.FormValue("default_size"); s != "" {
+		if indexOptions.defaultSize, err = strconv.Atoi(s); err != nil || indexOptions.defaultSize < 0 {
+			return indexOptions, fmt.Errorf("invalid default_size %q, must be a non-negative integer", s)
+		}
+	}
+	if indexOptions.rankingProfiles, err = parseRankingProfiles(r.Form["ranking_profile"]); err != nil {
+		return indexOptions, err
+	}
+	if s := r.FormValue("max_size"); s != "" {
+		if indexOptions.maxSize, err = strconv.Atoi(s); err != nil || indexOptions.maxSize < 0 {
+			return indexOptions, fmt.Errorf("invalid max_size %q, must be a non-negative integer", s)
 		}
-		a.indexBuilder.Add(tokenizedLine, uint32(i))
-		a.corpus = append(a.corpus, line)
-		i++
 	}
 
-	if err := scanner.Err(); err != nil {
-		http.Error(w, "Error reading file", http.StatusInternalServerError)
-		return
+	analyzer, err := indexOptions.newAnalyzer(indexOptions.filters)
+	if err != nil {
+		return indexOptions, err
 	}
+	indexOptions.analyzer = analyzer
 
-	fmt.Printf("Uploaded File: %+v\n", fileHeader.Filename)
-	fmt.Printf("File Size: %+v\n", fileHeader.Size)
-	fmt.Printf("MIME Header: %+v\n", fileHeader.Header)
+	if searchFilters, ok := r.Form["search_filters"]; ok {
+		indexOptions.searchFilters = make([]string, 0)
+		if searchFilters[0] != "" {
+			indexOptions.searchFilters = strings.Split(searchFilters[0], ",")
+		}
+		searchAnalyzer, err := indexOptions.newAnalyzer(indexOptions.searchFilters)
+		if err != nil {
+			return indexOptions, err
+		}
+		indexOptions.searchAnalyzer = searchAnalyzer
+	}
+	if indexOptions.stopwords.source == QueryStopwords {
+		if indexOptions.searchAnalyzer == nil {
+			indexOptions.searchAnalyzer = analyzer
+		}
+		indexOptions.analyzer = analyzer.WithoutStopWords()
+	}
+	return indexOptions, nil
+}
 
-	fmt.Fprint(w, "creating index brrr\n")
-	a.index = a.indexBuilder.Build()
+// newAnalyzer creates an analyzer with the given filters and the other analysis settings of the index.
+func (o IndexOptions) newAnalyzer(filters []string) (*analysis.Analyzer, error) {
+	analyzer, err := analysis.NewAnalyzer(o.language, o.stem, filters)
+	if err != nil {
+		return nil, err
+	}
+	if o.mapping != nil {
+		analyzer.AddCharFilter(analysis.NewMappingFilter(o.mapping))
+	}
+	if o.tokenizer != nil {
+		analyzer.SetTokenizer(o.tokenizer)
+	}
+	if o.length != (analysis.LengthFilter{}) {
+		analyzer.LimitTokenLength(o.length)
+	}
+	if o.stopwords.source != LanguageStopwords && o.stopwords.source != QueryStopwords {
+		analyzer.RemoveStopWords()
+	}
+	return analyzer, nil
 }
 
-type searchResponse struct {
-	Text  string  `json:"text"`
-	Score float64 `json:"score"`
-	Id    uint32  `json:"id"`
+// queryAnalyzer returns the analyzer for search queries, which defaults to the indexing analyzer.
+func (o IndexOptions) queryAnalyzer() *analysis.Analyzer {
+	if o.searchAnalyzer != nil {
+		return o.searchAnalyzer
+	}
+	return o.analyzer
 }
 
-func (a *App) search(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+func (a *App) uploadCorpus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !validIndexName(w, r) {
 		return
 	}
 
-	if a.index == nil {
-		http.Error(w, "No corpus has been uploaded", http.StatusInternalServerError)
+	a.limitBody(w, r)
+	size := r.ContentLength
+	if size < 0 {
+		size = a.limits.maxUploadSize
+	}
+	if !a.reserveUpload(w, size) {
+		return
+	}
+	defer a.memory.release(size * uploadMemoryFactor)
+	if !a.meterIndexing(w, r, size) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	query := r.URL.Query().Get("query")
-	typeString := r.URL.Query().Get("type")
-	operatorString := r.URL.Query().Get("operator")
-	d := r.URL.Query().Get("distance")
+	err := r.ParseMultipartForm(maxFormMemory)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error parsing form: "+err.Error())
+		return
+	}
 
-	var dist int
-	var err error
-	if d == "" {
-		dist = 0
-	} else {
-		dist, err = strconv.Atoi(d)
+	fileHeaders := r.MultipartForm.File["corpus"]
+	if len(fileHeaders) == 0 {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error retrieving the corpus file: "+http.ErrMissingFile.Error())
+		return
+	}
+
+	indexOptions, err := parseIndexOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+
+	sources := make([]corpusSource, 0, len(fileHeaders))
+	for _, fileHeader := range fileHeaders {
+		file, err := fileHeader.Open()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errInvalidRequest, "error retrieving the corpus file: "+err.Error())
 			return
 		}
+		defer file.Close()
+
+		fileSources, cleanup, err := a.uploadSources(file, fileHeader.Size, fileHeader.Filename, a.limits.maxUploadSize)
+		defer cleanup()
+		if err != nil {
+			writeIngestError(w, err)
+			return
+		}
+		sources = append(sources, fileSources...)
+
+		logf(r, "Uploaded File: %+v", fileHeader.Filename)
+		logf(r, "File Size: %+v", fileHeader.Size)
+		logf(r, "MIME Header: %+v", fileHeader.Header)
+	}
+	decompressed, ok := a.reserveDecompressed(w, sources, size, a.limits.maxUploadSize)
+	if !ok {
+		return
 	}
+	defer a.memory.release(decompressed * uploadMemoryFactor)
 
-	var searchType SearchType
-	switch typeString {
-	case "exact":
-		searchType = ExactSearch
-	case "prefix":
-		searchType = PrefixSearch
-	case "fuzzy":
-		searchType = FuzzySearch
-	default:
-		searchType = ExactSearch
+	build, err := a.buildCorpus(sources, indexOptions, a.documentIDs(indexName(r)))
+	if err != nil {
+		writeIngestError(w, err)
+		return
 	}
 
-	var operator Operator
-	switch operatorString {
-	case "and":
-		operator = And
-	case "or":
-		operator = Or
-	default:
-		operator = Or
+	fmt.Fprint(w, "creating index brrr\n")
+	a.getOrCreateIndex(indexName(r)).replace(build)
+}
+
+type uploadUrlsRequest struct {
+	Urls []string `json:"urls"`
+}
+
+type urlError struct {
+	Url     string `json:"url"`
+	Message string `json:"message"`
+}
+
+type uploadUrlsResponse struct {
+	Indexed int        `json:"indexed"`
+	Errors  []urlError `json:"errors"`
+}
+
+func (a *App) uploadUrls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !validIndexName(w, r) {
+		return
 	}
 
-	a.indexLock.RLock()
-	defer a.indexLock.RUnlock()
+	a.limitBody(w, r)
+	var req uploadUrlsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error parsing request body: "+err.Error())
+		return
+	}
+	if len(req.Urls) == 0 {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "no URLs provided")
+		return
+	}
+	if len(req.Urls) > maxFetchUrls {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, fmt.Sprintf("at most %d URLs can be indexed per request", maxFetchUrls))
+		return
+	}
 
-	searchResult, err := a.index.Search(query, searchType, operator, dist)
+	indexOptions, err := parseIndexOptions(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+
+	// pages are only read up to maxPageSize, so the memory and quota of the worst case are taken
+	// before anything is fetched, and the part the pages did not take is given back afterwards
+	worst := int64(len(req.Urls)) * maxPageSize
+	if !a.reserveUpload(w, worst) {
+		return
+	}
+	if !a.meterIndexing(w, r, worst) {
+		a.memory.release(worst * uploadMemoryFactor)
+		return
+	}
+	results := fetchAll(r.Context(), req.Urls)
+	if r.Context().Err() != nil {
+		a.memory.release(worst * uploadMemoryFactor)
+		refundIndexing(r, worst)
+		return
+	}
+	var size int64
+	for _, res := range results {
+		if res.err == nil {
+			size += int64(len(res.page.title) + len(res.page.body))
+		}
+	}
+	a.memory.release((worst - size) * uploadMemoryFactor)
+	refundIndexing(r, worst-size)
+	defer a.memory.release(size * uploadMemoryFactor)
+
+	build := newIndexBuild(indexOptions)
+	fetchErrors := make([]urlError, 0)
+
+	for _, res := range results {
+		if res.err != nil {
+			fetchErrors = append(fetchErrors, urlError{Url: res.url, Message: res.err.Error()})
+			continue
+		}
+		doc := document{
+			text:   res.page.body,
+			fields: map[string]string{"url": res.url, "title": res.page.title},
+		}
+		if err = build.add(doc, res.page.title+"\n"+res.page.body); err != nil {
+			writeError(w, http.StatusInternalServerError, errInternal, "error while processing text: "+err.Error())
+			return
+		}
+	}
+
+	if len(build.corpus) == 0 {
+		writeError(w, http.StatusBadGateway, errFetchFailed, "none of the URLs could be fetched")
 		return
 	}
 
-	matching_ids := a.index.Rank(searchResult.tokens, searchResult.DocIds())
+	a.getOrCreateIndex(indexName(r)).replace(build)
+	writeResponse(w, r, uploadUrlsResponse{Indexed: len(build.corpus), Errors: fetchErrors})
+}
+
+type searchResponse struct {
+	Index           string              `json:"index,omitempty"` // only set by federated search
+	Text            string              `json:"text"`
+	Fields          map[string]string   `json:"fields,omitempty"`
+	Key             string              `json:"key,omitempty"`
+	Score           float64             `json:"score"`
+	NormalizedScore *float64            `json:"normalized_score,omitempty"`
+	Matches         map[string][]string `json:"matches,omitempty"` // words of the text by matched term
+	RerankScore     *float64            `json:"rerank_score,omitempty"`
+	Features        *HitFeatures        `json:"features,omitempty"`
+	Id              uint32              `json:"id"`
+}
+
+func parseBool(name string, s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, must be true or false", name, s)
+	}
+	return b, nil
+}
+
+type searchParams struct {
+	query        string
+	searchType   SearchType
+	operator     Operator
+	distance     int
+	normalize    bool
+	minScore     float64 // lowest normalized score of the results, 0 to keep every match
+	matches      bool
+	diversify    bool
+	mmrLambda    float64 // weight of relevance against diversity when diversifying
+	mmrCount     int     // top results reordered when diversifying
+	expand       bool
+	expandDocs   int     // top results the query is expanded from
+	expandTerms  int     // terms added to the query
+	expandWeight float64 // multiplies the weights of the added terms
+	features     bool
+	rerankModel  string
+	reranker     Reranker // the model named rerankModel, nil to keep the order of the similarity
+	rerankTop    int      // top results reordered by the reranker
+	popularity   float64  // weight of the clicks recorded for the query, 0 to ignore them
+	// multiplies the weights of the terms a prefix or fuzzy search expanded a query token to
+	expansionPenalty float64
+	// whether stop words are kept in the query, which only matches them if the index kept them too
+	keepStopwords bool
+	filter        *docFilter
+	size          int // 0 to return every match
+	sort          *sortOrder
+	profile       bool
+	// name of the ranking profile of the index whose settings apply to the parameters left unset
+	rankingProfile string
+	limits         searchLimits
+}
+
+func parseSearchParams(query url.Values) (searchParams, error) {
+	params := searchParams{query: query.Get("query")}
+
+	var err error
+	if params.searchType, err = parseSearchType(query.Get("type")); err != nil {
+		return params, err
+	}
+	if params.operator, err = parseOperator(query.Get("operator")); err != nil {
+		return params, err
+	}
+	if params.distance, err = parseDistance(query.Get("distance"), params.searchType); err != nil {
+		return params, err
+	}
+	if params.expansionPenalty, err = parseExpansionPenalty(query.Get("expansion_penalty"), params.searchType); err != nil {
+		return params, err
+	}
+	if params.normalize, err = parseBool("normalize", query.Get("normalize")); err != nil {
+		return params, err
+	}
+	if s := query.Get("min_score"); s != "" {
+		if params.minScore, err = strconv.ParseFloat(s, 64); err != nil || !(params.minScore >= 0 && params.minScore <= 1) {
+			return params, fmt.Errorf("invalid min_score %q, must be a number between 0 and 1", s)
+		}
+	}
+	if params.profile, params.rankingProfile, err = parseProfile(query["profile"]); err != nil {
+		return params, err
+	}
+	if params.matches, err = parseBool("matches", query.Get("matches")); err != nil {
+		return params, err
+	}
+	if params.filter, err = parseFilter(query.Get("filter")); err != nil {
+		return params, err
+	}
+	if params.sort, err = parseSortOrder(query.Get("sort")); err != nil {
+		return params, err
+	}
+	if err = parseDiversity(query.Get, &params); err != nil {
+		return params, err
+	}
+	if err = parseExpansion(query.Get, &params); err != nil {
+		return params, err
+	}
+	if err = parseReranking(query.Get, &params); err != nil {
+		return params, err
+	}
+	if err = parsePopularity(query.Get, &params); err != nil {
+		return params, err
+	}
+	if params.keepStopwords, err = parseQueryStopwords(query.Get("stopwords")); err != nil {
+		return params, err
+	}
+	if s := query.Get("size"); s != "" {
+		if params.size, err = strconv.Atoi(s); err != nil || params.size < 1 {
+			return params, fmt.Errorf("invalid size %q, must be a positive integer", s)
+		}
+	}
+	if s := query.Get("max_expansions"); s != "" {
+		if params.limits.maxExpansions, err = strconv.Atoi(s); err != nil || params.limits.maxExpansions < 1 {
+			return params, fmt.Errorf("invalid max_expansions %q, must be a positive integer", s)
+		}
+	}
+	return params, nil
+}
+
+// errEmptyIndex is returned when searching an index no corpus has been uploaded to.
+var errEmptyIndex = errors.New("no corpus has been uploaded")
+
+// search runs a query on the index and returns the ranked matching documents, and whether some
+// terms the query expanded to were left out. It stops with the error of ctx when ctx is done,
+// releasing the index lock.
+func (idx *namedIndex) search(ctx context.Context, params searchParams) ([]searchResponse, bool, error) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if idx.index == nil {
+		return nil, false, errEmptyIndex
+	}
+
+	var matching_ids []RankResult
+	var tokens []string
+	var truncated bool
+	profile := profileOf(ctx)
+	if params.query == "" && params.filter != nil {
+		// filters select documents but never score them, so without a query there is nothing to rank
+		start := time.Now()
+		set := idx.fields.match(params.filter).Clone()
+		idx.withoutDeleted(set)
+		if profile != nil {
+			profile.Filter = millisecondsSince(start)
+			profile.Candidates = set.GetCardinality()
+		}
+		reserved, err := idx.reserveResults(set, params)
+		if err != nil {
+			return nil, false, err
+		}
+		defer idx.breaker.release(reserved)
+		ids := set.ToArray()
+		if params.size > 0 && params.sort == nil {
+			ids = ids[:min(params.size, len(ids))]
+		}
+		matching_ids = make([]RankResult, len(ids))
+		for i, id := range ids {
+			matching_ids[i].id = id
+		}
+	} else {
+		var searchResult *IndexResult
+		var err error
+		// other indexes do not index stop words, so keeping them in queries could only lose matches
+		if params.keepStopwords && idx.options.stopwords.source == QueryStopwords {
+			searchResult, err = idx.searchKeepingStopwords(ctx, params)
+		} else {
+			searchResult, err = idx.index.Search(
+				ctx, params.query, params.searchType, params.operator, params.distance, params.limits,
+			)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		truncated = searchResult.truncated
+		start := time.Now()
+		if params.filter != nil && searchResult.set != nil {
+			searchResult.set.And(idx.fields.match(params.filter))
+		}
+		idx.withoutDeleted(searchResult.set)
+		if profile != nil {
+			profile.Filter = millisecondsSince(start)
+		}
+		var expansion *IndexResult
+		if params.expand && searchResult.set != nil {
+			start = time.Now()
+			if expansion, err = idx.expand(ctx, params, searchResult); err != nil {
+				return nil, false, err
+			}
+			if profile != nil && expansion != nil {
+				profile.Expansion = expansion.tokens
+				profile.Expand = millisecondsSince(start)
+			}
+		}
+		if profile != nil && searchResult.set != nil {
+			profile.Candidates = searchResult.set.GetCardinality()
+		}
+		reserved, err := idx.reserveResults(searchResult.set, params)
+		if err != nil {
+			return nil, false, err
+		}
+		defer idx.breaker.release(reserved)
+		tokens = searchResult.tokens
+		start = time.Now()
+		var k int // 0 to rank every match
+		if params.size > 0 && params.sort == nil {
+			// diversification and reranking reorder more results than are returned
+			k = max(params.size, params.mmrCount, params.rerankTop)
+		}
+		rankTokens, multipliers := rankingTerms(searchResult, expansion, params)
+		switch {
+		case multipliers != nil:
+			matching_ids, err = idx.index.RankWeighted(ctx, rankTokens, multipliers, searchResult.set, k)
+		case k > 0:
+			matching_ids, err = idx.index.RankTop(ctx, tokens, searchResult.set, k)
+		default:
+			matching_ids, err = idx.index.Rank(ctx, tokens, searchResult.DocIds())
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if params.popularity > 0 {
+			if matching_ids, err = idx.popularityBoost(ctx, matching_ids, rankTokens, multipliers, searchResult.set, params); err != nil {
+				return nil, false, err
+			}
+		}
+		if profile != nil {
+			profile.Ranking = millisecondsSince(start)
+		}
+	}
 	result := make([]searchResponse, 0)
 
+	var maxScore float64
+	if params.normalize || params.minScore > 0 {
+		maxScore = idx.index.MaxScore(tokens)
+		if maxScore <= 0 && len(matching_ids) > 0 {
+			// scores of unbounded similarities are normalized relative to the best hit
+			maxScore = matching_ids[0].score
+		}
+	}
+	if params.minScore > 0 && params.query != "" {
+		// results are sorted by score until sorted by a field
+		cut := sort.Search(len(matching_ids), func(i int) bool {
+			return normalizeScore(matching_ids[i].score, maxScore) < params.minScore
+		})
+		matching_ids = matching_ids[:cut]
+	}
+	var rerankScores map[uint32]float64
+	if params.reranker != nil && params.query != "" {
+		start := time.Now()
+		var err error
+		if matching_ids, rerankScores, err = idx.rerank(matching_ids, tokens, params); err != nil {
+			return nil, false, err
+		}
+		if profile != nil {
+			profile.Ranking += millisecondsSince(start)
+		}
+	}
+	if params.diversify && params.query != "" {
+		start := time.Now()
+		var err error
+		if matching_ids, err = idx.index.Diversify(ctx, matching_ids, params.mmrCount, params.mmrLambda); err != nil {
+			return nil, false, err
+		}
+		if profile != nil {
+			profile.Ranking += millisecondsSince(start)
+		}
+	}
+
+	if params.sort != nil {
+		idx.fields.sortResults(matching_ids, params.sort)
+	}
+	if params.size > 0 {
+		matching_ids = matching_ids[:min(params.size, len(matching_ids))]
+	}
+
 	var response searchResponse
 	for _, res := range matching_ids {
-		response = searchResponse{Id: res.id, Score: math.Round(1000 * res.score), Text: a.corpus[res.id]}
+		doc := idx.corpus[res.id]
+		response = searchResponse{
+			Id: res.id, Key: doc.key, Score: math.Round(1000 * res.score), Text: doc.text, Fields: doc.fields,
+		}
+		if params.normalize {
+			normalized := normalizeScore(res.score, maxScore)
+			response.NormalizedScore = &normalized
+		}
+		if score, ok := rerankScores[res.id]; ok {
+			response.RerankScore = &score
+		}
+		if params.features && params.query != "" {
+			features, err := idx.features(res, tokens)
+			if err != nil {
+				return nil, false, err
+			}
+			response.Features = &features
+		}
+		if params.matches {
+			matches, err := idx.matches(doc, tokens)
+			if err != nil {
+				return nil, false, err
+			}
+			response.Matches = matches
+		}
 		result = append(result, response)
 	}
+	return result, truncated, nil
+}
+
+// matches returns the words of the text of doc that each of the matched terms was indexed from, or
+// nil if there are none. The index only holds analyzed terms, so the text is analyzed again.
+func (idx *namedIndex) matches(doc document, terms []string) (map[string][]string, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	forms, err := idx.options.analyzer.SurfaceForms(doc.text)
+	if err != nil {
+		return nil, err
+	}
+	var matches map[string][]string
+	for _, term := range terms {
+		if words, ok := forms[term]; ok {
+			if matches == nil {
+				matches = make(map[string][]string)
+			}
+			matches[term] = words
+		}
+	}
+	return matches, nil
+}
+
+func (a *App) search(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	params, err := parseSearchParams(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+	if params.rankingProfile != "" {
+		if params, err = idx.withRankingProfile(r.URL.Query(), params.rankingProfile); err != nil {
+			writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+			return
+		}
+	}
+	if params.rerankModel != "" {
+		if params.reranker = a.rerankers[params.rerankModel]; params.reranker == nil {
+			writeError(w, http.StatusBadRequest, errInvalidParameter, "unknown ranking model "+strconv.Quote(params.rerankModel))
+			return
+		}
+	}
+
+	a.limitSearch(&params)
+	maxSize := a.limitSize(&params, idx)
 
-	err = json.NewEncoder(w).Encode(result)
+	ctx := r.Context()
+	var profile *searchProfile
+	if params.profile {
+		profile = &searchProfile{Terms: make([]termProfile, 0)}
+		ctx = withSearchProfile(ctx, profile)
+	}
+	result, truncated, err := idx.search(ctx, params)
+	if r.Context().Err() != nil {
+		// the client is gone, so there is no one to respond to
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeSearchError(w, err)
 		return
 	}
+
+	if truncated {
+		w.Header().Set(truncatedHeader, "true")
+	}
+	if maxSize > 0 {
+		w.Header().Set(maxSizeHeader, strconv.Itoa(maxSize))
+	}
+	if profile != nil {
+		writeProfiledResponse(w, r, result, profile, start)
+	} else {
+		writeResponse(w, r, result)
+	}
+	a.logQuery(r, indexName(r), start, len(result))
+}
+
+// writeSearchError writes the response of a search of a single index that failed with err.
+func writeSearchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errShortPrefix):
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+	case errors.Is(err, errTooManyMatches):
+		writeError(w, http.StatusTooManyRequests, errQueryTooBroad, err.Error())
+	case errors.Is(err, errEmptyIndex):
+		writeError(w, http.StatusConflict, errNoCorpus, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, errInternal, err.Error())
+	}
+}
+
+// normalizeScore maps a raw score to [0, 1] relative to the maximum possible score of the query.
+func normalizeScore(score float64, maxScore float64) float64 {
+	if maxScore <= 0 {
+		return 0
+	}
+	return math.Round(1e4*min(max(score/maxScore, 0), 1)) / 1e4
 }
 
 func main() {
-	app := &App{corpus: make([]string, 0)}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replay(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := bench(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		if err := check(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	http.HandleFunc("/uploadCorpus", app.uploadCorpus)
-	http.HandleFunc("/search", app.search)
-	http.ListenAndServe(":8345", nil)
+	queryLogPath := flag.String("query-log", "", "append every search with its parameters and latency to this file")
+	auditLogPath := flag.String("audit-log", "", "append every request that changes indexes to this file")
+	apiKeysPath := flag.String("api-keys", "", "require one of the API keys of this JSON file and enforce their daily quotas")
+	maxUploadSize := flag.Int64("max-upload-size", defaultMaxUploadSize, "maximum size in bytes of an upload request body")
+	maxChunkedSize := flag.Int64(
+		"max-chunked-upload-size", defaultMaxChunkedSize, "maximum size in bytes of all the chunks of a chunked upload",
+	)
+	maxLineSize := flag.Int("max-line-size", defaultMaxLineSize, "maximum size in bytes of a line of a text corpus")
+	maxExpansions := flag.Int(
+		"max-expansions", defaultMaxExpansions, "maximum number of terms a token expands to in prefix and fuzzy searches",
+	)
+	minPrefixLength := flag.Int("min-prefix-length", 0, "minimum length in characters of tokens of prefix and fuzzy searches")
+	defaultSize := flag.Int("default-size", 0, "number of results of searches without a size, 0 for every match")
+	maxSize := flag.Int("max-size", 0, "maximum number of results of a search, 0 for no maximum")
+	maxConcurrentSearches := flag.Int(
+		"max-concurrent-searches", defaultMaxConcurrentSearches, "maximum number of searches running at the same time",
+	)
+	maxQueuedSearches := flag.Int(
+		"max-queued-searches", defaultMaxQueuedSearches, "maximum number of searches waiting to run before new ones are rejected",
+	)
+	maxSearchWait := flag.Duration(
+		"max-search-wait", defaultMaxSearchWait, "maximum time a search waits to run before it is rejected",
+	)
+	memoryLimit := flag.Int64(
+		"memory-limit", 0, "estimated memory in bytes above which uploads and broad searches are rejected, 0 for no limit",
+	)
+	adminAddr := flag.String(
+		"admin-addr", "", "serve the endpoints that change indexes, metrics and profiling on this address instead of port 8345",
+	)
+	corpusPath := flag.String("corpus", "", "index this corpus file into the default index before serving requests")
+	rankingModelsPath := flag.String("ranking-models", "", "load the linear ranking models of this JSON file for the rerank parameter")
+	flag.Float64Var(
+		&maxStaleRatio, "max-stale-ratio", defaultMaxStaleRatio,
+		"fraction of the documents of an index merges and deletes can change before all weights are recomputed",
+	)
+	flag.Parse()
+	if *maxUploadSize <= 0 || *maxChunkedSize <= 0 || *maxLineSize <= 0 {
+		fmt.Fprintln(os.Stderr, "upload limits must be positive")
+		os.Exit(2)
+	}
+	if *maxExpansions <= 0 || *maxConcurrentSearches <= 0 || *minPrefixLength < 0 || *maxQueuedSearches < 0 ||
+		*maxSearchWait <= 0 || *defaultSize < 0 || *maxSize < 0 {
+		fmt.Fprintln(os.Stderr, "search limits must be positive")
+		os.Exit(2)
+	}
+	if *memoryLimit < 0 {
+		fmt.Fprintln(os.Stderr, "memory-limit must not be negative")
+		os.Exit(2)
+	}
+	if maxStaleRatio < 0 || maxStaleRatio > 1 {
+		fmt.Fprintln(os.Stderr, "max-stale-ratio must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	app := NewApp()
+	app.limits = uploadLimits{maxUploadSize: *maxUploadSize, maxChunkedSize: *maxChunkedSize, maxLineSize: *maxLineSize}
+	app.searchLimits = searchLimits{
+		maxExpansions: *maxExpansions, minPrefixLength: *minPrefixLength, defaultSize: *defaultSize, maxSize: *maxSize,
+	}
+	app.searchLimiter = newSearchLimiter(*maxConcurrentSearches, *maxQueuedSearches, *maxSearchWait)
+	app.memory.limit = *memoryLimit
+	if *queryLogPath != "" {
+		queryLog, err := openJSONLog(*queryLogPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error opening query log:", err)
+			os.Exit(1)
+		}
+		defer queryLog.Close()
+		app.queryLog = queryLog
+	}
+	if *rankingModelsPath != "" {
+		models, err := loadRankingModels(*rankingModelsPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error loading ranking models:", err)
+			os.Exit(1)
+		}
+		for name, model := range models {
+			app.RegisterReranker(name, model)
+		}
+	}
+	if *apiKeysPath != "" {
+		apiKeys, err := loadAPIKeys(*apiKeysPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error loading API keys:", err)
+			os.Exit(1)
+		}
+		app.apiKeys = apiKeys
+	}
+	if *auditLogPath != "" {
+		auditLog, err := openJSONLog(*auditLogPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error opening audit log:", err)
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		app.auditLog = auditLog
+	}
+	if *corpusPath != "" {
+		if err := app.loadCorpus(*corpusPath); err != nil {
+			fmt.Fprintln(os.Stderr, "error loading corpus:", err)
+			os.Exit(1)
+		}
+	}
+	go app.sweepExpiredEvery(expirySweepInterval)
+	go app.expireUploadsEvery(uploadSweepInterval)
+	if *adminAddr == "" {
+		http.ListenAndServe(":8345", app.Routes())
+		return
+	}
+	go func() {
+		err := http.ListenAndServe(*adminAddr, app.AdminRoutes())
+		fmt.Fprintln(os.Stderr, "error serving admin endpoints:", err)
+		os.Exit(1)
+	}()
+	http.ListenAndServe(":8345", app.SearchRoutes())
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+const (
+	// uploadMemoryFactor is the estimated memory used to index an upload, relative to its size.
+	uploadMemoryFactor = 4
+	// resultMemory is the estimated memory used by a search for each matching document.
+	resultMemory = 128
+)
+
+// errTooManyMatches is returned by searches matching too many documents to rank within the memory limit.
+var errTooManyMatches = errors.New("query matches too many documents")
+
+// memoryBreaker keeps the estimated memory of the indexes and of the uploads and searches in
+// progress under a limit, rejecting work that would exceed it instead of running out of memory.
+// It is safe for concurrent use.
+type memoryBreaker struct {
+	limit    int64 // 0 for no limit
+	indexes  atomic.Int64
+	reserved atomic.Int64
+}
+
+// reserve accounts for n more bytes, and reports false without reserving them if this would
+// exceed the limit. Reserved bytes must be released once they are no longer used.
+func (b *memoryBreaker) reserve(n int64) bool {
+	for {
+		reserved := b.reserved.Load()
+		if b.limit > 0 && b.indexes.Load()+reserved+n > b.limit {
+			return false
+		}
+		if b.reserved.CompareAndSwap(reserved, reserved+n) {
+			return true
+		}
+	}
+}
+
+func (b *memoryBreaker) release(n int64) {
+	b.reserved.Add(-n)
+}
+
+// used returns the estimated memory of the indexes and of the work in progress.
+func (b *memoryBreaker) used() int64 {
+	return b.indexes.Load() + b.reserved.Load()
+}
+
+// reserveUpload reserves the memory to index an upload of the given size. It writes a 507 response
+// and returns false if the memory limit would be exceeded.
+func (a *App) reserveUpload(w http.ResponseWriter, size int64) bool {
+	if a.memory.reserve(size * uploadMemoryFactor) {
+		return true
+	}
+	writeError(
+		w, http.StatusInsufficientStorage, errInsufficientMemory,
+		fmt.Sprintf("indexing %s would exceed the memory limit of %s", formatBytes(size), formatBytes(a.memory.limit)),
+	)
+	return false
+}
+
+// reserveResults reserves the memory to rank and return the matching documents of a search,
+// returning the number of bytes to release once the search is done.
+func (idx *namedIndex) reserveResults(matches *roaring.Bitmap, params searchParams) (int64, error) {
+	var n uint64
+	if matches != nil {
+		n = matches.GetCardinality()
+	}
+	if params.size > 0 && params.sort == nil {
+		// only the best results are kept
+		n = min(n, uint64(params.size))
+	}
+	size := int64(min(n, math.MaxInt64/resultMemory)) * resultMemory
+	if !idx.breaker.reserve(size) {
+		return 0, fmt.Errorf(
+			"%w: ranking %d documents would exceed the memory limit of %s, narrow the query or set a size",
+			errTooManyMatches, n, formatBytes(idx.breaker.limit),
+		)
+	}
+	return size, nil
+}
+
+// setMemory records the estimated memory of the current version of the index. It must be called
+// with the index lock held.
+func (idx *namedIndex) setMemory(n int64) {
+	idx.breaker.indexes.Add(n - idx.memory)
+	idx.memory = n
+}
+
+// estimateMemory returns the estimated memory used by an index and its corpus.
+func estimateMemory(index SearchIndex, corpus []document) int64 {
+	var n int64
+	if index != nil {
+		n += index.EstimatedMemory()
+	}
+	for _, doc := range corpus {
+		n += 64 + int64(len(doc.key)+len(doc.text))
+		for key, value := range doc.fields {
+			// the value is also held by the field index and doc values
+			n += 96 + int64(len(key)+2*len(value))
+		}
+	}
+	return n
+}
+
+// EstimatedMemory returns the approximate number of bytes used by the index.
+func (t *trieSearchIndex) EstimatedMemory() int64 {
+	var n int64
+	for _, tokenSet := range t.invIndex.Traversal() {
+		// trie nodes and edge labels, and the vocabulary entry with its document frequency
+		n += 112 + 2*int64(len(tokenSet.token)) + int64(tokenSet.set.GetSizeInBytes())
+	}
+	for i, doc := range t.docEntries {
+		// token ids and weights, and term count map entries
+		n += 176 + 12*int64(len(doc.ids)) + 40*int64(len(t.docTerms[i].counts))
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBreaker(t *testing.T) {
+	app := NewApp()
+	handler := app.Routes()
+	corpus := strings.Repeat("red fox\n", 10) + "blue whale\n"
+
+	app.memory.limit = 100
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", corpus))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected status 507 over the memory limit, got %d", w.Code)
+	}
+
+	app.memory.limit = 0
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", corpus))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	used := app.memory.used()
+	if used <= 0 {
+		t.Fatalf("expected the index to use memory, got %d", used)
+	}
+
+	// room to rank 5 documents
+	app.memory.limit = used + 5*resultMemory
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=fox", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 for a broad query, got %d", w.Code)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=fox&size=5"); len(ids) != 5 {
+		t.Errorf("expected 5 results, got %v", ids)
+	}
+	if app.memory.used() != used {
+		t.Errorf("expected searches to release their memory, %d bytes are used instead of %d", app.memory.used(), used)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/indexes/default", nil))
+	if app.memory.used() != 0 {
+		t.Errorf("expected deleting the index to release its memory, %d bytes are used", app.memory.used())
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// MergeIndexes combines built indexes into a new one, as if their documents had been added to a
+// single builder in order. Document ids of each index are shifted by the number of documents in the
+// indexes before it, postings are combined and the weights are recomputed with the new document
+// frequencies. All indexes must analyze and score text the same way, or errIncompatibleIndexes is
+// returned.
+func MergeIndexes(indexes ...SearchIndex) (SearchIndex, error) {
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("no indexes to merge")
+	}
+
+	var builder *trieIndexBuilder
+	var offset uint32
+	for i, index := range indexes {
+		t, ok := index.(*trieSearchIndex)
+		if !ok {
+			return nil, fmt.Errorf("cannot merge index of type %T", index)
+		}
+		if builder == nil {
+			builder = NewTrieIndex(t.options).(*trieIndexBuilder)
+			// the first index keeps its token ids, so that the weights of its documents can be reused
+			builder.base, builder.vocabulary = t, t.vocabulary.clone()
+		} else if !sameAnalysis(builder.options, t.options) {
+			return nil, fmt.Errorf(
+				"%w: index %d uses different language, tokenization, stemming, filters, stop words, token length limits or similarity",
+				errIncompatibleIndexes, i,
+			)
+		}
+
+		if uint64(offset)+uint64(len(t.docTerms)) > maxDocuments {
+			return nil, tooManyDocumentsError()
+		}
+
+		for _, tokenSet := range t.invIndex.Traversal() {
+			builder.invIndex.Insert(tokenSet.token, roaring.AddOffset(tokenSet.set, offset))
+		}
+		if i == 0 {
+			builder.docTerms = append(builder.docTerms, t.docTerms...)
+		} else {
+			for _, terms := range t.docTerms {
+				builder.docTerms = append(builder.docTerms, terms.translate(t.vocabulary, builder.vocabulary))
+			}
+			builder.changed += len(t.docTerms)
+		}
+		offset += uint32(len(t.docTerms))
+	}
+	return builder.Build(), nil
+}
+
+// errIncompatibleIndexes is returned when merging indexes whose weights cannot be combined.
+var errIncompatibleIndexes = errors.New("incompatible indexes")
+
+// sameAnalysis reports whether two indexes turn text and queries into the same tokens, and weigh
+// them with the same similarity.
+func sameAnalysis(a, b IndexOptions) bool {
+	return a.language == b.language && a.stem == b.stem && slices.Equal(a.filters, b.filters) &&
+		slices.Equal(a.searchFilters, b.searchFilters) && a.tokenizer == b.tokenizer && maps.Equal(a.mapping, b.mapping) &&
+		a.length == b.length && a.stopwords.source == b.stopwords.source && slices.Equal(a.stopwords.words, b.stopwords.words) &&
+		reflect.DeepEqual(a.similarity, b.similarity)
+}
+
+type mergeRequest struct {
+	Sources []string `json:"sources"`
+}
+
+type mergeResponse struct {
+	Documents int `json:"documents"`
+}
+
+// mergeIndexes replaces the index in the path with the merge of the source indexes, which may include it.
+func (a *App) mergeIndexes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !validIndexName(w, r) {
+		return
+	}
+
+	a.limitBody(w, r)
+	var req mergeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error parsing request body: "+err.Error())
+		return
+	}
+	if len(req.Sources) == 0 {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "no source indexes provided")
+		return
+	}
+
+	sources := make([]*namedIndex, 0, len(req.Sources))
+	for i, name := range req.Sources {
+		if slices.Contains(req.Sources[:i], name) {
+			writeError(w, http.StatusBadRequest, errInvalidRequest, "index "+name+" is listed more than once")
+			return
+		}
+		idx, ok := a.getIndex(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, errIndexNotFound, "index "+name+" does not exist")
+			return
+		}
+		sources = append(sources, idx)
+	}
+
+	// the merged index takes about as much memory as its sources
+	var size int64
+	for _, idx := range sources {
+		idx.lock.RLock()
+		size += idx.memory
+		idx.lock.RUnlock()
+	}
+	if !a.memory.reserve(size) {
+		writeError(
+			w, http.StatusInsufficientStorage, errInsufficientMemory,
+			fmt.Sprintf("merging %s of indexes would exceed the memory limit of %s", formatBytes(size), formatBytes(a.memory.limit)),
+		)
+		return
+	}
+	defer a.memory.release(size)
+
+	// built indexes are never modified, so they can be merged after releasing the locks
+	corpus := make([]document, 0)
+	var options IndexOptions
+	indexes := make([]SearchIndex, 0, len(sources))
+	var duplicates []duplicateCluster
+	keys := make(map[string]bool)
+	var deleted *roaring.Bitmap
+	clicks := newClickFeedback()
+	for i, idx := range sources {
+		idx.lock.RLock()
+		index, sourceCorpus, clusters, sourceOptions := idx.index, idx.corpus, idx.duplicates, idx.options
+		var sourceDeleted *roaring.Bitmap
+		if idx.deleted != nil {
+			sourceDeleted = idx.deleted.Clone()
+		}
+		sourceClicks := idx.clicks
+		idx.lock.RUnlock()
+
+		if index == nil {
+			writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded to index "+req.Sources[i])
+			return
+		}
+		if i == 0 {
+			options = sourceOptions
+		}
+
+		offset := uint32(len(corpus))
+		for _, c := range clusters {
+			shifted := duplicateCluster{Id: c.Id + offset, Duplicates: make([]uint32, len(c.Duplicates)), Skipped: c.Skipped}
+			for j, id := range c.Duplicates {
+				shifted.Duplicates[j] = id + offset
+			}
+			duplicates = append(duplicates, shifted)
+		}
+		if sourceDeleted != nil {
+			if deleted == nil {
+				deleted = roaring.New()
+			}
+			deleted.Or(roaring.AddOffset(sourceDeleted, offset))
+		}
+		if sourceClicks != nil {
+			clicks.merge(sourceClicks, offset)
+		}
+		for _, doc := range sourceCorpus {
+			if doc.key == "" {
+				continue
+			}
+			if keys[doc.key] {
+				writeError(w, http.StatusBadRequest, errInvalidRequest, "document key "+doc.key+" is used in more than one source index")
+				return
+			}
+			keys[doc.key] = true
+		}
+		indexes = append(indexes, index)
+		corpus = append(corpus, sourceCorpus...)
+	}
+
+	merged, err := MergeIndexes(indexes...)
+	if errors.Is(err, errIncompatibleIndexes) {
+		writeError(w, http.StatusConflict, errIncompatible, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+
+	fields := newFieldIndex(corpus)
+	expirations := newExpirations(corpus)
+	memory := estimateMemory(merged, corpus)
+
+	target := a.getOrCreateIndex(indexName(r))
+	target.lock.Lock()
+	target.index = merged
+	target.corpus = corpus
+	target.fields = fields
+	target.duplicates = duplicates
+	target.deleted = deleted
+	target.clicks = clicks
+	target.expirations = expirations
+	target.setMemory(memory)
+	target.options = options
+	target.lock.Unlock()
+
+	writeResponse(w, r, mergeResponse{Documents: len(corpus)})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"stellr/analysis"
+)
+
+func buildTestIndex(t *testing.T, options IndexOptions, corpus []string) SearchIndex {
+	t.Helper()
+	builder := NewTrieIndex(options)
+	for i, text := range corpus {
+		tokens, err := options.analyzer.Analyze(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder.Add(tokens, uint32(i))
+	}
+	return builder.Build()
+}
+
+func TestMergeIndexes(t *testing.T) {
+	analyzer, err := analysis.NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexOptions{
+		language:   "english",
+		analyzer:   analyzer,
+		similarity: BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf},
+	}
+	first := []string{"the cat sat on the mat", "cat cat cat dog"}
+	second := []string{"a dog and a cat", "dog", "bird"}
+
+	merged, err := MergeIndexes(buildTestIndex(t, options, first), buildTestIndex(t, options, second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := buildTestIndex(t, options, append(append([]string{}, first...), second...))
+
+	for _, query := range []string{"cat", "dog", "bird cat"} {
+		res, _ := merged.Search(context.Background(), query, ExactSearch, Or, 0, searchLimits{})
+		expectedRes, _ := expected.Search(context.Background(), query, ExactSearch, Or, 0, searchLimits{})
+		ranked, _ := merged.Rank(context.Background(), res.tokens, res.DocIds())
+		expectedRanked, _ := expected.Rank(context.Background(), expectedRes.tokens, expectedRes.DocIds())
+		if len(ranked) != len(expectedRanked) {
+			t.Fatalf("merged index returned %v, expected %v for %s", ranked, expectedRanked, query)
+		}
+		for i := range ranked {
+			if ranked[i].id != expectedRanked[i].id || math.Abs(ranked[i].score-expectedRanked[i].score) > 1e-9 {
+				t.Errorf("merged index returned %v, expected %v for %s", ranked, expectedRanked, query)
+				break
+			}
+		}
+	}
+
+	stemmed := options
+	stemmed.stem = true
+	withStopwords := options
+	withStopwords.stopwords = StopwordOptions{source: CorpusStopwords, words: []string{"the"}}
+	tfidf := options
+	tfidf.similarity = TFIDF{Tf: LogTf, Idf: SmoothIdf, Slope: 0.2}
+	for _, other := range []IndexOptions{stemmed, withStopwords, tfidf} {
+		if _, err := MergeIndexes(buildTestIndex(t, options, first), buildTestIndex(t, other, second)); !errors.Is(err, errIncompatibleIndexes) {
+			t.Errorf("expected an error when merging indexes with different analysis or similarity, got %v", err)
+		}
+	}
+}
+
+func TestIncrementalMerge(t *testing.T) {
+	analyzer, err := analysis.NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexOptions{analyzer: analyzer, similarity: BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf}}
+	first := []string{"red fox", "red dog", "blue whale", "green frog", "red bird", "blue jay", "green tree"}
+	base := buildTestIndex(t, options, first).(*trieSearchIndex)
+
+	merged, err := MergeIndexes(base, buildTestIndex(t, options, []string{"gray dog"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	incremental := merged.(*trieSearchIndex)
+	if incremental.stale != 1 {
+		t.Errorf("expected 1 stale document, got %d", incremental.stale)
+	}
+	for i := range first {
+		// only the document with dog has a term whose document frequency changed
+		if reused := incremental.docEntries[i] == base.docEntries[i]; reused != (i != 1) {
+			t.Errorf("document %d reused %v", i, reused)
+		}
+	}
+	expected := buildTestIndex(t, options, append(slices.Clone(first), "gray dog")).(*trieSearchIndex)
+	for _, i := range []int{1, 7} {
+		if math.Abs(incremental.docEntries[i].norm-expected.docEntries[i].norm) > 1e-9 ||
+			len(incremental.docEntries[i].weights) != len(expected.docEntries[i].weights) {
+			t.Errorf("document %d was not recomputed", i)
+		}
+	}
+
+	defer func(ratio float64) { maxStaleRatio = ratio }(maxStaleRatio)
+	maxStaleRatio = 0
+	merged, err = MergeIndexes(base, buildTestIndex(t, options, []string{"gray dog"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed := merged.(*trieSearchIndex); refreshed.stale != 0 || refreshed.docEntries[0] == base.docEntries[0] {
+		t.Errorf("expected all weights to be recomputed")
+	}
+}
+
+func TestMergeDocumentLimit(t *testing.T) {
+	defer func(limit uint64) { maxDocuments = limit }(maxDocuments)
+	maxDocuments = 3
+
+	analyzer, err := analysis.NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexOptions{language: "english", analyzer: analyzer, similarity: TFIDF{Idf: SmoothIdf}}
+	first := buildTestIndex(t, options, []string{"cat", "dog"})
+	second := buildTestIndex(t, options, []string{"bird", "fish"})
+	if _, err := MergeIndexes(first, second); err == nil {
+		t.Errorf("expected an error when the merged index has more than %d documents", maxDocuments)
+	}
+
+	build := newIndexBuild(options)
+	for i, text := range []string{"cat", "dog", "bird", "fish"} {
+		err := build.add(document{text: text}, text)
+		if i < 3 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 3 && err == nil {
+			t.Errorf("expected an error when adding more than %d documents", maxDocuments)
+		}
+	}
+}
+
+func TestMergeEndpoint(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/day1/corpus", "red fox\nblue whale\n"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/day2/corpus", "red bird\n"))
+
+	w = httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"sources": ["day1", "day2"]}`)
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/all/merge", body))
+	var resp mergeResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || resp.Documents != 3 {
+		t.Fatalf("merge failed with status %d: %+v", w.Code, resp)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/all/search?query=red"); len(ids) != 2 || !slices.Contains(ids, 2) {
+		t.Errorf("wrong search results %v", ids)
+	}
+
+	w = httptest.NewRecorder()
+	body = bytes.NewBufferString(`{"sources": ["day1", "missing"]}`)
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/all/merge", body))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected missing source index, got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/day3/corpus?stopwords=none", "red cat\n"))
+	w = httptest.NewRecorder()
+	body = bytes.NewBufferString(`{"sources": ["day1", "day3"]}`)
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/all/merge", body))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected sources with different stop words to conflict, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// maxMgetIds is the number of documents a multi-get can fetch at once.
+const maxMgetIds = 1000
+
+// termVectorEntry is a term of a document with its number of occurrences and its weight in the
+// similarity of the index.
+type termVectorEntry struct {
+	TF     int     `json:"tf"`
+	Weight float64 `json:"weight"`
+}
+
+// TermVector returns the terms of a document, or an empty map if it has none.
+func (t *trieSearchIndex) TermVector(id uint32) map[string]termVectorEntry {
+	terms := t.docTerms[id]
+	vector := make(map[string]termVectorEntry, len(terms.counts))
+	doc := t.docEntries[id]
+	for tokenID, count := range terms.counts {
+		entry := termVectorEntry{TF: count}
+		if doc != nil {
+			entry.Weight = doc.weight(tokenID)
+		}
+		vector[t.vocabulary.token(tokenID)] = entry
+	}
+	return vector
+}
+
+type mgetRequest struct {
+	Ids []uint32 `json:"ids"`
+}
+
+// mgetDocument is a document of a multi-get. Documents that do not exist, or were deleted, are only
+// returned with their id and found set to false.
+type mgetDocument struct {
+	Id          uint32                     `json:"id"`
+	Found       bool                       `json:"found"`
+	Key         string                     `json:"key,omitempty"`
+	Text        string                     `json:"text,omitempty"`
+	Fields      map[string]string          `json:"fields,omitempty"`
+	TermVectors map[string]termVectorEntry `json:"term_vectors,omitempty"`
+}
+
+type mgetResponse struct {
+	Docs []mgetDocument `json:"docs"`
+}
+
+// multiGet returns the stored documents with the given ids, in the order of the request, and with
+// their term vectors if term_vectors is true.
+func (a *App) multiGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	termVectors, err := parseBool("term_vectors", r.URL.Query().Get("term_vectors"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	a.limitBody(w, r)
+	var req mgetRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "error parsing request body: "+err.Error())
+		return
+	}
+	if len(req.Ids) == 0 || len(req.Ids) > maxMgetIds {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "ids must list between 1 and "+strconv.Itoa(maxMgetIds)+" documents")
+		return
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if idx.index == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+
+	docs := make([]mgetDocument, len(req.Ids))
+	for i, id := range req.Ids {
+		docs[i].Id = id
+		if !idx.exists(id) {
+			continue
+		}
+		doc := idx.corpus[id]
+		docs[i].Found, docs[i].Key, docs[i].Text, docs[i].Fields = true, doc.key, doc.text, doc.fields
+		if termVectors {
+			docs[i].TermVectors = idx.index.TermVector(id)
+		}
+	}
+	writeResponse(w, r, mgetResponse{Docs: docs})
+}
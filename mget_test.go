@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMultiGet(t *testing.T) {
+	app := NewApp()
+	handler := app.Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred red bird\nblue whale\n"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/v1/indexes/default/documents/2", nil))
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"ids": [1, 2, 0, 9]}`)
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/documents/_mget?term_vectors=true", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("multi-get failed with status %d: %s", w.Code, w.Body.String())
+	}
+	var resp mgetResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Docs) != 4 {
+		t.Fatalf("expected 4 documents, got %+v", resp.Docs)
+	}
+	if doc := resp.Docs[0]; doc.Id != 1 || !doc.Found || doc.Text != "red red bird" {
+		t.Errorf("unexpected first document %+v", doc)
+	}
+	if vector := resp.Docs[0].TermVectors; len(vector) != 2 || vector["red"].TF != 2 || vector["bird"].Weight <= 0 {
+		t.Errorf("unexpected term vectors %+v", vector)
+	}
+	// deleted and missing documents are not found
+	if resp.Docs[1].Found || resp.Docs[3].Found || resp.Docs[3].Id != 9 {
+		t.Errorf("expected documents 2 and 9 not to be found, got %+v", resp.Docs)
+	}
+	if doc := resp.Docs[2]; doc.Id != 0 || !doc.Found || doc.Text != "red fox" {
+		t.Errorf("unexpected third document %+v", doc)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/documents/_mget", strings.NewReader(`{"ids": [0]}`)))
+	if strings.Contains(w.Body.String(), "term_vectors") {
+		t.Errorf("term vectors should only be returned when requested")
+	}
+	for _, body := range []string{`{"ids": []}`, `{"ids": [-1]}`, `not json`} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/documents/_mget", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got status %d", body, w.Code)
+		}
+	}
+	app.limits.maxUploadSize = 16
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/default/documents/_mget", strings.NewReader(`{"ids": [0, 1, 2, 3, 4, 5, 6]}`)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for a body over the maximum upload size, got %d", w.Code)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	defaultMMRLambda     = 0.7
+	defaultMMRCandidates = 100
+	maxMMRCandidates     = 1000
+)
+
+// Diversify reorders the first n results with maximal marginal relevance: each position goes to the
+// result maximizing lambda * relevance - (1 - lambda) * similarity, where relevance is its score
+// relative to the best one and similarity is its highest cosine similarity to the results placed
+// before it. Results after the first n keep their order. It returns the error of ctx if ctx is done
+// before the results are reordered.
+func (t *trieSearchIndex) Diversify(ctx context.Context, ranked []RankResult, n int, lambda float64) ([]RankResult, error) {
+	n = min(n, len(ranked))
+	if n < 2 || lambda >= 1 {
+		return ranked, nil
+	}
+
+	norms := make([]float64, n)
+	for i, res := range ranked[:n] {
+		norms[i] = t.weightNorm(res.id)
+	}
+	maxScore := ranked[0].score
+
+	placed := make([]bool, n)
+	// maxSimilarity[i] is the highest similarity of result i to the results placed so far
+	maxSimilarity := make([]float64, n)
+	diversified := make([]RankResult, 0, len(ranked))
+	for len(diversified) < n {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		best, bestValue := -1, math.Inf(-1)
+		for i, res := range ranked[:n] {
+			if placed[i] {
+				continue
+			}
+			var relevance float64
+			if maxScore > 0 {
+				relevance = res.score / maxScore
+			}
+			// ties go to the better ranked result
+			if value := lambda*relevance - (1-lambda)*maxSimilarity[i]; value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		placed[best] = true
+		diversified = append(diversified, ranked[best])
+		for i, res := range ranked[:n] {
+			if !placed[i] {
+				similarity := t.cosine(ranked[best].id, res.id, norms[best], norms[i])
+				maxSimilarity[i] = max(maxSimilarity[i], similarity)
+			}
+		}
+	}
+	return append(diversified, ranked[n:]...), nil
+}
+
+// weightNorm returns the euclidean norm of the term weights of a document.
+func (t *trieSearchIndex) weightNorm(id uint32) float64 {
+	doc := t.docEntries[id]
+	if doc == nil {
+		return 0
+	}
+	var sum float64
+	for _, weight := range doc.weights {
+		sum += weight * weight
+	}
+	return math.Sqrt(sum)
+}
+
+// cosine returns the cosine similarity of the term weights of two documents with the given norms.
+func (t *trieSearchIndex) cosine(a, b uint32, normA, normB float64) float64 {
+	docA, docB := t.docEntries[a], t.docEntries[b]
+	if docA == nil || docB == nil || normA == 0 || normB == 0 {
+		return 0
+	}
+	// both documents have their tokens sorted by id, so they are intersected in one pass
+	var dot float64
+	for i, j := 0, 0; i < len(docA.ids) && j < len(docB.ids); {
+		switch {
+		case docA.ids[i] < docB.ids[j]:
+			i++
+		case docA.ids[i] > docB.ids[j]:
+			j++
+		default:
+			dot += docA.weights[i] * docB.weights[j]
+			i++
+			j++
+		}
+	}
+	return dot / (normA * normB)
+}
+
+// parseDiversity reads the diversify, mmr_lambda and mmr_candidates parameters of a search.
+func parseDiversity(get func(string) string, params *searchParams) error {
+	var err error
+	if params.diversify, err = parseBool("diversify", get("diversify")); err != nil {
+		return err
+	}
+	if !params.diversify {
+		if get("mmr_lambda") != "" || get("mmr_candidates") != "" {
+			return fmt.Errorf("mmr_lambda and mmr_candidates are only valid with diversify=true")
+		}
+		return nil
+	}
+	if params.sort != nil {
+		return fmt.Errorf("diversify cannot be combined with sort")
+	}
+
+	params.mmrLambda, params.mmrCount = defaultMMRLambda, defaultMMRCandidates
+	if s := get("mmr_lambda"); s != "" {
+		if params.mmrLambda, err = strconv.ParseFloat(s, 64); err != nil || !(params.mmrLambda >= 0 && params.mmrLambda <= 1) {
+			return fmt.Errorf("invalid mmr_lambda %q, must be a number between 0 and 1", s)
+		}
+	}
+	if s := get("mmr_candidates"); s != "" {
+		if params.mmrCount, err = strconv.Atoi(s); err != nil || params.mmrCount < 1 || params.mmrCount > maxMMRCandidates {
+			return fmt.Errorf("invalid mmr_candidates %q, must be an integer between 1 and %d", s, maxMMRCandidates)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"stellr/analysis"
+)
+
+func TestDiversify(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := "quick red fox\nquick red fox\nquick red fox\nred fox in the snow\nblue whale\n"
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", corpus))
+
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=quick+red+fox&size=2"); !reflect.DeepEqual(ids, []uint32{0, 1}) {
+		t.Fatalf("expected the duplicates first without diversification, got %v", ids)
+	}
+	ids := searchIds(t, handler, "/v1/indexes/default/search?query=quick+red+fox&size=2&diversify=true&mmr_lambda=0.5")
+	if !reflect.DeepEqual(ids, []uint32{0, 3}) {
+		t.Errorf("expected a different document after the best one, got %v", ids)
+	}
+	// with a lambda of 1, results are ordered by relevance only
+	ids = searchIds(t, handler, "/v1/indexes/default/search?query=quick+red+fox&diversify=true&mmr_lambda=1")
+	if !reflect.DeepEqual(ids, []uint32{0, 1, 2, 3}) {
+		t.Errorf("expected the relevance order, got %v", ids)
+	}
+
+	for _, params := range []string{"mmr_lambda=0.5", "diversify=true&mmr_lambda=2", "diversify=true&mmr_candidates=0", "diversify=true&sort=id"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=fox&"+params, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got status %d", params, w.Code)
+		}
+	}
+}
+
+func TestCosine(t *testing.T) {
+	analyzer, err := analysis.NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexOptions{analyzer: analyzer, similarity: BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf}}
+	corpus := []string{"quick red fox", "quick red fox", "red fox in the snow jumps", "blue whale", "snow whale jumps quick"}
+	index := buildTestIndex(t, options, corpus).(*trieSearchIndex)
+
+	for a := range corpus {
+		for b := range corpus {
+			vectorA, vectorB := index.TermVector(uint32(a)), index.TermVector(uint32(b))
+			var dot float64
+			for term, entry := range vectorA {
+				dot += entry.Weight * vectorB[term].Weight
+			}
+			normA, normB := index.weightNorm(uint32(a)), index.weightNorm(uint32(b))
+			if cosine := index.cosine(uint32(a), uint32(b), normA, normB); math.Abs(cosine-dot/(normA*normB)) > 1e-9 {
+				t.Errorf("cosine of documents %d and %d is %f, expected %f", a, b, cosine, dot/(normA*normB))
+			}
+		}
+	}
+	if cosine := index.cosine(0, 1, index.weightNorm(0), index.weightNorm(1)); math.Abs(cosine-1) > 1e-9 {
+		t.Errorf("expected identical documents to have a cosine of 1, got %f", cosine)
+	}
+	if cosine := index.cosine(0, 3, index.weightNorm(0), index.weightNorm(3)); cosine != 0 {
+		t.Errorf("expected documents without common terms to have a cosine of 0, got %f", cosine)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// searchProfile is the breakdown of the time a search took, returned with its results when asked
+// for with profile=true. Times are in milliseconds.
+type searchProfile struct {
+	Analysis      float64       `json:"analysis_ms"`
+	Terms         []termProfile `json:"terms"`
+	Combine       float64       `json:"combine_ms"` // of the documents of each query token
+	Filter        float64       `json:"filter_ms"`  // of filters and deleted documents
+	Expand        float64       `json:"expand_ms,omitempty"`
+	Expansion     []string      `json:"expansion,omitempty"` // terms added to the query by expand
+	Candidates    uint64        `json:"candidates"`          // documents left to rank
+	Ranking       float64       `json:"ranking_ms"`
+	Results       int           `json:"results"`
+	Serialization float64       `json:"serialization_ms"`
+	Total         float64       `json:"total_ms"`
+}
+
+// termProfile is the trie lookup of a query token.
+type termProfile struct {
+	Token      string  `json:"token"`
+	Expansions int     `json:"expansions"` // terms of the index it matched
+	Documents  uint64  `json:"documents"`  // documents containing any of the terms
+	Lookup     float64 `json:"lookup_ms"`
+}
+
+type searchProfileKey struct{}
+
+// withSearchProfile returns a context in which searches record their profile in p.
+func withSearchProfile(ctx context.Context, p *searchProfile) context.Context {
+	return context.WithValue(ctx, searchProfileKey{}, p)
+}
+
+// profileOf returns the profile searches record in, or nil if the search is not profiled.
+func profileOf(ctx context.Context) *searchProfile {
+	p, _ := ctx.Value(searchProfileKey{}).(*searchProfile)
+	return p
+}
+
+func millisecondsSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
+
+type profiledResponse struct {
+	Results any            `json:"results"`
+	Profile *searchProfile `json:"profile"`
+}
+
+// writeProfiledResponse sends the results of a search along with its profile. The results are
+// encoded first, so that the time it takes is part of the profile.
+func writeProfiledResponse(w http.ResponseWriter, r *http.Request, results []searchResponse, p *searchProfile, start time.Time) {
+	encodeStart := time.Now()
+	var encoded any
+	var buf bytes.Buffer
+	var err error
+	if acceptsMsgpack(r) {
+		err = newMsgpackEncoder(&buf).Encode(results)
+		encoded = msgpack.RawMessage(buf.Bytes())
+	} else {
+		err = json.NewEncoder(&buf).Encode(results)
+		encoded = json.RawMessage(buf.Bytes())
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errInternal, "error encoding response: "+err.Error())
+		return
+	}
+	p.Results = len(results)
+	p.Serialization = millisecondsSince(encodeStart)
+	p.Total = millisecondsSince(start)
+	writeResponse(w, r, profiledResponse{Results: encoded, Profile: p})
+}
+
+// newMsgpackEncoder returns an encoder of MessagePack responses, which use the JSON field names.
+func newMsgpackEncoder(w io.Writer) *msgpack.Encoder {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	return enc
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// queryLogEntry is a line of the query log.
+type queryLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Index     string    `json:"index,omitempty"` // empty for federated searches
+	Params    string    `json:"params"`          // encoded search query string
+	Results   int       `json:"results"`
+	LatencyMs float64   `json:"latency_ms"`
+}
+
+// jsonLog appends a JSON line per entry to a file, such as the query log. It is safe for concurrent use.
+type jsonLog struct {
+	file    *os.File
+	encoder *json.Encoder
+	lock    sync.Mutex
+}
+
+func openJSONLog(path string) (*jsonLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLog{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (l *jsonLog) log(entry any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.encoder.Encode(entry); err != nil {
+		fmt.Printf("Error writing %s: %s\n", l.file.Name(), err)
+	}
+}
+
+func (l *jsonLog) Close() error {
+	return l.file.Close()
+}
+
+// logQuery records a search on the given index if query logging is enabled.
+func (a *App) logQuery(r *http.Request, index string, start time.Time, results int) {
+	if a.queryLog == nil {
+		return
+	}
+	a.queryLog.log(queryLogEntry{
+		Time:      start.UTC(),
+		RequestID: requestID(r),
+		Index:     index,
+		Params:    r.URL.RawQuery,
+		Results:   results,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
+
+func readQueryLog(r io.Reader) ([]queryLogEntry, error) {
+	entries := make([]queryLogEntry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), defaultMaxLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry queryLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("query log line %d: %w", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+type replaySummary struct {
+	queries    int
+	errors     int
+	mismatches int // queries whose number of results differs from the log
+	latencies  []time.Duration
+}
+
+// percentile returns the latency below which p percent of the successful queries fall.
+func (s *replaySummary) percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	return s.latencies[int(p/100*float64(len(s.latencies)-1))]
+}
+
+// sortLatencies sorts the latencies, which percentile expects.
+func (s *replaySummary) sortLatencies() {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+}
+
+// mean returns the mean latency of the successful queries.
+func (s *replaySummary) mean() time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, latency := range s.latencies {
+		total += latency
+	}
+	return total / time.Duration(len(s.latencies))
+}
+
+func (s *replaySummary) print(w io.Writer) {
+	s.sortLatencies()
+	fmt.Fprintf(w, "queries:     %d\n", s.queries)
+	fmt.Fprintf(w, "errors:      %d\n", s.errors)
+	fmt.Fprintf(w, "mismatches:  %d\n", s.mismatches)
+	fmt.Fprintf(w, "mean:        %s\n", s.mean())
+	fmt.Fprintf(w, "p50:         %s\n", s.percentile(50))
+	fmt.Fprintf(w, "p95:         %s\n", s.percentile(95))
+	fmt.Fprintf(w, "p99:         %s\n", s.percentile(99))
+	fmt.Fprintf(w, "max:         %s\n", s.percentile(100))
+}
+
+// replayQueries runs every logged search against the server at addr, optionally on a different index.
+func replayQueries(client *http.Client, addr string, index string, entries []queryLogEntry) *replaySummary {
+	summary := &replaySummary{latencies: make([]time.Duration, 0, len(entries))}
+	for _, entry := range entries {
+		endpoint := addr + "/v1/search?"
+		if entry.Index != "" {
+			name := entry.Index
+			if index != "" {
+				name = index
+			}
+			endpoint = addr + "/v1/indexes/" + url.PathEscape(name) + "/search?"
+		}
+		summary.queries++
+
+		start := time.Now()
+		resp, err := client.Get(endpoint + entry.Params)
+		if err != nil {
+			summary.errors++
+			continue
+		}
+		var results []json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&results)
+		resp.Body.Close()
+		latency := time.Since(start)
+
+		if resp.StatusCode != http.StatusOK || err != nil {
+			summary.errors++
+			continue
+		}
+		summary.latencies = append(summary.latencies, latency)
+		if len(results) != entry.Results {
+			summary.mismatches++
+		}
+	}
+	return summary
+}
+
+// replay implements the replay subcommand, which re-runs a query log against a running server.
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	addr := flags.String("addr", "http://localhost:8345", "address of the stellr server")
+	index := flags.String("index", "", "index to run single-index queries on, instead of the logged one")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stellr replay [flags] query-log")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := readQueryLog(file)
+	if err != nil {
+		return err
+	}
+
+	summary := replayQueries(&http.Client{Timeout: fetchTimeout}, *addr, *index, entries)
+	summary.print(os.Stdout)
+	return nil
+}
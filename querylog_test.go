@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueryLogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	queryLog, err := openJSONLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp()
+	app.queryLog = queryLog
+	handler := app.Routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/books/corpus", "red fox\nred fox jumps high\nblue whale\n"))
+	searchIds(t, handler, "/v1/indexes/books/search?query=fox")
+	searchIds(t, handler, "/v1/indexes/books/search?query=whale&type=prefix")
+	queryLog.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries, err := readQueryLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Index != "books" || entries[0].Params != "query=fox" || entries[0].Results != 2 {
+		t.Fatalf("wrong query log entries %+v", entries)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	summary := replayQueries(server.Client(), server.URL, "", entries)
+	if summary.queries != 2 || summary.errors != 0 || summary.mismatches != 0 || len(summary.latencies) != 2 {
+		t.Errorf("wrong replay summary %+v", summary)
+	}
+
+	// replaying on an index that does not exist fails every query
+	summary = replayQueries(server.Client(), server.URL, "missing", entries)
+	if summary.errors != 2 {
+		t.Errorf("expected 2 errors, got %+v", summary)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// cancelCheckInterval is the number of documents scored between checks for cancellation.
+const cancelCheckInterval = 1024
+
+// maxImpacts returns the highest weight of every token in any document, multiplied by the scale
+// and boost of that document. It returns nil if the similarity does not bound scores per token.
+func maxImpacts(similarity Similarity, docEntries []*docEntry, stats CollectionStats, nTokens int) []float64 {
+	scaled, ok := similarity.(ScaledSimilarity)
+	if !ok {
+		return nil
+	}
+	impacts := make([]float64, nTokens)
+	for _, doc := range docEntries {
+		scale := scaled.DocScale(doc.norm, stats) * doc.boost
+		for i, id := range doc.ids {
+			impacts[id] = max(impacts[id], doc.weights[i]*scale)
+		}
+	}
+	return impacts
+}
+
+// topResults is a min-heap of the best results found so far, with the result that would be
+// ranked last at the top.
+type topResults []RankResult
+
+func (h topResults) Len() int { return len(h) }
+func (h topResults) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score < h[j].score
+	}
+	return h[i].id > h[j].id
+}
+func (h topResults) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *topResults) Push(x any)   { *h = append(*h, x.(RankResult)) }
+func (h *topResults) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// offer adds a result to h if it ranks above the worst of the k results h holds.
+func (h *topResults) offer(res RankResult, k int) {
+	if len(*h) < k {
+		heap.Push(h, res)
+	} else if (topResults{(*h)[0], res}).Less(0, 1) {
+		(*h)[0] = res
+		heap.Fix(h, 0)
+	}
+}
+
+type boundedTerm struct {
+	bound float64 // highest score the token can add to a document
+	docs  *roaring.Bitmap
+}
+
+// RankTop returns the k best scoring candidates, in the same order as the first k results of Rank.
+// Like Rank, it returns the error of ctx if ctx is done before all candidates are scored.
+//
+// With a scaled similarity it uses the MaxScore algorithm. Query tokens are sorted by the highest
+// score they can add to a document, and once k documents have been scored, the tokens whose bounds
+// add up to no more than the k-th best score are no longer enough for a document to enter the
+// results. Documents containing only those tokens are skipped without being scored.
+func (t *trieSearchIndex) RankTop(ctx context.Context, tokens []string, candidates *roaring.Bitmap, k int) ([]RankResult, error) {
+	queryWeights, queryNorm := t.queryWeights(tokens, nil)
+	return t.rankTop(ctx, queryWeights, queryNorm, candidates, k)
+}
+
+// RankWeighted is like RankTop with the query weight of each token of multipliers multiplied by its
+// value. A k of 0 ranks every candidate, like Rank.
+func (t *trieSearchIndex) RankWeighted(
+	ctx context.Context, tokens []string, multipliers map[string]float64, candidates *roaring.Bitmap, k int,
+) ([]RankResult, error) {
+	queryWeights, queryNorm := t.queryWeights(tokens, multipliers)
+	if k == 0 {
+		if candidates == nil {
+			return []RankResult{}, nil
+		}
+		return t.rank(ctx, queryWeights, queryNorm, candidates.ToArray())
+	}
+	return t.rankTop(ctx, queryWeights, queryNorm, candidates, k)
+}
+
+// rankTop is RankTop for a query with the given weights and norm. Large sets of candidates are
+// split by id range across rankWorkers goroutines, each keeping its own k best results, which are
+// merged at the end.
+func (t *trieSearchIndex) rankTop(
+	ctx context.Context, queryWeights []queryWeight, queryNorm float64, candidates *roaring.Bitmap, k int,
+) ([]RankResult, error) {
+	if candidates == nil || k <= 0 {
+		return []RankResult{}, nil
+	}
+	scaled, ok := t.options.similarity.(ScaledSimilarity)
+	if !ok || t.maxImpact == nil {
+		docIds := candidates.ToArray()
+		parts := partitions(len(docIds))
+		tops := make([]topResults, len(parts))
+		err := inParallel(parts, func(part, from, to int) error {
+			tops[part] = make(topResults, 0, min(k, to-from))
+			for i, id := range docIds[from:to] {
+				if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+					return ctx.Err()
+				}
+				tops[part].offer(RankResult{id: id, score: t.score(id, queryWeights, queryNorm)}, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return mergeTop(tops, k), nil
+	}
+
+	q := maxScoreQuery{weights: queryWeights, norm: queryNorm}
+	queryScale := scaled.QueryScale(queryNorm)
+	for _, query := range queryWeights {
+		res := t.invIndex.Search(query.token)
+		if res == nil {
+			continue
+		}
+		// rounding can make an exact score slightly larger than the sum of the bounds
+		bound := query.weight * queryScale * t.maxImpact[query.id] * (1 + 1e-9)
+		q.terms = append(q.terms, boundedTerm{bound: bound, docs: roaring.And(res.set, candidates)})
+	}
+	sort.Slice(q.terms, func(i, j int) bool { return q.terms[i].bound < q.terms[j].bound })
+	q.cumulative = make([]float64, len(q.terms))
+	var sum float64
+	for i, term := range q.terms {
+		sum += term.bound
+		q.cumulative[i] = sum
+	}
+
+	parts := partitions(int(candidates.GetCardinality()))
+	tops := make([]topResults, len(parts))
+	err := inParallel(parts, func(part, from, to int) error {
+		if from == to {
+			return nil
+		}
+		// the ids of the candidates at positions from and to bound the range of the part
+		first, _ := candidates.Select(uint32(from))
+		last := uint64(math.MaxUint32) + 1
+		if part < len(parts)-1 {
+			next, _ := candidates.Select(uint32(to))
+			last = uint64(next)
+		}
+		var err error
+		tops[part], err = t.maxScoreTop(ctx, &q, k, first, last, to-from)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeTop(tops, k), nil
+}
+
+// maxScoreQuery is a query ranked with the MaxScore algorithm.
+type maxScoreQuery struct {
+	weights []queryWeight
+	norm    float64
+	terms   []boundedTerm // by increasing bound
+	// cumulative[i] is the highest score of a document containing only the tokens up to i
+	cumulative []float64
+}
+
+// maxScoreTop returns the k best scoring documents with ids in [from, to), of which there are n
+// candidates, as a topResults heap.
+func (t *trieSearchIndex) maxScoreTop(ctx context.Context, q *maxScoreQuery, k int, from uint32, to uint64, n int) (topResults, error) {
+	// essential returns the documents containing any of the tokens from the first essential one on
+	essential := func(first int) roaring.IntPeekable {
+		sets := make([]*roaring.Bitmap, 0, len(q.terms)-first)
+		for _, term := range q.terms[first:] {
+			sets = append(sets, term.docs)
+		}
+		return roaring.FastOr(sets...).Iterator()
+	}
+
+	results := make(topResults, 0, min(k, n))
+	var firstEssential, scored int
+	docs := essential(firstEssential)
+	docs.AdvanceIfNeeded(from)
+	for docs.HasNext() {
+		if scored%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		scored++
+		id := docs.Next()
+		if uint64(id) >= to {
+			break
+		}
+		// documents are visited in id order, so a later document with the same score ranks lower
+		results.offer(RankResult{id: id, score: t.score(id, q.weights, q.norm)}, k)
+		if len(results) < k {
+			continue
+		}
+
+		threshold := results[0].score
+		first := firstEssential
+		for first < len(q.terms) && q.cumulative[first] <= threshold {
+			first++
+		}
+		if first == len(q.terms) || id == math.MaxUint32 {
+			break
+		}
+		if first != firstEssential {
+			firstEssential = first
+			docs = essential(firstEssential)
+			docs.AdvanceIfNeeded(id + 1)
+		}
+	}
+	return results, nil
+}
+
+// parallelRankThreshold is the number of candidates from which ranking is split across rankWorkers
+// goroutines. Below it, scoring on a single goroutine is faster than starting and merging them.
+var parallelRankThreshold = 1 << 16
+
+// rankWorkers is the number of goroutines ranking a large set of candidates.
+var rankWorkers = runtime.GOMAXPROCS(0)
+
+// partitions splits n candidates into contiguous ranges of positions [from, to), one per worker,
+// or a single range if there are too few candidates to rank them in parallel.
+func partitions(n int) [][2]int {
+	workers := 1
+	if n >= parallelRankThreshold {
+		workers = max(1, min(rankWorkers, n))
+	}
+	parts := make([][2]int, workers)
+	for i := range parts {
+		parts[i] = [2]int{i * n / workers, (i + 1) * n / workers}
+	}
+	return parts
+}
+
+// inParallel calls f with each part on its own goroutine, and returns the error of the first part
+// that failed.
+func inParallel(parts [][2]int, f func(part, from, to int) error) error {
+	if len(parts) == 1 {
+		return f(0, parts[0][0], parts[0][1])
+	}
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f(i, part[0], part[1])
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeTop returns the k best results of the heaps of several workers, best first.
+func mergeTop(tops []topResults, k int) []RankResult {
+	results := tops[0]
+	if len(tops) > 1 {
+		var n int
+		for _, top := range tops {
+			n += len(top)
+		}
+		results = make(topResults, 0, min(k, n))
+		for _, top := range tops {
+			for _, res := range top {
+				results.offer(res, k)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(results))
+	return results
+}
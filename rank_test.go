@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+
+	"stellr/analysis"
+)
+
+func TestRankTop(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet"}
+	corpus := make([]string, 500)
+	for i := range corpus {
+		doc := make([]string, 1+rng.Intn(12))
+		for j := range doc {
+			// skewed so that the first words are common and the last ones rare
+			doc[j] = words[int(float64(len(words))*math.Pow(rng.Float64(), 2))]
+		}
+		corpus[i] = strings.Join(doc, " ")
+	}
+
+	similarities := []Similarity{
+		TFIDF{Tf: ProportionalTf, Idf: StandardIdf, Slope: 1},
+		TFIDF{Tf: LogTf, Idf: SmoothIdf, Slope: 0.2},
+		BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf},
+	}
+	queries := []string{"alpha bravo juliet", "alpha", "india juliet", "alpha bravo charlie delta echo", "missing"}
+	for _, similarity := range similarities {
+		analyzer, err := analysis.NewAnalyzer("english", false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTrieIndex(IndexOptions{analyzer: analyzer, similarity: similarity})
+		for i, text := range corpus {
+			tokens, _ := analyzer.Analyze(text)
+			builder.Add(tokens, uint32(i))
+			if i%7 == 0 {
+				builder.Boost(uint32(i), 0.5+float64(i%3))
+			}
+		}
+		index := builder.Build()
+
+		for _, query := range queries {
+			res, err := index.Search(context.Background(), query, ExactSearch, Or, 0, searchLimits{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ranked, _ := index.Rank(context.Background(), res.tokens, res.DocIds())
+			for _, k := range []int{1, 5, 50, 1000} {
+				top, _ := index.RankTop(context.Background(), res.tokens, res.set, k)
+				expected := ranked[:min(k, len(ranked))]
+				if len(top) != len(expected) {
+					t.Fatalf("%d results instead of %d for %+v, query %q and k %d", len(top), len(expected), similarity, query, k)
+				}
+				for i := range top {
+					// documents with equal scores can come in either order, as the sums are rounded differently
+					tied := func(res RankResult) bool {
+						return res.id == top[i].id && math.Abs(res.score-top[i].score) <= 1e-9
+					}
+					if math.Abs(top[i].score-expected[i].score) > 1e-9 || !slices.ContainsFunc(expected, tied) {
+						t.Errorf("result %d is %+v instead of %+v for %+v, query %q and k %d", i, top[i], expected[i], similarity, query, k)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParallelRanking(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+	corpus := make([]string, 300)
+	for i := range corpus {
+		doc := make([]string, 1+rng.Intn(6))
+		for j := range doc {
+			doc[j] = words[rng.Intn(len(words))]
+		}
+		corpus[i] = strings.Join(doc, " ")
+	}
+
+	for _, similarity := range []Similarity{
+		TFIDF{Tf: LogTf, Idf: SmoothIdf, Slope: 0.2},
+		BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf},
+	} {
+		analyzer, err := analysis.NewAnalyzer("english", false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := buildTestIndex(t, IndexOptions{analyzer: analyzer, similarity: similarity}, corpus)
+		res, err := index.Search(context.Background(), "alpha bravo echo", ExactSearch, Or, 0, searchLimits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// short documents often tie, so results must also be in the same order as sequential ones
+		sequential, _ := index.Rank(context.Background(), res.tokens, res.DocIds())
+		sequentialTop, _ := index.RankTop(context.Background(), res.tokens, res.set, 20)
+		threshold, workers := parallelRankThreshold, rankWorkers
+		parallelRankThreshold, rankWorkers = 1, 7
+		parallel, _ := index.Rank(context.Background(), res.tokens, res.DocIds())
+		parallelTop, _ := index.RankTop(context.Background(), res.tokens, res.set, 20)
+		parallelRankThreshold, rankWorkers = threshold, workers
+
+		if !slices.Equal(parallel, sequential) {
+			t.Errorf("parallel ranking differs from sequential ranking for %+v", similarity)
+		}
+		if !slices.Equal(parallelTop, sequentialTop) {
+			t.Errorf("parallel top results %v differ from sequential ones %v for %+v", parallelTop, sequentialTop, similarity)
+		}
+	}
+}
+
+func TestCanceledSearch(t *testing.T) {
+	analyzer, err := analysis.NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexOptions{analyzer: analyzer, similarity: BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf}}
+	index := buildTestIndex(t, options, []string{"red fox", "red bird", "blue whale"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, searchType := range []SearchType{ExactSearch, PrefixSearch, FuzzySearch} {
+		if _, err := index.Search(ctx, "red", searchType, Or, 1, searchLimits{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected a canceled search, got %v", err)
+		}
+	}
+	if _, err := index.Rank(ctx, []string{"red"}, []uint32{0, 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled ranking, got %v", err)
+	}
+	if _, err := index.RankTop(ctx, []string{"red"}, roaring.BitmapOf(0, 1), 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled ranking, got %v", err)
+	}
+}
+
+func TestAndOperator(t *testing.T) {
+	analyzer, err := analysis.NewAnalyzer("english", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := IndexOptions{analyzer: analyzer, similarity: BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf}}
+	index := buildTestIndex(t, options, []string{"organ music", "organism music", "organ", "music", "organ organism"})
+
+	tests := []struct {
+		query      string
+		searchType SearchType
+		expected   *roaring.Bitmap
+	}{
+		// orga expands to organ and organism, either of which is enough
+		{"orga music", PrefixSearch, roaring.BitmapOf(0, 1)},
+		{"organ music", ExactSearch, roaring.BitmapOf(0)},
+		{"organs musik", FuzzySearch, roaring.BitmapOf(0)},
+		{"organ missing", ExactSearch, roaring.New()},
+		{"missing organ", ExactSearch, roaring.New()},
+		{"orgx music", PrefixSearch, roaring.New()},
+	}
+	for _, test := range tests {
+		res, err := index.Search(context.Background(), test.query, test.searchType, And, 1, searchLimits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.set.Equals(test.expected) {
+			t.Errorf("query %q matched %v, expected %v", test.query, res.set.ToArray(), test.expected.ToArray())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// rankingProfileParams are the search parameters a ranking profile can set.
+var rankingProfileParams = []string{
+	"type", "operator", "distance", "expansion_penalty", "max_expansions", "min_score", "diversify", "mmr_lambda", "mmr_candidates",
+	"expand", "expand_docs", "expand_terms", "expand_weight", "rerank", "rerank_top", "popularity", "stopwords",
+}
+
+// parseRankingProfiles reads the ranking_profile settings of an index, each in the form
+// name:param=value,param=value. A profile sets defaults for the search parameters of
+// rankingProfileParams, applied to searches that select it with profile=name.
+func parseRankingProfiles(values []string) (map[string]url.Values, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	profiles := make(map[string]url.Values, len(values))
+	for _, value := range values {
+		name, settings, ok := strings.Cut(value, ":")
+		if !ok || !indexNamePattern.MatchString(name) {
+			return nil, fmt.Errorf(
+				"invalid ranking_profile %q, must be in the form name:param=value,param=value with a name of up to 64 lowercase letters, digits, '-' or '_'",
+				value,
+			)
+		}
+		if _, err := strconv.ParseBool(name); err == nil {
+			// profile=true asks for the timings of a search
+			return nil, fmt.Errorf("invalid ranking_profile name %q, must not be a boolean", name)
+		}
+		if _, ok := profiles[name]; ok {
+			return nil, fmt.Errorf("ranking_profile %s is defined more than once", name)
+		}
+
+		params := make(url.Values)
+		for _, setting := range strings.Split(settings, ",") {
+			key, val, ok := strings.Cut(setting, "=")
+			if !ok || !slices.Contains(rankingProfileParams, key) {
+				return nil, fmt.Errorf(
+					"invalid setting %q of ranking_profile %s, must be param=value with one of %s",
+					setting, name, strings.Join(rankingProfileParams, ", "),
+				)
+			}
+			params.Set(key, val)
+		}
+		if _, err := parseSearchParams(params); err != nil {
+			return nil, fmt.Errorf("invalid ranking_profile %s: %w", name, err)
+		}
+		profiles[name] = params
+	}
+	return profiles, nil
+}
+
+// parseProfile reads the profile parameter of a search, which can be given twice: true or false
+// ask for the timings of the search or not, and any other value names a ranking profile of the index.
+func parseProfile(values []string) (timings bool, rankingProfile string, err error) {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if b, err := strconv.ParseBool(value); err == nil {
+			timings = b
+		} else if rankingProfile == "" {
+			rankingProfile = value
+		} else {
+			return false, "", fmt.Errorf("profile can only select one ranking profile")
+		}
+	}
+	return timings, rankingProfile, nil
+}
+
+// withRankingProfile returns the parameters of a search that selects a ranking profile of the index,
+// with the settings of the profile for the parameters the search does not set.
+func (idx *namedIndex) withRankingProfile(query url.Values, name string) (searchParams, error) {
+	idx.lock.RLock()
+	profile, ok := idx.options.rankingProfiles[name]
+	idx.lock.RUnlock()
+	if !ok {
+		return searchParams{}, fmt.Errorf("unknown ranking profile %q", name)
+	}
+
+	merged := make(url.Values, len(query)+len(profile))
+	for key, values := range query {
+		merged[key] = values
+	}
+	for key, values := range profile {
+		if !query.Has(key) {
+			merged[key] = values
+		}
+	}
+	return parseSearchParams(merged)
+}
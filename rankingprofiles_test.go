@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRankingProfiles(t *testing.T) {
+	handler := NewApp().Routes()
+	settings := url.Values{"ranking_profile": {"precision:operator=and", "recall:type=fuzzy,distance=1"}}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus?"+settings.Encode(), "red fox\nred bird\nblue whale\n"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	inputs := map[string][]uint32{
+		"query=red+fox":                               {0, 1},
+		"query=red+fox&profile=precision":             {0},
+		"query=red+fox&profile=precision&operator=or": {0, 1}, // parameters of the search take precedence
+		"query=rad&profile=recall":                    {0, 1},
+	}
+	for params, expected := range inputs {
+		if ids := searchIds(t, handler, "/v1/indexes/default/search?"+params); !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected %v for %s, got %v", expected, params, ids)
+		}
+	}
+
+	// a ranking profile can be combined with the timings of the search
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=rad&profile=recall&profile=true", nil))
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"ranking_ms"`)) {
+		t.Errorf("expected a profiled response, got status %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{
+		"/v1/indexes/default/search?query=red&profile=unknown",
+		"/v1/indexes/default/search?query=red&profile=precision&profile=recall",
+		"/v1/search?indexes=default&query=red&profile=precision",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got status %d", path, w.Code)
+		}
+	}
+	for _, profile := range []string{"true:operator=and", "precision", "precision:size=3", "precision:type=bogus", "Precision:operator=and"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/other/corpus?"+url.Values{"ranking_profile": {profile}}.Encode(), "red fox\n"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected ranking_profile %s to be rejected, got status %d", profile, w.Code)
+		}
+	}
+}
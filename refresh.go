@@ -0,0 +1,39 @@
+package main
+
+// defaultMaxStaleRatio is the default of maxStaleRatio.
+const defaultMaxStaleRatio = 0.2
+
+// maxStaleRatio is the fraction of the documents of an index that merges and purges can add or
+// remove before all weights are recomputed. Until then, only the weights of the new documents and
+// of the documents containing a term whose document frequency changed are computed, and the other
+// documents keep the weights computed with the previous collection statistics.
+var maxStaleRatio = defaultMaxStaleRatio
+
+// incrementalBase returns the index whose weights Build can reuse, along with the number of
+// documents added or removed since all weights were computed. It returns nil if every weight must be
+// recomputed.
+func (builder *trieIndexBuilder) incrementalBase() (*trieSearchIndex, int) {
+	if builder.base == nil {
+		return nil, 0
+	}
+	stale := builder.base.stale + builder.changed
+	if float64(stale) > maxStaleRatio*float64(len(builder.docTerms)) {
+		return nil, 0
+	}
+	return builder.base, stale
+}
+
+// reusable reports whether the weights of a document of the index are still valid for the same
+// document of an index built from it, whose document frequencies are df.
+func (t *trieSearchIndex) reusable(id int, terms docTerms, df []uint64) bool {
+	// documents are only ever cleared once built, so a document with as many terms is unchanged
+	if id >= len(t.docTerms) || len(terms.counts) != len(t.docTerms[id].counts) {
+		return false
+	}
+	for token := range terms.counts {
+		if df[token] != t.df[token] {
+			return false
+		}
+	}
+	return true
+}
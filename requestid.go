@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// requestIDHeader carries the id of a request, sent by clients or generated by the server, and is
+// echoed in every response so that client reports can be matched with server logs.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length above which request ids sent by clients are replaced.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID assigns an id to every request, keeping the one sent by the client if it is valid.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID reports whether a request id is short and made of printable ASCII characters, so
+// that it can be written to logs and headers as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestID returns the id of a request, or an empty string for requests that did not go through
+// withRequestID.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf prints a log line prefixed with the id of the request it relates to. r is nil for work that
+// no request started, whose lines have no prefix.
+func logf(r *http.Request, format string, args ...any) {
+	if r == nil {
+		fmt.Printf(format+"\n", args...)
+		return
+	}
+	fmt.Printf("[%s] "+format+"\n", append([]any{requestID(r)}, args...)...)
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/pprof"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+const defaultIndexName = "default"
+
+// maxDocuments is the number of documents an index can hold. Document ids are uint32 positions in
+// the corpus, so building larger indexes fails instead of wrapping ids around.
+var maxDocuments uint64 = math.MaxUint32
+
+func tooManyDocumentsError() error {
+	return fmt.Errorf("an index cannot hold more than %d documents", maxDocuments)
+}
+
+var indexNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// namedIndex is a searchable index together with the corpus it was built from.
+type namedIndex struct {
+	name        string
+	index       SearchIndex
+	corpus      []document
+	fields      *fieldIndex
+	duplicates  []duplicateCluster
+	deleted     *roaring.Bitmap // documents deleted since the index was built, nil if none
+	expirations []expiration    // documents with an expiry time that have not been deleted yet
+	clicks      *clickFeedback  // documents chosen among the results of queries, nil until a corpus is indexed
+	purging     bool            // whether deleted documents are being purged
+	memory      int64           // estimated bytes used by the index and its corpus
+	breaker     *memoryBreaker
+	options     IndexOptions
+	lock        sync.RWMutex
+}
+
+// indexBuild accumulates documents for a new version of an index without holding any lock.
+type indexBuild struct {
+	builder IndexBuilder
+	corpus  []document
+	options IndexOptions
+	dedup   *deduplicator // nil when near-duplicate detection is disabled
+}
+
+func newIndexBuild(options IndexOptions) *indexBuild {
+	build := &indexBuild{builder: NewTrieIndex(options), corpus: make([]document, 0), options: options}
+	if options.dedup.mode != NoDedup {
+		build.dedup = newDeduplicator(options.dedup)
+	}
+	return build
+}
+
+// add analyzes text, indexes it under the next document id and appends doc to the corpus.
+// Near-duplicates of earlier documents are flagged or skipped, depending on the dedup options.
+func (b *indexBuild) add(doc document, text string) error {
+	tokens, err := b.options.analyzer.Analyze(text)
+	if err != nil {
+		return err
+	}
+	return b.addTokens(doc, tokens)
+}
+
+// addTokens is like add for text that was already analyzed.
+func (b *indexBuild) addTokens(doc document, tokens []string) error {
+	if uint64(len(b.corpus)) >= maxDocuments {
+		return tooManyDocumentsError()
+	}
+	id := uint32(len(b.corpus))
+	if b.dedup != nil {
+		var keep bool
+		if doc, keep = b.dedup.check(doc, tokens, id); !keep {
+			return nil
+		}
+	}
+	b.builder.Add(tokens, id)
+	if doc.boost != 0 {
+		b.builder.Boost(id, doc.boost)
+	}
+	b.corpus = append(b.corpus, doc)
+	return nil
+}
+
+// addVacant reserves the next document id for a document that is not in this version of the index.
+func (b *indexBuild) addVacant() error {
+	if uint64(len(b.corpus)) >= maxDocuments {
+		return tooManyDocumentsError()
+	}
+	b.builder.AddVacant(uint32(len(b.corpus)))
+	b.corpus = append(b.corpus, document{vacant: true})
+	return nil
+}
+
+// replace builds the index and swaps it in, replacing the previous version.
+func (idx *namedIndex) replace(b *indexBuild) {
+	searchIndex := b.builder.Build()
+	fields := newFieldIndex(b.corpus)
+	expirations := newExpirations(b.corpus)
+	memory := estimateMemory(searchIndex, b.corpus)
+	var duplicates []duplicateCluster
+	if b.dedup != nil {
+		duplicates = b.dedup.result()
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.clicks = idx.clicksByKey(b.corpus)
+	idx.index = searchIndex
+	idx.corpus = b.corpus
+	idx.fields = fields
+	idx.duplicates = duplicates
+	idx.deleted = nil
+	idx.expirations = expirations
+	idx.options = b.options
+	idx.setMemory(memory)
+}
+
+type App struct {
+	indexes       map[string]*namedIndex
+	indexesLock   sync.RWMutex
+	queryLog      *jsonLog            // nil when query logging is disabled
+	auditLog      *jsonLog            // nil when audit logging is disabled
+	apiKeys       map[string]*apiKey  // by key, nil when API keys are disabled
+	rerankers     map[string]Reranker // ranking models by name
+	limits        uploadLimits
+	searchLimits  searchLimits
+	searchLimiter *searchLimiter
+	memory        *memoryBreaker
+	uploads       map[string]*uploadSession
+	uploadsLock   sync.Mutex
+}
+
+func NewApp() *App {
+	memory := &memoryBreaker{}
+	return &App{
+		indexes:       map[string]*namedIndex{defaultIndexName: {name: defaultIndexName, breaker: memory}},
+		memory:        memory,
+		limits:        uploadLimits{maxUploadSize: defaultMaxUploadSize, maxChunkedSize: defaultMaxChunkedSize, maxLineSize: defaultMaxLineSize},
+		searchLimits:  searchLimits{maxExpansions: defaultMaxExpansions},
+		searchLimiter: newSearchLimiter(defaultMaxConcurrentSearches, defaultMaxQueuedSearches, defaultMaxSearchWait),
+		uploads:       make(map[string]*uploadSession),
+	}
+}
+
+// indexName returns the index a request refers to. Legacy endpoints have no name in the path
+// and always use the default index.
+func indexName(r *http.Request) string {
+	if name := r.PathValue("name"); name != "" {
+		return name
+	}
+	return defaultIndexName
+}
+
+func (a *App) getIndex(name string) (*namedIndex, bool) {
+	a.indexesLock.RLock()
+	defer a.indexesLock.RUnlock()
+	idx, ok := a.indexes[name]
+	return idx, ok
+}
+
+func (a *App) getOrCreateIndex(name string) *namedIndex {
+	a.indexesLock.Lock()
+	defer a.indexesLock.Unlock()
+	idx, ok := a.indexes[name]
+	if !ok {
+		idx = &namedIndex{name: name, breaker: a.memory}
+		a.indexes[name] = idx
+	}
+	return idx
+}
+
+// lookupIndex writes an error response and returns false if the index does not exist.
+func (a *App) lookupIndex(w http.ResponseWriter, r *http.Request) (*namedIndex, bool) {
+	name := indexName(r)
+	idx, ok := a.getIndex(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, errIndexNotFound, "index "+name+" does not exist")
+	}
+	return idx, ok
+}
+
+// validIndexName writes an error response and returns false if the name in the path is not a valid index name.
+func validIndexName(w http.ResponseWriter, r *http.Request) bool {
+	if !indexNamePattern.MatchString(indexName(r)) {
+		writeError(
+			w, http.StatusBadRequest, errInvalidParameter,
+			"invalid index name, use up to 64 lowercase letters, digits, '-' or '_'",
+		)
+		return false
+	}
+	return true
+}
+
+type indexInfo struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"`
+	Language  string `json:"language,omitempty"`
+	Stem      bool   `json:"stem"`
+}
+
+func (a *App) listIndexes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	a.indexesLock.RLock()
+	result := make([]indexInfo, 0, len(a.indexes))
+	for _, idx := range a.indexes {
+		idx.lock.RLock()
+		result = append(result, indexInfo{
+			Name:      idx.name,
+			Documents: len(idx.corpus),
+			Language:  idx.options.language,
+			Stem:      idx.options.stem,
+		})
+		idx.lock.RUnlock()
+	}
+	a.indexesLock.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	writeResponse(w, r, result)
+}
+
+func (a *App) deleteIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	name := indexName(r)
+	a.indexesLock.Lock()
+	defer a.indexesLock.Unlock()
+
+	idx, ok := a.indexes[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, errIndexNotFound, "index "+name+" does not exist")
+		return
+	}
+	idx.lock.Lock()
+	idx.setMemory(0)
+	idx.lock.Unlock()
+	if name == defaultIndexName {
+		a.indexes[name] = &namedIndex{name: name, breaker: a.memory}
+	} else {
+		delete(a.indexes, name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// swapIndexes exchanges the contents of two indexes, so that a new version can be built and
+// validated under another name before taking the place of the live one.
+func (a *App) swapIndexes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	name, other := indexName(r), r.URL.Query().Get("with")
+	if other == "" || other == name {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "with must name another index")
+		return
+	}
+
+	a.indexesLock.Lock()
+	defer a.indexesLock.Unlock()
+
+	for _, n := range []string{name, other} {
+		if _, ok := a.indexes[n]; !ok {
+			writeError(w, http.StatusNotFound, errIndexNotFound, "index "+n+" does not exist")
+			return
+		}
+	}
+
+	// indexes are locked in name order so that concurrent swaps cannot deadlock
+	first, second := a.indexes[min(name, other)], a.indexes[max(name, other)]
+	first.lock.Lock()
+	second.lock.Lock()
+	first.index, second.index = second.index, first.index
+	first.corpus, second.corpus = second.corpus, first.corpus
+	first.fields, second.fields = second.fields, first.fields
+	first.duplicates, second.duplicates = second.duplicates, first.duplicates
+	first.deleted, second.deleted = second.deleted, first.deleted
+	first.expirations, second.expirations = second.expirations, first.expirations
+	first.clicks, second.clicks = second.clicks, first.clicks
+	first.memory, second.memory = second.memory, first.memory
+	first.options, second.options = second.options, first.options
+	second.lock.Unlock()
+	first.lock.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptsMsgpack reports whether the Accept header asks for MessagePack.
+func acceptsMsgpack(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if mediaType == "application/msgpack" || mediaType == "application/x-msgpack" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResponse sends v with status 200, encoded as MessagePack if the client accepts it and as JSON otherwise.
+// Both encodings use the same field names.
+func writeResponse(w http.ResponseWriter, r *http.Request, v any) {
+	writeResponseStatus(w, r, http.StatusOK, v)
+}
+
+// writeResponseStatus is like writeResponse with a custom status code.
+func writeResponseStatus(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Add("Vary", "Accept")
+
+	var err error
+	if acceptsMsgpack(r) {
+		w.Header().Set("Content-Type", "application/msgpack")
+		w.WriteHeader(status)
+		err = newMsgpackEncoder(w).Encode(v)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		err = json.NewEncoder(w).Encode(v)
+	}
+	if err != nil {
+		logf(r, "Error encoding response: %s", err)
+	}
+}
+
+// deprecated marks a legacy endpoint as deprecated and points clients to its /v1 successor.
+func deprecated(successor string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		handler(w, r)
+	}
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, errNotFound, "no endpoint at "+r.URL.Path)
+}
+
+// Routes returns the handler of the whole API, for deployments serving admin endpoints on the same
+// listener as searches.
+func (a *App) Routes() http.Handler {
+	mux := http.NewServeMux()
+	a.handleSearches(mux)
+	a.handleAdmin(mux)
+	mux.HandleFunc("/{$}", demo)
+	mux.HandleFunc("/", notFound)
+	return withRequestID(a.authenticate(mux))
+}
+
+// SearchRoutes returns the handler of the API without the admin endpoints, so that it can be exposed
+// while AdminRoutes is served on a separate, private listener.
+func (a *App) SearchRoutes() http.Handler {
+	mux := http.NewServeMux()
+	a.handleSearches(mux)
+	mux.HandleFunc("/{$}", demo)
+	mux.HandleFunc("/", notFound)
+	return withRequestID(a.authenticate(mux))
+}
+
+// AdminRoutes returns the handler of the endpoints that change indexes or expose the internals of
+// the server, along with the profiling endpoints of net/http/pprof.
+func (a *App) AdminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	a.handleAdmin(mux)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/", notFound)
+	return withRequestID(a.authenticate(mux))
+}
+
+// handleSearches registers the endpoints that read indexes.
+func (a *App) handleSearches(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/indexes", a.listIndexes)
+	mux.HandleFunc("/v1/usage", a.usage)
+	mux.HandleFunc("/v1/search", a.meterQueries(a.limitSearches(a.federatedSearch)))
+	mux.HandleFunc("/v1/indexes/{name}/search", a.meterQueries(a.limitSearches(a.search)))
+	mux.HandleFunc("/v1/indexes/{name}/facets", a.meterQueries(a.limitSearches(a.facetCounts)))
+	mux.HandleFunc("/v1/indexes/{name}/stats", a.indexStats)
+	mux.HandleFunc("/v1/indexes/{name}/terms", a.listTerms)
+	mux.HandleFunc("/v1/indexes/{name}/terms/suggest", a.suggestTerms)
+	mux.HandleFunc("/v1/indexes/{name}/evaluate", a.meterQueries(a.limitSearches(a.evaluate)))
+	mux.HandleFunc("/v1/indexes/{name}/duplicates", a.duplicates)
+	mux.HandleFunc("/v1/indexes/{name}/documents/{id}/keywords", a.documentKeywords)
+	mux.HandleFunc("/v1/indexes/{name}/documents/_mget", a.multiGet)
+
+	mux.HandleFunc("/search", deprecated("/v1/indexes/default/search", a.meterQueries(a.limitSearches(a.search))))
+
+	// Elasticsearch compatibility
+	mux.HandleFunc("/{name}/_search", a.meterQueries(a.limitSearches(a.esSearch)))
+}
+
+// handleAdmin registers the endpoints that change indexes and the metrics of the server.
+func (a *App) handleAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/metrics", a.metrics)
+	mux.HandleFunc("/v1/indexes/{name}/debug/trie", a.exportTrie)
+	mux.HandleFunc("/v1/indexes/{name}", a.audited("delete_index", a.deleteIndex))
+	mux.HandleFunc("/v1/indexes/{name}/corpus", a.audited("upload_corpus", a.uploadCorpus))
+	mux.HandleFunc("/v1/indexes/{name}/uploads", a.audited("create_upload", a.createUpload))
+	mux.HandleFunc("/v1/indexes/{name}/uploads/{id}", a.audited("cancel_upload", a.upload))
+	mux.HandleFunc("/v1/indexes/{name}/uploads/{id}/chunks/{n}", a.audited("upload_chunk", a.uploadChunk))
+	mux.HandleFunc("/v1/indexes/{name}/uploads/{id}/finalize", a.audited("finalize_upload", a.finalizeUpload))
+	mux.HandleFunc("/v1/indexes/{name}/urls", a.audited("upload_urls", a.uploadUrls))
+	mux.HandleFunc("/v1/indexes/{name}/merge", a.audited("merge_indexes", a.mergeIndexes))
+	mux.HandleFunc("/v1/indexes/{name}/_swap", a.audited("swap_indexes", a.swapIndexes))
+	mux.HandleFunc("/v1/indexes/{name}/documents/{id}", a.audited("delete_document", a.deleteDocument))
+	mux.HandleFunc("/v1/indexes/{name}/feedback", a.audited("record_feedback", a.feedback))
+
+	mux.HandleFunc("/uploadCorpus", deprecated("/v1/indexes/default/corpus", a.audited("upload_corpus", a.uploadCorpus)))
+	mux.HandleFunc("/uploadUrls", deprecated("/v1/indexes/default/urls", a.audited("upload_urls", a.uploadUrls)))
+
+	// Elasticsearch compatibility
+	mux.HandleFunc("/_bulk", a.audited("bulk", a.esBulk))
+	mux.HandleFunc("/{name}/_bulk", a.audited("bulk", a.esBulk))
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func uploadRequest(t *testing.T, url string, corpus string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("corpus", "corpus.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(corpus))
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, url, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func searchIds(t *testing.T, handler http.Handler, url string) []uint32 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("search %s failed with status %d: %s", url, w.Code, w.Body.String())
+	}
+	var results []searchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint32, len(results))
+	for i, res := range results {
+		ids[i] = res.Id
+	}
+	return ids
+}
+
+func TestNamedIndexes(t *testing.T) {
+	handler := NewApp().Routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/books/corpus", "the old man and the sea\na tale of two cities\n"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	if ids := searchIds(t, handler, "/v1/indexes/books/search?query=sea"); len(ids) != 1 || ids[0] != 0 {
+		t.Errorf("wrong search results %v", ids)
+	}
+
+	// the default index used by the legacy endpoints is separate from named indexes
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?query=sea", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected empty default index, got status %d", w.Code)
+	}
+	if w.Header().Get("Deprecation") != "true" {
+		t.Errorf("legacy endpoint should be marked as deprecated")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/indexes/books/search?query=cities", nil)
+	r.Header.Set("Accept", "application/msgpack, application/json;q=0.5")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Content-Type") != "application/msgpack" {
+		t.Errorf("expected msgpack response, got %s", w.Header().Get("Content-Type"))
+	}
+	var packed []searchResponse
+	dec := msgpack.NewDecoder(w.Body)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&packed); err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) != 1 || packed[0].Id != 1 || packed[0].Text != "a tale of two cities" {
+		t.Errorf("wrong msgpack search results %+v", packed)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes", nil))
+	var indexes []indexInfo
+	if err := json.NewDecoder(w.Body).Decode(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 2 || indexes[0].Name != "books" || indexes[0].Documents != 2 {
+		t.Errorf("wrong index list %+v", indexes)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/indexes/books", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete failed with status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/Bad%20Name/corpus", "foo\n"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid index name accepted with status %d", w.Code)
+	}
+}
+
+func TestNormalizedScores(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred fox jumps high\nblue whale\n"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red+fox&normalize=true", nil))
+	var results []searchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	for _, res := range results {
+		if res.NormalizedScore == nil || *res.NormalizedScore < 0 || *res.NormalizedScore > 1 {
+			t.Errorf("normalized score out of range for %+v", res)
+		}
+	}
+	// the first document contains exactly the query terms
+	if *results[0].NormalizedScore != 1 {
+		t.Errorf("expected a normalized score of 1 for an exact match, got %v", *results[0].NormalizedScore)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red", nil))
+	if bytes.Contains(w.Body.Bytes(), []byte("normalized_score")) {
+		t.Errorf("normalized scores should only be returned when requested")
+	}
+}
+
+func TestMinScore(t *testing.T) {
+	handler := NewApp().Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred fox jumps high\nblue whale\n"))
+
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox&min_score=0.99"); len(ids) != 1 || ids[0] != 0 {
+		t.Errorf("expected only the exact match above the minimum score, got %v", ids)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/default/search?query=red+fox&min_score=0.01"); len(ids) != 2 {
+		t.Errorf("expected both matches above a low minimum score, got %v", ids)
+	}
+	for _, minScore := range []string{"-0.1", "1.5", "high", "NaN"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red&min_score="+minScore, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected min_score %s to be rejected, got status %d", minScore, w.Code)
+		}
+	}
+}
+
+func TestSearchProfile(t *testing.T) {
+	handler := NewApp().Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred bird\nblue whale\n"))
+
+	for _, accept := range []string{"application/json", "application/msgpack"} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=red+blu&type=prefix&profile=true", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var response struct {
+			Results []searchResponse `json:"results"`
+			Profile searchProfile    `json:"profile"`
+		}
+		var err error
+		if accept == "application/msgpack" {
+			dec := msgpack.NewDecoder(w.Body)
+			dec.SetCustomStructTag("json")
+			err = dec.Decode(&response)
+		} else {
+			err = json.NewDecoder(w.Body).Decode(&response)
+		}
+		if err != nil {
+			t.Fatalf("error decoding %s response: %s", accept, err)
+		}
+
+		profile := response.Profile
+		expected := []termProfile{{Token: "red", Expansions: 1, Documents: 2}, {Token: "blu", Expansions: 1, Documents: 1}}
+		for i := range profile.Terms {
+			profile.Terms[i].Lookup = 0
+		}
+		if !reflect.DeepEqual(profile.Terms, expected) {
+			t.Errorf("expected term profiles %+v, got %+v", expected, profile.Terms)
+		}
+		if len(response.Results) != 3 || profile.Candidates != 3 || profile.Results != 3 {
+			t.Errorf("expected 3 candidates and results, got %d results and profile %+v", len(response.Results), profile)
+		}
+		if profile.Total <= 0 {
+			t.Errorf("expected a total time, got profile %+v", profile)
+		}
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	handler := NewApp().Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus?stem=true", "Running runs\nred fox\n"))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=run+fox&matches=true", nil))
+	var results []searchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string][]string{{"run": {"Running", "runs"}}, {"fox": {"fox"}}}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	for _, res := range results {
+		if !reflect.DeepEqual(res.Matches, expected[res.Id]) {
+			t.Errorf("expected matches %v for document %d, got %v", expected[res.Id], res.Id, res.Matches)
+		}
+	}
+}
+
+func TestIndexStats(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred fox jumps high\nblue whale\n"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/stats?top=2", nil))
+	var stats IndexStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Documents != 3 || stats.VocabularySize != 6 || stats.TotalTokens != 8 {
+		t.Errorf("wrong stats %+v", stats)
+	}
+	expectedTop := []termDocFreq{{"fox", 2}, {"red", 2}}
+	if len(stats.TopTerms) != 2 || stats.TopTerms[0] != expectedTop[0] || stats.TopTerms[1] != expectedTop[1] {
+		t.Errorf("wrong top terms %+v", stats.TopTerms)
+	}
+	if stats.TokenLengthHistogram[3] != 2 || stats.TokenLengthHistogram[4] != 2 || stats.TokenLengthHistogram[5] != 2 {
+		t.Errorf("wrong token length histogram %v", stats.TokenLengthHistogram)
+	}
+}
+
+func TestCorpusStopwords(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := "error disk full\nerror timeout\nerror in the parser\nwarning disk slow\n"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/logs/corpus?stopwords=corpus&max_df=0.5", corpus))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/logs/stats", nil))
+	var stats IndexStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats.Stopwords, []string{"error"}) {
+		t.Errorf("expected error to be the only stop word, got %v", stats.Stopwords)
+	}
+	// the stop words of the language are kept, and queries drop the stop words of the corpus
+	if ids := searchIds(t, handler, "/v1/indexes/logs/search?query=the"); !reflect.DeepEqual(ids, []uint32{2}) {
+		t.Errorf("expected a language stop word to be indexed, got %v", ids)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/logs/search?query=error+disk&operator=and"); len(ids) != 2 {
+		t.Errorf("expected the corpus stop word to be ignored, got %v", ids)
+	}
+
+	for _, params := range []string{"stopwords=stemmed", "max_df=0.5", "stopwords=corpus&max_df=1.5", "stopwords=corpus&max_df=0"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/logs/corpus?"+params, corpus))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got status %d", params, w.Code)
+		}
+	}
+}
+
+func TestCorpusStopwordsKeepSharedAnalyzer(t *testing.T) {
+	options, err := parseIndexOptions(httptest.NewRequest(http.MethodPost, "/?stopwords=corpus&max_df=0.5", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// finalizing a chunked upload again reuses the options of the session
+	for range 2 {
+		docs := []analyzedDocument{{tokens: []string{"error", "disk"}}, {tokens: []string{"error", "timeout"}}}
+		retry := options
+		removeCorpusStopwords(docs, &retry)
+		if tokens, _ := retry.analyzer.Analyze("error disk"); !reflect.DeepEqual(tokens, []string{"disk"}) {
+			t.Errorf("expected the corpus stop word to be removed, got %v", tokens)
+		}
+	}
+	if tokens, _ := options.analyzer.Analyze("error disk"); !reflect.DeepEqual(tokens, []string{"error", "disk"}) {
+		t.Errorf("expected the shared analyzer to be unchanged, got %v", tokens)
+	}
+}
+
+func TestQueryStopwords(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := "The Who played live\nthe band played\nlive music\n"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus?stopwords=query", corpus))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	inputs := map[string][]uint32{
+		"query=the+who+live":                                {2, 0}, // stop words are removed from queries by default
+		"query=the+who+live&stopwords=remove":               {2, 0},
+		"query=the+who&stopwords=keep&operator=and":         {0},
+		"query=the+who+live&stopwords=keep&operator=and":    {0},
+		"query=the+band+played&stopwords=keep&operator=and": {1},
+	}
+	for params, expected := range inputs {
+		if ids := searchIds(t, handler, "/v1/indexes/default/search?"+params); !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected %v for %s, got %v", expected, params, ids)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?query=the&stopwords=drop", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid stopwords to be rejected, got status %d", w.Code)
+	}
+
+	// stop words are not indexed with the language stop words, so keeping them would match nothing
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/bands/corpus", corpus))
+	for _, params := range []string{"query=the+band&operator=and", "query=the+band&operator=and&stopwords=keep"} {
+		if ids := searchIds(t, handler, "/v1/indexes/bands/search?"+params); !reflect.DeepEqual(ids, []uint32{1}) {
+			t.Errorf("expected [1] for %s, got %v", params, ids)
+		}
+	}
+}
+
+func TestExpansionPenalty(t *testing.T) {
+	handler := NewApp().Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", "car\ncarpet\ncar wash\nbike\nbike ride\n"))
+
+	// carpet is rarer than car, so it ranks first unless expansions are penalized
+	inputs := map[string][]uint32{
+		"query=car&type=prefix&expansion_penalty=1": {1, 0, 2},
+		"query=car&type=prefix":                     {0, 1, 2},
+	}
+	for params, expected := range inputs {
+		if ids := searchIds(t, handler, "/v1/indexes/default/search?"+params); !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected %v for %s, got %v", expected, params, ids)
+		}
+	}
+
+	for _, params := range []string{"query=car&expansion_penalty=0.5", "query=car&type=prefix&expansion_penalty=0", "query=car&type=prefix&expansion_penalty=2"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/search?"+params, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got status %d", params, w.Code)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	corpus := "the quick brown fox jumps over the lazy dog near the river bank\n" +
+		"a completely different sentence about whales swimming in the ocean\n" +
+		"the quick brown fox jumps over the lazy dog near the river bank!\n"
+	handler := NewApp().Routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/flagged/corpus?dedup=flag", corpus))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/flagged/search?query=fox"); len(ids) != 2 {
+		t.Errorf("flagged duplicates should still be indexed, got %v", ids)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/flagged/duplicates", nil))
+	var clusters []duplicateCluster
+	if err := json.NewDecoder(w.Body).Decode(&clusters); err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Id != 0 || len(clusters[0].Duplicates) != 1 || clusters[0].Duplicates[0] != 2 {
+		t.Errorf("wrong duplicate clusters %+v", clusters)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/skipped/corpus?dedup=skip", corpus))
+	if ids := searchIds(t, handler, "/v1/indexes/skipped/search?query=fox"); len(ids) != 1 || ids[0] != 0 {
+		t.Errorf("skipped duplicates should not be indexed, got %v", ids)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/invalid/corpus?dedup_threshold=0.5", corpus))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("dedup_threshold without dedup accepted with status %d", w.Code)
+	}
+}
+
+func TestDocumentKeywords(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "red fox red fox whale\nred bird\nblue bird\n"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/documents/0/keywords?n=2", nil))
+	var keywords []keyword
+	if err := json.NewDecoder(w.Body).Decode(&keywords); err != nil {
+		t.Fatal(err)
+	}
+	// fox is repeated and appears in a single document, red is present in two documents
+	if len(keywords) != 2 || keywords[0].Term != "fox" || keywords[1].Term != "whale" {
+		t.Errorf("wrong keywords %+v", keywords)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/documents/3/keywords", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected missing document, got status %d", w.Code)
+	}
+}
+
+func TestDemoPage(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("<title>stellr</title>")) {
+		t.Errorf("expected the demo page, got status %d", w.Code)
+	}
+}
+
+func TestFederatedSearch(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/animals/corpus", "red fox\nred fox jumps high\nblue whale\n"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/colors/corpus?similarity=bm25", "red\nblue and green\n"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/search?indexes=animals,colors&query=red", nil))
+	var results []searchResponse
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	for i, res := range results {
+		if res.Index == "" || res.NormalizedScore == nil {
+			t.Errorf("missing index or normalized score in %+v", res)
+		}
+		if i > 0 && *res.NormalizedScore > *results[i-1].NormalizedScore {
+			t.Errorf("results not sorted by normalized score: %+v", results)
+		}
+	}
+	if results[2].Index != "animals" || results[2].Id != 1 {
+		t.Errorf("expected the longest document last, got %+v", results[2])
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/search?indexes=animals,missing&query=red", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected missing index, got status %d", w.Code)
+	}
+}
+
+func TestSwapIndexes(t *testing.T) {
+	handler := NewApp().Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/live/corpus", "old version\n"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/next/corpus", "new version\nanother new document\n"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/live/_swap?with=next", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("swap failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/live/search?query=new"); len(ids) != 2 {
+		t.Errorf("expected the new version to be live, got %v", ids)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/next/search?query=old"); len(ids) != 1 {
+		t.Errorf("expected the old version under the other name, got %v", ids)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/live/_swap?with=missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected missing index, got status %d", w.Code)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := "Donaudampfschiff\nDampf und Wasser\n"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/both/corpus?language=german&filters=decompound", corpus))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(
+		w, uploadRequest(t, "/v1/indexes/index_only/corpus?language=german&filters=decompound&search_filters=", corpus),
+	)
+
+	if ids := searchIds(t, handler, "/v1/indexes/both/search?query=Donaudampfschiff"); len(ids) != 2 {
+		t.Errorf("expected the query to be decompounded, got %v", ids)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/index_only/search?query=Donaudampfschiff"); len(ids) != 1 {
+		t.Errorf("expected only the compound to match, got %v", ids)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/index_only/search?query=dampf"); len(ids) != 2 {
+		t.Errorf("expected compounds to be split when indexing, got %v", ids)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	app := NewApp()
+	app.limits = uploadLimits{maxUploadSize: 1 << 10, maxLineSize: 16}
+	handler := app.Routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", strings.Repeat("short line\n", 200)))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "1 KB") {
+		t.Errorf("expected 413 stating the upload limit, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "short line\nthis line is far too long\n"))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "line 2") {
+		t.Errorf("expected 413 stating the long line, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMinPrefixLength(t *testing.T) {
+	app := NewApp()
+	app.searchLimits.minPrefixLength = 2
+	handler := app.Routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus?min_prefix_length=3", "red fox\nreading\n"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, uploadRequest(t, "/v1/indexes/server/corpus", "red fox\nreading\n"))
+
+	for _, test := range []struct {
+		url  string
+		code int
+	}{
+		{"/v1/indexes/default/search?query=re&type=prefix", http.StatusBadRequest},
+		{"/v1/indexes/default/search?query=re&type=fuzzy&distance=1", http.StatusBadRequest},
+		{"/v1/indexes/default/search?query=rea&type=prefix", http.StatusOK},
+		{"/v1/indexes/default/search?query=re", http.StatusOK},
+		{"/v1/indexes/server/search?query=r&type=prefix", http.StatusBadRequest},
+		{"/v1/indexes/server/search?query=re&type=prefix", http.StatusOK},
+	} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if w.Code != test.code {
+			t.Errorf("expected status %d for %s, got %d: %s", test.code, test.url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestResultSizes(t *testing.T) {
+	app := NewApp()
+	app.searchLimits.maxSize = 3
+	handler := app.Routes()
+	corpus := "red a\nred b\nred c\nred d\n"
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/server/corpus", corpus))
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/sized/corpus?default_size=1&max_size=2", corpus))
+
+	for _, test := range []struct {
+		url     string
+		results int
+		maxSize string
+	}{
+		{"/v1/indexes/server/search?query=red", 3, "3"},
+		{"/v1/indexes/server/search?query=red&size=2", 2, ""},
+		{"/v1/indexes/sized/search?query=red", 1, ""},
+		{"/v1/indexes/sized/search?query=red&size=10", 2, "2"},
+		{"/v1/search?indexes=server,sized&query=red", 3, "3"},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.url, nil))
+		var results []searchResponse
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != test.results || w.Header().Get(maxSizeHeader) != test.maxSize {
+			t.Errorf(
+				"expected %d results and a maximum size of %q for %s, got %d and %q",
+				test.results, test.maxSize, test.url, len(results), w.Header().Get(maxSizeHeader),
+			)
+		}
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	app := NewApp()
+	public, admin := app.SearchRoutes(), app.AdminRoutes()
+
+	w := httptest.NewRecorder()
+	public.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nblue whale\n"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected uploads to be missing from the search listener, got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nblue whale\n"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload on the admin listener failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if ids := searchIds(t, public, "/v1/indexes/default/search?query=fox"); len(ids) != 1 {
+		t.Errorf("expected 1 result, got %v", ids)
+	}
+
+	for path, handler := range map[string]http.Handler{"/v1/metrics": public, "/v1/indexes/default/search?query=fox": admin} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected %s to be missing, got status %d", path, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/indexes/default/debug/trie", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "digraph trie {") {
+		t.Errorf("expected the trie on the admin listener, got status %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected profiling on the admin listener, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+type IdfWeighting int
+
+const (
+	// StandardIdf is log(N/df). Terms present in every document get an IDF of 0.
+	StandardIdf IdfWeighting = iota
+	// SmoothIdf is log((1+N)/(1+df)) + 1, as if every term appeared in one extra document.
+	// Terms present in every document still get a positive weight.
+	SmoothIdf
+	// ProbabilisticIdf is log(1 + (N-df+0.5)/(df+0.5)), the IDF used by BM25.
+	ProbabilisticIdf
+)
+
+func parseIdfWeighting(s string) (IdfWeighting, error) {
+	switch s {
+	case "", "standard":
+		return StandardIdf, nil
+	case "smooth":
+		return SmoothIdf, nil
+	case "probabilistic":
+		return ProbabilisticIdf, nil
+	}
+	return StandardIdf, fmt.Errorf("invalid idf %q, must be one of standard, smooth or probabilistic", s)
+}
+
+// idf returns the inverse document frequency of a term that appears in df out of nDocs documents.
+func (w IdfWeighting) idf(nDocs int, df uint64) float64 {
+	n, d := float64(nDocs), float64(df)
+	switch w {
+	case SmoothIdf:
+		return math.Log((1+n)/(1+d)) + 1
+	case ProbabilisticIdf:
+		return math.Log(1 + (n-d+0.5)/(d+0.5))
+	default:
+		return math.Log(n / d)
+	}
+}
+
+// defaultIdf is the IDF of query terms that are not in the vocabulary, computed as if the term
+// appeared in a single extra document. It is always positive, so unknown terms lower the
+// similarity through the query norm but never subtract from the dot product.
+func (w IdfWeighting) defaultIdf(nDocs int) float64 {
+	return w.idf(nDocs+1, 1)
+}
+
+type TfWeighting int
+
+const (
+	// ProportionalTf divides the count of a term by the number of tokens in the document.
+	ProportionalTf TfWeighting = iota
+	// RawTf is the count of the term in the document.
+	RawTf
+	// LogTf is 1 + log(count), damping the effect of repeated terms.
+	LogTf
+	// BooleanTf is 1 for every term present in the document.
+	BooleanTf
+	// AugmentedTf is 0.5 + 0.5 * count / max count, which limits the advantage of long documents.
+	AugmentedTf
+)
+
+func parseTfWeighting(s string) (TfWeighting, error) {
+	switch s {
+	case "", "proportional":
+		return ProportionalTf, nil
+	case "raw":
+		return RawTf, nil
+	case "log":
+		return LogTf, nil
+	case "boolean":
+		return BooleanTf, nil
+	case "augmented":
+		return AugmentedTf, nil
+	}
+	return ProportionalTf, fmt.Errorf("invalid tf %q, must be one of proportional, raw, log, boolean or augmented", s)
+}
+
+// tf returns the TF weight of a term that occurs count times in a text of length tokens,
+// where the most frequent term occurs maxCount times.
+func (w TfWeighting) tf(count int, maxCount int, length int) float64 {
+	c := float64(count)
+	switch w {
+	case RawTf:
+		return c
+	case LogTf:
+		return 1 + math.Log(c)
+	case BooleanTf:
+		return 1
+	case AugmentedTf:
+		return 0.5 + 0.5*c/float64(maxCount)
+	default:
+		return c / float64(length)
+	}
+}
+
+// TermStats describes the occurrences of a term in a document or in a query.
+type TermStats struct {
+	Count    int    // occurrences of the term
+	MaxCount int    // occurrences of the most frequent term
+	Length   int    // number of tokens
+	DocFreq  uint64 // number of documents containing the term, 0 if it is not in the vocabulary
+}
+
+// CollectionStats describes the indexed corpus as a whole.
+type CollectionStats struct {
+	NumDocs   int
+	AvgLength float64 // average number of tokens per document
+	AvgNorm   float64 // average of the square root of the document norms
+}
+
+// Similarity is the ranking model used to score documents against a query.
+// Documents and queries are represented as vectors of term weights, and the score of a document
+// is derived from the dot product of both vectors.
+type Similarity interface {
+	// TermWeight returns the weight of a term in a document, or in the query if query is true.
+	TermWeight(term TermStats, collection CollectionStats, query bool) float64
+	// DocNorm returns the norm of a document or query vector given its weights, passed as is to Combine.
+	DocNorm(weights []float64) float64
+	// Combine computes the score of a document from the dot product between the query and document vectors.
+	Combine(dot float64, queryNorm float64, docNorm float64, collection CollectionStats) float64
+}
+
+// ScaledSimilarity is implemented by similarities whose Combine never exceeds the dot product
+// multiplied by a query factor and a document factor. Ranking uses the factors to bound the
+// score each query term can add to a document, so documents that cannot reach the top results
+// are skipped.
+type ScaledSimilarity interface {
+	QueryScale(queryNorm float64) float64
+	DocScale(docNorm float64, collection CollectionStats) float64
+}
+
+// BoundedSimilarity is implemented by similarities that know the highest score a query can reach.
+type BoundedSimilarity interface {
+	MaxScore(query map[string]TermStats, collection CollectionStats) float64
+}
+
+// termCounts returns the number of occurrences of each distinct token and the highest count.
+func termCounts(tokens []string) (map[string]int, int) {
+	counts := make(map[string]int)
+	var maxCount int
+	for _, token := range tokens {
+		counts[token]++
+		maxCount = max(maxCount, counts[token])
+	}
+	return counts, maxCount
+}
+
+// TFIDF scores documents by the cosine similarity between TF-IDF vectors.
+//
+// With a Slope below 1, the document norm is replaced by a pivoted norm,
+// (1 - Slope) * average norm + Slope * norm, so that documents shorter than average are
+// penalized and longer ones favored, instead of short documents dominating because of their tiny norms.
+type TFIDF struct {
+	Tf    TfWeighting
+	Idf   IdfWeighting
+	Slope float64
+}
+
+func (s TFIDF) TermWeight(term TermStats, collection CollectionStats, query bool) float64 {
+	idf := s.Idf.defaultIdf(collection.NumDocs)
+	if term.DocFreq > 0 {
+		idf = s.Idf.idf(collection.NumDocs, term.DocFreq)
+	}
+	return s.Tf.tf(term.Count, term.MaxCount, term.Length) * idf
+}
+
+// DocNorm returns the squared euclidean norm of the vector.
+func (s TFIDF) DocNorm(weights []float64) float64 {
+	var norm float64
+	for _, value := range weights {
+		norm += value * value
+	}
+	return norm
+}
+
+func (s TFIDF) Combine(dot float64, queryNorm float64, docNorm float64, collection CollectionStats) float64 {
+	if s.Slope >= 1 {
+		return dot / math.Sqrt(queryNorm*docNorm+1e-8)
+	}
+	pivoted := (1-s.Slope)*collection.AvgNorm + s.Slope*math.Sqrt(docNorm)
+	return dot / (math.Sqrt(queryNorm)*pivoted + 1e-8)
+}
+
+func (s TFIDF) QueryScale(queryNorm float64) float64 {
+	if queryNorm <= 0 {
+		return 0
+	}
+	return 1 / math.Sqrt(queryNorm)
+}
+
+func (s TFIDF) DocScale(docNorm float64, collection CollectionStats) float64 {
+	scale := math.Sqrt(docNorm)
+	if s.Slope < 1 {
+		scale = (1-s.Slope)*collection.AvgNorm + s.Slope*math.Sqrt(docNorm)
+	}
+	if scale <= 0 {
+		return 0
+	}
+	return 1 / scale
+}
+
+// MaxScore is 1 for the plain cosine similarity between non-negative vectors.
+// Pivoted scores are unbounded, since a document shorter than average can exceed 1.
+func (s TFIDF) MaxScore(query map[string]TermStats, collection CollectionStats) float64 {
+	if s.Slope < 1 {
+		return 0
+	}
+	return 1
+}
+
+const (
+	defaultK1 = 1.2
+	defaultB  = 0.75
+)
+
+// BM25 is the Okapi BM25 ranking function. K1 controls how quickly repeated terms saturate and
+// B how strongly scores are normalized by document length.
+type BM25 struct {
+	K1  float64
+	B   float64
+	Idf IdfWeighting
+}
+
+func (s BM25) idf(term TermStats, collection CollectionStats) float64 {
+	if term.DocFreq == 0 {
+		return s.Idf.defaultIdf(collection.NumDocs)
+	}
+	return s.Idf.idf(collection.NumDocs, term.DocFreq)
+}
+
+func (s BM25) TermWeight(term TermStats, collection CollectionStats, query bool) float64 {
+	if query {
+		return float64(term.Count)
+	}
+	tf := float64(term.Count)
+	lengthRatio := 1.0
+	if collection.AvgLength > 0 {
+		lengthRatio = float64(term.Length) / collection.AvgLength
+	}
+	return s.idf(term, collection) * tf * (s.K1 + 1) / (tf + s.K1*(1-s.B+s.B*lengthRatio))
+}
+
+// DocNorm is unused by BM25, length normalization is part of the term weight.
+func (s BM25) DocNorm(weights []float64) float64 {
+	return 1
+}
+
+func (s BM25) Combine(dot float64, queryNorm float64, docNorm float64, collection CollectionStats) float64 {
+	return dot
+}
+
+func (s BM25) QueryScale(queryNorm float64) float64 {
+	return 1
+}
+
+func (s BM25) DocScale(docNorm float64, collection CollectionStats) float64 {
+	return 1
+}
+
+// MaxScore is reached when every query term occurs in the document so often that its weight saturates.
+func (s BM25) MaxScore(query map[string]TermStats, collection CollectionStats) float64 {
+	var score float64
+	for _, term := range query {
+		score += float64(term.Count) * s.idf(term, collection) * (s.K1 + 1)
+	}
+	return score
+}
+
+func parseFloatParam(name string, s string, defaultValue float64) (float64, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s %q, must be a non-negative number", name, s)
+	}
+	return value, nil
+}
+
+// parseSimilarity builds the similarity selected by the similarity, tf, idf, k1 and b settings.
+func parseSimilarity(get func(string) string) (Similarity, error) {
+	idfDefault := "standard"
+	name := get("similarity")
+	if name == "bm25" {
+		idfDefault = "probabilistic"
+	}
+	idfName := get("idf")
+	if idfName == "" {
+		idfName = idfDefault
+	}
+	idf, err := parseIdfWeighting(idfName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "", "tfidf":
+		if get("k1") != "" || get("b") != "" {
+			return nil, fmt.Errorf("k1 and b are only valid for bm25 similarity")
+		}
+		tf, err := parseTfWeighting(get("tf"))
+		if err != nil {
+			return nil, err
+		}
+		slope, err := parseFloatParam("slope", get("slope"), 1)
+		if err != nil {
+			return nil, err
+		}
+		if slope > 1 {
+			return nil, fmt.Errorf("invalid slope %v, must be between 0 and 1", slope)
+		}
+		return TFIDF{Tf: tf, Idf: idf, Slope: slope}, nil
+	case "bm25":
+		if get("tf") != "" || get("slope") != "" {
+			return nil, fmt.Errorf("tf and slope are only valid for tfidf similarity")
+		}
+		k1, err := parseFloatParam("k1", get("k1"), defaultK1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := parseFloatParam("b", get("b"), defaultB)
+		if err != nil {
+			return nil, err
+		}
+		if b > 1 {
+			return nil, fmt.Errorf("invalid b %v, must be between 0 and 1", b)
+		}
+		return BM25{K1: k1, B: b, Idf: idf}, nil
+	}
+	return nil, fmt.Errorf("invalid similarity %q, must be either tfidf or bm25", name)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	"stellr/analysis"
+)
+
+type idfTest struct {
+	weighting IdfWeighting
+	nDocs     int
+	df        uint64
+	idf       float64
+}
+
+func TestIdfWeighting(t *testing.T) {
+	inputs := []idfTest{
+		{StandardIdf, 10, 10, 0},
+		{StandardIdf, 10, 1, math.Log(10)},
+		{SmoothIdf, 10, 10, 1},
+		{SmoothIdf, 10, 1, math.Log(11.0/2) + 1},
+		{ProbabilisticIdf, 10, 10, math.Log(1 + 0.5/10.5)},
+		{ProbabilisticIdf, 10, 1, math.Log(1 + 9.5/1.5)},
+	}
+	for _, input := range inputs {
+		idf := input.weighting.idf(input.nDocs, input.df)
+		if math.Abs(idf-input.idf) > 1e-9 {
+			t.Errorf("idf %v different from expected %v for %+v", idf, input.idf, input)
+		}
+	}
+
+	for _, weighting := range []IdfWeighting{StandardIdf, SmoothIdf, ProbabilisticIdf} {
+		if weighting.defaultIdf(10) <= 0 {
+			t.Errorf("default idf should be positive for weighting %d", weighting)
+		}
+	}
+}
+
+type tfTest struct {
+	weighting TfWeighting
+	tf        map[string]float64
+}
+
+func TestTfWeighting(t *testing.T) {
+	tokens := []string{"a", "b", "a", "a"}
+	inputs := []tfTest{
+		{ProportionalTf, map[string]float64{"a": 0.75, "b": 0.25}},
+		{RawTf, map[string]float64{"a": 3, "b": 1}},
+		{LogTf, map[string]float64{"a": 1 + math.Log(3), "b": 1}},
+		{BooleanTf, map[string]float64{"a": 1, "b": 1}},
+		{AugmentedTf, map[string]float64{"a": 1, "b": 0.5 + 0.5/3}},
+	}
+	for _, input := range inputs {
+		counts, maxCount := termCounts(tokens)
+		for token, expected := range input.tf {
+			tf := input.weighting.tf(counts[token], maxCount, len(tokens))
+			if math.Abs(tf-expected) > 1e-9 {
+				t.Errorf("tf %v different from expected %v for token %s and weighting %d", tf, expected, token, input.weighting)
+			}
+		}
+	}
+}
+
+type rankTest struct {
+	similarity Similarity
+	query      string
+	ids        []uint32
+}
+
+func TestSimilarityRanking(t *testing.T) {
+	corpus := []string{
+		"the cat sat on the mat",
+		"cat cat cat cat cat cat cat cat dog",
+		"a dog and a cat",
+		"dog",
+	}
+	inputs := []rankTest{
+		{TFIDF{Tf: ProportionalTf, Idf: StandardIdf, Slope: 1}, "dog", []uint32{3, 2, 1}},
+		{TFIDF{Tf: ProportionalTf, Idf: StandardIdf, Slope: 0.2}, "dog", []uint32{3, 2, 1}},
+		{BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf}, "dog", []uint32{3, 2, 1}},
+		{BM25{K1: defaultK1, B: defaultB, Idf: ProbabilisticIdf}, "cat", []uint32{1, 2, 0}},
+		{TFIDF{Tf: RawTf, Idf: SmoothIdf, Slope: 1}, "cat", []uint32{1, 2, 0}},
+	}
+	for _, input := range inputs {
+		analyzer, err := analysis.NewAnalyzer("english", false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTrieIndex(IndexOptions{analyzer: analyzer, similarity: input.similarity})
+		for i, text := range corpus {
+			tokens, _ := analyzer.Analyze(text)
+			builder.Add(tokens, uint32(i))
+		}
+		index := builder.Build()
+
+		res, err := index.Search(context.Background(), input.query, ExactSearch, Or, 0, searchLimits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranked, _ := index.Rank(context.Background(), res.tokens, res.DocIds())
+		ids := make([]uint32, len(ranked))
+		for i, r := range ranked {
+			ids[i] = r.id
+			if maxScore := index.MaxScore(res.tokens); maxScore > 0 && r.score > maxScore+1e-9 {
+				t.Errorf("score %v above max score %v", r.score, maxScore)
+			}
+		}
+		if !slices.Equal(ids, input.ids) {
+			t.Errorf("ranking %v different from expected %v for %+v and query %s", ids, input.ids, input.similarity, input.query)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// loadCorpus indexes a corpus file into the default index, as an upload of that file without
+// parameters would. Archives and NDJSON files are read the same way as uploads.
+func (a *App) loadCorpus(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	sources, cleanup, err := a.uploadSources(file, info.Size(), filepath.Base(path), a.limits.maxUploadSize)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	options, err := parseIndexOptions(&http.Request{Form: url.Values{}})
+	if err != nil {
+		return err
+	}
+	build, err := a.buildCorpus(sources, options, nil)
+	if err != nil {
+		return err
+	}
+	a.getOrCreateIndex(defaultIndexName).replace(build)
+	fmt.Printf("Loaded %d documents from %s\n", len(build.corpus), path)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	defaultTopTerms = 10
+	maxTopTerms     = 1000
+)
+
+type termDocFreq struct {
+	Term    string `json:"term"`
+	DocFreq uint64 `json:"df"`
+}
+
+// IndexStats are corpus-level statistics of a built index.
+type IndexStats struct {
+	Documents         int           `json:"documents"`
+	VocabularySize    int           `json:"vocabulary_size"`
+	TotalTokens       int           `json:"total_tokens"`
+	AvgDocumentLength float64       `json:"avg_document_length"`
+	TopTerms          []termDocFreq `json:"top_terms"`
+	// TokenLengthHistogram maps a length in characters to the number of vocabulary terms with that length.
+	TokenLengthHistogram map[int]int `json:"token_length_histogram"`
+	// Stopwords are the stop words found in the corpus, with stopwords set to corpus.
+	Stopwords []string `json:"stopwords,omitempty"`
+}
+
+// indexStatsCache computes the statistics of an index on first use. Built indexes never change,
+// so the result is valid for the lifetime of the index.
+type indexStatsCache struct {
+	once  sync.Once
+	stats IndexStats
+}
+
+// Stats returns corpus statistics with up to maxTopTerms most frequent terms, ordered by descending document frequency.
+func (t *trieSearchIndex) Stats() IndexStats {
+	t.statsCache.once.Do(func() {
+		stats := IndexStats{
+			Documents:            t.stats.NumDocs,
+			AvgDocumentLength:    t.stats.AvgLength,
+			TokenLengthHistogram: make(map[int]int),
+			Stopwords:            t.options.stopwords.words,
+		}
+		for _, doc := range t.docEntries {
+			stats.TotalTokens += doc.length
+		}
+
+		tokenSets := t.invIndex.Traversal()
+		terms := make([]termDocFreq, 0, len(tokenSets))
+		for _, tokenSet := range tokenSets {
+			terms = append(terms, termDocFreq{Term: tokenSet.token, DocFreq: tokenSet.set.GetCardinality()})
+			stats.TokenLengthHistogram[utf8.RuneCountInString(tokenSet.token)]++
+		}
+		stats.VocabularySize = len(terms)
+
+		sort.Slice(terms, func(i, j int) bool {
+			if terms[i].DocFreq != terms[j].DocFreq {
+				return terms[i].DocFreq > terms[j].DocFreq
+			}
+			return terms[i].Term < terms[j].Term
+		})
+		stats.TopTerms = terms[:min(len(terms), maxTopTerms)]
+
+		t.statsCache.stats = stats
+	})
+	return t.statsCache.stats
+}
+
+func (a *App) indexStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	top := defaultTopTerms
+	if s := r.URL.Query().Get("top"); s != "" {
+		var err error
+		top, err = strconv.Atoi(s)
+		if err != nil || top < 0 || top > maxTopTerms {
+			writeError(
+				w, http.StatusBadRequest, errInvalidParameter,
+				"invalid top "+strconv.Quote(s)+", must be an integer between 0 and "+strconv.Itoa(maxTopTerms),
+			)
+			return
+		}
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	searchIndex := idx.index
+	idx.lock.RUnlock()
+
+	if searchIndex == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+
+	stats := searchIndex.Stats()
+	stats.TopTerms = stats.TopTerms[:min(len(stats.TopTerms), top)]
+	writeResponse(w, r, stats)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// defaultMaxDF is the fraction of documents above which a term is a stop word of the corpus.
+const defaultMaxDF = 0.5
+
+// StopwordSource selects where the stop words removed from documents and queries come from.
+type StopwordSource int
+
+const (
+	LanguageStopwords StopwordSource = iota // the fixed list of the index language
+	CorpusStopwords                         // the terms in too many documents of the corpus
+	NoStopwords
+	// the fixed list of the index language, removed from queries only, so that queries can keep them
+	QueryStopwords
+)
+
+func parseStopwordSource(s string) (StopwordSource, error) {
+	switch s {
+	case "", "language":
+		return LanguageStopwords, nil
+	case "corpus":
+		return CorpusStopwords, nil
+	case "none":
+		return NoStopwords, nil
+	case "query":
+		return QueryStopwords, nil
+	}
+	return LanguageStopwords, fmt.Errorf("invalid stopwords %q, must be one of language, corpus, none or query", s)
+}
+
+type StopwordOptions struct {
+	source StopwordSource
+	maxDF  float64
+	words  []string // found in the corpus, with corpus stop words
+}
+
+// parseStopwordOptions reads the stopwords and max_df settings.
+func parseStopwordOptions(get func(string) string) (StopwordOptions, error) {
+	source, err := parseStopwordSource(get("stopwords"))
+	if err != nil {
+		return StopwordOptions{}, err
+	}
+	if source != CorpusStopwords && get("max_df") != "" {
+		return StopwordOptions{}, fmt.Errorf("max_df is only valid with stopwords set to corpus")
+	}
+	maxDF, err := parseFloatParam("max_df", get("max_df"), defaultMaxDF)
+	if err != nil {
+		return StopwordOptions{}, err
+	}
+	if maxDF == 0 || maxDF > 1 {
+		return StopwordOptions{}, fmt.Errorf("invalid max_df %v, must be greater than 0 and at most 1", maxDF)
+	}
+	return StopwordOptions{source: source, maxDF: maxDF}, nil
+}
+
+// corpusStopwords returns the tokens in more than a maxDF fraction of docs, sorted.
+func corpusStopwords(docs []analyzedDocument, maxDF float64) []string {
+	df := make(map[string]int)
+	lastDoc := make(map[string]int) // 1 + the index of the last document counted for a token
+	for i, doc := range docs {
+		for _, token := range doc.tokens {
+			if lastDoc[token] != i+1 {
+				lastDoc[token] = i + 1
+				df[token]++
+			}
+		}
+	}
+	words := make([]string, 0)
+	for token, count := range df {
+		if float64(count) > maxDF*float64(len(docs)) {
+			words = append(words, token)
+		}
+	}
+	slices.Sort(words)
+	return words
+}
+
+// parseQueryStopwords reads the stopwords parameter of a search, returning whether stop words are
+// kept in the query.
+func parseQueryStopwords(s string) (bool, error) {
+	switch s {
+	case "", "remove":
+		return false, nil
+	case "keep":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid stopwords %q, must be either keep or remove", s)
+}
+
+// searchKeepingStopwords is like the Search of the index for a query analyzed without removing
+// stop words.
+func (idx *namedIndex) searchKeepingStopwords(ctx context.Context, params searchParams) (*IndexResult, error) {
+	start := time.Now()
+	tokens, err := idx.options.queryAnalyzer().WithoutStopWords().Analyze(params.query)
+	if err != nil {
+		return nil, err
+	}
+	if profile := profileOf(ctx); profile != nil {
+		profile.Analysis = millisecondsSince(start)
+	}
+	return idx.index.SearchTokens(ctx, tokens, params.searchType, params.operator, params.distance, params.limits)
+}
+
+// removeCorpusStopwords finds the stop words of docs and removes them, from docs and from the
+// queries and documents analyzed with options later on. The analyzers of options are replaced by
+// copies, as they can be shared with other options, like those of an upload finalized again.
+func removeCorpusStopwords(docs []analyzedDocument, options *IndexOptions) {
+	words := corpusStopwords(docs, options.stopwords.maxDF)
+	if len(words) == 0 {
+		return
+	}
+	for i := range docs {
+		docs[i].tokens = slices.DeleteFunc(docs[i].tokens, func(token string) bool {
+			_, found := slices.BinarySearch(words, token)
+			return found
+		})
+	}
+	options.stopwords.words = words
+	options.analyzer = options.analyzer.Clone()
+	options.analyzer.AddStopWords(words)
+	if options.searchAnalyzer != nil {
+		options.searchAnalyzer = options.searchAnalyzer.Clone()
+		options.searchAnalyzer.AddStopWords(words)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 100
+	defaultTermsPage   = 1000
+	maxTermsPage       = 10000
+)
+
+// Suggest returns up to limit terms completing the last token of prefix, analyzed like a query,
+// with the most frequent terms first. Documents are not ranked, so this is cheap enough to run on
+// every keystroke of a search box.
+func (t *trieSearchIndex) Suggest(ctx context.Context, prefix string, limit int, limits searchLimits) ([]termDocFreq, error) {
+	tokens, err := t.options.queryAnalyzer().Analyze(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return []termDocFreq{}, nil
+	}
+	// earlier tokens are complete words, only the one being typed is completed
+	last := tokens[len(tokens)-1:]
+	if err := t.checkPrefixLength(last, limits); err != nil {
+		return nil, err
+	}
+
+	completions := t.invIndex.completions(ctx, last[0])
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	terms := make([]termDocFreq, len(completions))
+	for i, completion := range completions {
+		terms[i] = termDocFreq{Term: completion.token, DocFreq: completion.set.GetCardinality()}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].DocFreq != terms[j].DocFreq {
+			return terms[i].DocFreq > terms[j].DocFreq
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	return terms[:min(len(terms), limit)], nil
+}
+
+func (a *App) suggestTerms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	if prefix == "" {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, "missing prefix")
+		return
+	}
+	limit := defaultSuggestions
+	if s := query.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxSuggestions {
+			writeError(
+				w, http.StatusBadRequest, errInvalidParameter,
+				"invalid limit "+strconv.Quote(s)+", must be an integer between 1 and "+strconv.Itoa(maxSuggestions),
+			)
+			return
+		}
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	searchIndex := idx.index
+	idx.lock.RUnlock()
+
+	if searchIndex == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+
+	terms, err := searchIndex.Suggest(r.Context(), prefix, limit, a.searchLimits)
+	if r.Context().Err() != nil {
+		return
+	}
+	if errors.Is(err, errShortPrefix) {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	}
+	writeResponse(w, r, terms)
+}
+
+// Terms returns up to limit terms of the vocabulary greater than after, in lexicographic order, and
+// whether there are more.
+func (t *trieSearchIndex) Terms(after string, limit int) ([]termDocFreq, bool) {
+	tokenSets, more := t.invIndex.keysAfter(after, limit)
+	terms := make([]termDocFreq, len(tokenSets))
+	for i, tokenSet := range tokenSets {
+		terms[i] = termDocFreq{Term: tokenSet.token, DocFreq: tokenSet.set.GetCardinality()}
+	}
+	return terms, more
+}
+
+type termsResponse struct {
+	Terms []termDocFreq `json:"terms"`
+	Next  string        `json:"next,omitempty"` // after parameter of the next page, empty on the last page
+}
+
+// listTerms pages through the vocabulary of an index in lexicographic order.
+func (a *App) listTerms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultTermsPage
+	if s := query.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxTermsPage {
+			writeError(
+				w, http.StatusBadRequest, errInvalidParameter,
+				"invalid limit "+strconv.Quote(s)+", must be an integer between 1 and "+strconv.Itoa(maxTermsPage),
+			)
+			return
+		}
+	}
+
+	idx, ok := a.lookupIndex(w, r)
+	if !ok {
+		return
+	}
+
+	idx.lock.RLock()
+	searchIndex := idx.index
+	idx.lock.RUnlock()
+
+	if searchIndex == nil {
+		writeError(w, http.StatusConflict, errNoCorpus, "no corpus has been uploaded")
+		return
+	}
+
+	terms, more := searchIndex.Terms(query.Get("after"), limit)
+	response := termsResponse{Terms: terms}
+	if more {
+		response.Next = terms[len(terms)-1].Term
+	}
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func getTerms(t *testing.T, handler http.Handler, url string, v any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+func TestSuggestTerms(t *testing.T) {
+	handler := NewApp().Routes()
+	corpus := "organization report\norganization chart\norganism\norgan music\norange\n"
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", corpus))
+
+	var terms []termDocFreq
+	getTerms(t, handler, "/v1/indexes/default/terms/suggest?prefix=chart+Orga&limit=2", &terms)
+	expected := []termDocFreq{{Term: "organization", DocFreq: 2}, {Term: "organ", DocFreq: 1}}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("expected %v, got %v", expected, terms)
+	}
+	getTerms(t, handler, "/v1/indexes/default/terms/suggest?prefix=zebra", &terms)
+	if len(terms) != 0 {
+		t.Errorf("expected no suggestions, got %v", terms)
+	}
+
+	for _, url := range []string{"/v1/indexes/default/terms/suggest", "/v1/indexes/default/terms/suggest?prefix=or&limit=0"} {
+		if code := getTerms(t, handler, url, &terms); code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", url, code)
+		}
+	}
+}
+
+func TestListTerms(t *testing.T) {
+	handler := NewApp().Routes()
+	handler.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, "/v1/indexes/default/corpus", "red fox\nred bird\nblue whale\n"))
+
+	var pages [][]termDocFreq
+	url := "/v1/indexes/default/terms?limit=2"
+	for {
+		var page termsResponse
+		if code := getTerms(t, handler, url, &page); code != http.StatusOK {
+			t.Fatalf("listing terms failed with status %d", code)
+		}
+		pages = append(pages, page.Terms)
+		if page.Next == "" {
+			break
+		}
+		url = "/v1/indexes/default/terms?limit=2&after=" + page.Next
+	}
+	expected := [][]termDocFreq{
+		{{Term: "bird", DocFreq: 1}, {Term: "blue", DocFreq: 1}},
+		{{Term: "fox", DocFreq: 1}, {Term: "red", DocFreq: 2}},
+		{{Term: "whale", DocFreq: 1}},
+	}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected pages %v, got %v", expected, pages)
+	}
+}
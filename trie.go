@@ -1,8 +1,7 @@
 package main
 
 import (
-	"fmt"
-	"strings"
+	"sort"
 
 	"github.com/RoaringBitmap/roaring"
 )
@@ -13,73 +12,47 @@ type node struct {
 	children []*node
 }
 
+// edge is the label leading to a node, stored as a range of the labels of the trie.
 type edge struct {
-	id  int
-	len int
+	offset int
+	len    int
 }
 
 type PatriciaTrie struct {
-	root    *node
-	strings []string
+	root   *node
+	labels []byte // edge labels, concatenated; splitting an edge only changes the ranges
 }
 
 func NewPatriciaTrie() *PatriciaTrie {
-	return &PatriciaTrie{root: &node{}, strings: make([]string, 0)}
+	return &PatriciaTrie{root: &node{}}
 }
 
-func (n *node) isLeaf() bool {
-	return len(n.children) == 0
+func (t *PatriciaTrie) label(e *edge) []byte {
+	return t.labels[e.offset : e.offset+e.len]
 }
 
-func (t *PatriciaTrie) Print() {
-	fmt.Println("-> TRIE:")
-	fmt.Printf("%v\n", t.strings)
-	node := t.root
-	t.print(node, 0, make([]string, 0))
+// addEdge stores label and returns the edge leading to it.
+func (t *PatriciaTrie) addEdge(label string) *edge {
+	e := &edge{offset: len(t.labels), len: len(label)}
+	t.labels = append(t.labels, label...)
+	return e
 }
 
-func (t *PatriciaTrie) print(currentNode *node, length int, path []string) {
-	if currentNode == nil {
-		return
-	}
-
-	if currentNode.parent != nil {
-		l := length + currentNode.parent.len
-		edgeLabel := t.strings[currentNode.parent.id][length:l]
-		edgeLabel = strings.Replace(edgeLabel, string('\x00'), "$", 1)
-		path = append(path, fmt.Sprintf("[%d] %s", currentNode.parent.id, edgeLabel))
-		length += currentNode.parent.len
-	}
-
-	if currentNode.isLeaf() {
-		path := strings.Join(path, " -> ")
-		fmt.Printf("PATH: %s\n", path)
-		return
-	}
-
-	for _, childNode := range currentNode.children {
-		t.print(childNode, length, path)
-	}
-}
-
-func (t *PatriciaTrie) findChild(n *node, key string, elementsFound int) *node {
-	var l int
+func (t *PatriciaTrie) findChild(n *node, key string) *node {
 	for _, childNode := range n.children {
-		l = elementsFound + childNode.parent.len
-		edgeLabel := t.strings[childNode.parent.id][elementsFound:l]
+		edgeLabel := t.label(childNode.parent)
 
-		if strings.HasPrefix(key, edgeLabel) {
+		if len(key) >= len(edgeLabel) && key[:len(edgeLabel)] == string(edgeLabel) {
 			return childNode
 		}
 	}
 	return nil
 }
 
-func (t *PatriciaTrie) findPrefix(n *node, key string, elementsFound int) (*node, int) {
-	var overlap, l int
+func (t *PatriciaTrie) findPrefix(n *node, key string) (*node, int) {
+	var overlap int
 	for _, childNode := range n.children {
-		l = elementsFound + childNode.parent.len
-		edgeLabel := t.strings[childNode.parent.id][elementsFound:l]
+		edgeLabel := t.label(childNode.parent)
 
 		for ; overlap < len(key); overlap++ {
 			if key[overlap] != edgeLabel[overlap] {
@@ -111,9 +84,9 @@ func (t *PatriciaTrie) search(key string) (*node, int, int) {
 		}
 
 		nextNode = nil
-		nextNode = t.findChild(currentNode, key, elementsFound)
+		nextNode = t.findChild(currentNode, key)
 		if nextNode == nil {
-			currentNode, overlap = t.findPrefix(currentNode, key, elementsFound)
+			currentNode, overlap = t.findPrefix(currentNode, key)
 			elementsFound += overlap
 			return currentNode, elementsFound, overlap
 		}
@@ -125,33 +98,6 @@ func (t *PatriciaTrie) search(key string) (*node, int, int) {
 	return currentNode, elementsFound, 0
 }
 
-func (t *PatriciaTrie) fuzzySearch(node *node, key string, limit int, length int, matchedNodes []*node) []*node {
-	partialStr := ""
-	if node.parent != nil {
-		length += node.parent.len
-		partialStr = t.strings[node.parent.id][0:length]
-	}
-	l := min(len(key), length)
-	k := key[0:l]
-
-	distance := LevenshteinDistance(partialStr, k)
-	if distance <= limit {
-		for _, child := range node.children {
-			matchedNodes = t.fuzzySearch(child, key, limit, length, matchedNodes)
-		}
-	}
-
-	if node.isLeaf() {
-		if l < len(key) {
-			distance = LevenshteinDistance(partialStr, key)
-		}
-		if distance <= limit {
-			matchedNodes = append(matchedNodes, node)
-		}
-	}
-	return matchedNodes
-}
-
 func (t *PatriciaTrie) Insert(key string, set *roaring.Bitmap) {
 	key += string('\x00')
 	lenKey := len(key)
@@ -174,18 +120,13 @@ func (t *PatriciaTrie) Insert(key string, set *roaring.Bitmap) {
 }
 
 func (t *PatriciaTrie) insertRootChild(n *node, key string, set *roaring.Bitmap) {
-	t.strings = append(t.strings, key)
-	edge := &edge{id: len(t.strings) - 1, len: len(key)}
-	childNode := &node{parent: edge, value: set}
+	childNode := &node{parent: t.addEdge(key), value: set}
 	n.children = append(n.children, childNode)
 }
 
 func (t *PatriciaTrie) insertNode(n *node, key string, set *roaring.Bitmap, elementsFound int, overlap int) {
-	idx := n.parent.id
-	lenKey := len(key)
-
 	if overlap != 0 {
-		splitEdge := &edge{id: idx, len: n.parent.len - overlap}
+		splitEdge := &edge{offset: n.parent.offset + overlap, len: n.parent.len - overlap}
 		splitNode := &node{parent: splitEdge}
 		splitNode.children = n.children
 		splitNode.value = n.value
@@ -194,10 +135,7 @@ func (t *PatriciaTrie) insertNode(n *node, key string, set *roaring.Bitmap, elem
 		n.parent.len = overlap
 	}
 
-	t.strings = append(t.strings, key)
-	idx = len(t.strings) - 1
-	newEdge := &edge{id: idx, len: lenKey - elementsFound}
-	newNode := &node{parent: newEdge, value: set}
+	newNode := &node{parent: t.addEdge(key[elementsFound:]), value: set}
 	n.children = append(n.children, newNode)
 }
 
@@ -205,24 +143,49 @@ func (t *PatriciaTrie) Search(key string) *IndexResult {
 	key += string('\x00')
 	n, elementsFound, _ := t.search(key)
 	if elementsFound == len(key) {
-		label := t.strings[n.parent.id]
-		label = label[0 : len(label)-1]
-		return &IndexResult{set: n.value, tokens: []string{label}}
+		return &IndexResult{set: n.value, tokens: []string{key[:len(key)-1]}}
 	}
 	return nil
 }
 
-func (t *PatriciaTrie) FuzzySearch(key string, limit int) *IndexResult {
-	key += string('\x00')
-	nodes := t.fuzzySearch(t.root, key, limit, 0, make([]*node, 0))
-	res := &IndexResult{set: roaring.New(), tokens: make([]string, 0)}
-
-	var r *IndexResult
-	for _, n := range nodes {
-		label := t.strings[n.parent.id]
-		label = label[0 : len(label)-1]
-		r = &IndexResult{set: n.value, tokens: []string{label}}
-		res.CombineOr(r)
+// expansion is a key matching a prefix or fuzzy search.
+type expansion struct {
+	token    string
+	set      *roaring.Bitmap
+	distance int
+}
+
+// sortExpansions orders the keys matching a search from the most plausible to the least: the
+// closest first, then the most frequent, so that a rare term one typo away from the query does not
+// come before a common one.
+func sortExpansions(expansions []expansion) {
+	df := make(map[string]uint64, len(expansions))
+	for _, e := range expansions {
+		df[e.token] = e.set.GetCardinality()
+	}
+	sort.Slice(expansions, func(i, j int) bool {
+		a, b := expansions[i], expansions[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if df[a.token] != df[b.token] {
+			return df[a.token] > df[b.token]
+		}
+		return a.token < b.token
+	})
+}
+
+// combineExpansions returns the union of the keys matching a search, with their tokens in the order
+// of expansions, keeping only the first maxExpansions of them if there are more.
+func combineExpansions(expansions []expansion, maxExpansions int) *IndexResult {
+	res := &IndexResult{set: roaring.New(), tokens: make([]string, 0, len(expansions))}
+	if maxExpansions > 0 && len(expansions) > maxExpansions {
+		expansions = expansions[:maxExpansions]
+		res.truncated = true
+	}
+	for _, e := range expansions {
+		res.tokens = append(res.tokens, e.token)
+		res.set.Or(e.set)
 	}
 	return res
 }
@@ -230,6 +193,29 @@ func (t *PatriciaTrie) FuzzySearch(key string, limit int) *IndexResult {
 type IndexResult struct {
 	set    *roaring.Bitmap
 	tokens []string
+	// the tokens of the query, so that the tokens matched only as a prefix or fuzzy expansion of one
+	// can be told apart, nil if every token is a token of the query
+	exact     map[string]bool
+	truncated bool // whether some keys matching a prefix or fuzzy search were left out
+}
+
+// expansionMultipliers returns penalty for each token of the result that was only matched as the
+// prefix or fuzzy expansion of a query token, to weigh it in ranking. It returns nil if there are
+// no such tokens.
+func (r *IndexResult) expansionMultipliers(penalty float64) map[string]float64 {
+	if r.exact == nil || penalty == 1 {
+		return nil
+	}
+	var multipliers map[string]float64
+	for _, token := range r.tokens {
+		if !r.exact[token] {
+			if multipliers == nil {
+				multipliers = make(map[string]float64)
+			}
+			multipliers[token] = penalty
+		}
+	}
+	return multipliers
 }
 
 func (r *IndexResult) CombineOr(res *IndexResult) {
@@ -239,6 +225,7 @@ func (r *IndexResult) CombineOr(res *IndexResult) {
 		r.set.Or(res.set)
 	}
 	r.tokens = append(r.tokens, res.tokens...)
+	r.truncated = r.truncated || res.truncated
 }
 
 func (r *IndexResult) CombineAnd(res *IndexResult) {
@@ -248,6 +235,7 @@ func (r *IndexResult) CombineAnd(res *IndexResult) {
 		r.set.And(res.set)
 	}
 	r.tokens = append(r.tokens, res.tokens...)
+	r.truncated = r.truncated || res.truncated
 }
 
 func (r *IndexResult) DocIds() []uint32 {
@@ -258,54 +246,22 @@ func (r *IndexResult) DocIds() []uint32 {
 	return r.set.ToArray()
 }
 
-func (t *PatriciaTrie) mergeChildren(n *node, result *IndexResult) *IndexResult {
-	if n.isLeaf() {
-		label := t.strings[n.parent.id]
-		label = label[0 : len(label)-1]
-		result.tokens = append(result.tokens, label)
-		result.set.Or(n.value)
-		return result
-	}
-
-	for _, child := range n.children {
-		result = t.mergeChildren(child, result)
-	}
-	return result
-}
-
-func (t *PatriciaTrie) StartsWith(key string) *IndexResult {
-	n, elementsFound, _ := t.search(key)
-	if elementsFound == len(key) {
-		return t.mergeChildren(n, &IndexResult{set: roaring.New(), tokens: make([]string, 0)})
-	}
-	return nil
-}
-
 type tokenSet struct {
 	set   *roaring.Bitmap
 	token string
 }
 
 func (t *PatriciaTrie) Traversal() []tokenSet {
-	path := []tokenSet{}
-	processNode := func(node *node) {
-		if node.value == nil {
-			return
-		}
-		token := t.strings[node.parent.id]
-		token = token[:len(token)-1]
-		path = append(path, tokenSet{set: node.value, token: token})
-	}
-	walkIn(t.root, processNode)
-	return path
+	return t.traversal(t.root, nil, []tokenSet{})
 }
 
-func walkIn(curr *node, processNode func(*node)) {
-	if curr == nil {
-		return
+// traversal appends the keys under n in depth-first order, given the path from the root to n.
+func (t *PatriciaTrie) traversal(n *node, path []byte, tokenSets []tokenSet) []tokenSet {
+	if n.value != nil {
+		tokenSets = append(tokenSets, tokenSet{set: n.value, token: string(path[:len(path)-1])})
 	}
-	processNode(curr)
-	for _, n := range curr.children {
-		walkIn(n, processNode)
+	for _, child := range n.children {
+		tokenSets = t.traversal(child, append(path, t.label(child.parent)...), tokenSets)
 	}
+	return tokenSets
 }
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/RoaringBitmap/roaring"
@@ -106,7 +110,7 @@ func TestPatriciaTriePrefix(t *testing.T) {
 
 	var result *IndexResult
 	for _, prefixTest := range tests {
-		result = trie.StartsWith(prefixTest.word)
+		result = newFlatTrie(trie).StartsWith(context.Background(), prefixTest.word, 0)
 		if (result == nil || !prefixTest.prefix) && (result != nil || prefixTest.prefix) {
 			t.Errorf(
 				"trie prefix search failed for word %s. Expected %v got %v",
@@ -155,7 +159,7 @@ func TestPatriciaTrieFuzzySearch(t *testing.T) {
 		}
 		trie.Insert(insert.word, insert.set)
 
-		found = trie.FuzzySearch(insert.word, insert.distance)
+		found = newFlatTrie(trie).FuzzySearch(context.Background(), insert.word, insert.distance, 0)
 		if found == nil {
 			t.Errorf("word %s should be found in trie", insert.word)
 		}
@@ -164,3 +168,171 @@ func TestPatriciaTrieFuzzySearch(t *testing.T) {
 		}
 	}
 }
+
+func TestPatriciaTrieMaxExpansions(t *testing.T) {
+	trie := NewPatriciaTrie()
+	trie.Insert("cart", roaring.BitmapOf(1))
+	trie.Insert("card", roaring.BitmapOf(2, 3, 4))
+	trie.Insert("care", roaring.BitmapOf(5, 6))
+	trie.Insert("cat", roaring.BitmapOf(7))
+	flat := newFlatTrie(trie)
+	ctx := context.Background()
+
+	// prefix searches keep the most frequent terms
+	result := flat.StartsWith(ctx, "car", 2)
+	if !result.truncated || !result.set.Equals(roaring.BitmapOf(2, 3, 4, 5, 6)) {
+		t.Errorf("expected documents of card and care, got %v with truncated %v", result.set.ToArray(), result.truncated)
+	}
+	if result = flat.StartsWith(ctx, "car", 3); result.truncated || result.set.GetCardinality() != 6 {
+		t.Errorf("expected all documents of the 3 terms, got %v with truncated %v", result.set.ToArray(), result.truncated)
+	}
+
+	// fuzzy searches keep the closest terms, then the most frequent ones
+	result = flat.FuzzySearch(ctx, "cart", 1, 2)
+	if !result.truncated || !result.set.Equals(roaring.BitmapOf(1, 2, 3, 4)) {
+		t.Errorf("expected documents of cart and card, got %v with truncated %v", result.set.ToArray(), result.truncated)
+	}
+
+	// terms are ordered the same way without a limit
+	if result = flat.FuzzySearch(ctx, "cart", 1, 0); !slices.Equal(result.tokens, []string{"cart", "card", "care", "cat"}) {
+		t.Errorf("expected the closest and most frequent terms first, got %v", result.tokens)
+	}
+	if result = flat.StartsWith(ctx, "car", 0); !slices.Equal(result.tokens, []string{"card", "care", "cart"}) {
+		t.Errorf("expected the most frequent terms first, got %v", result.tokens)
+	}
+}
+
+func TestFlatTrie(t *testing.T) {
+	trie := NewPatriciaTrie()
+	words := []string{"orange", "organism", "apple", "ape", "or", "oregon", "ore", "horror", "oranges"}
+	for i, word := range words {
+		trie.Insert(word, roaring.BitmapOf(uint32(i)))
+	}
+	flat := newFlatTrie(trie)
+
+	// the flat trie keeps keys in lexicographic order
+	expected, traversal := trie.Traversal(), flat.Traversal()
+	sort.Slice(expected, func(i, j int) bool { return expected[i].token < expected[j].token })
+	if len(traversal) != len(expected) {
+		t.Fatalf("%d keys instead of %d", len(traversal), len(expected))
+	}
+	for i := range expected {
+		if traversal[i].token != expected[i].token || !traversal[i].set.Equals(expected[i].set) {
+			t.Errorf("key %d is %s instead of %s", i, traversal[i].token, expected[i].token)
+		}
+	}
+	for i, word := range append(words, "oran", "apples", "") {
+		found := flat.Search(word)
+		if (i < len(words)) != (found != nil) {
+			t.Errorf("unexpected search result for %q: %v", word, found)
+		}
+		if found != nil && !found.set.Equals(trie.Search(word).set) {
+			t.Errorf("wrong bitset returned for word %s", word)
+		}
+	}
+	if result := flat.StartsWith(context.Background(), "orx", 0); result != nil {
+		t.Errorf("expected no keys starting with orx, got %v", result.tokens)
+	}
+}
+
+func TestPatriciaTrieLabels(t *testing.T) {
+	trie := NewPatriciaTrie()
+	for i, word := range []string{"organ", "organs", "organism", "organs"} {
+		trie.Insert(word, roaring.BitmapOf(uint32(i)))
+	}
+	// only the new suffixes are stored: organ$, s$ and ism$
+	if string(trie.labels) != "organ\x00s\x00ism\x00" {
+		t.Errorf("unexpected labels %q", trie.labels)
+	}
+	if found := trie.Search("organs"); found == nil || !found.set.Equals(roaring.BitmapOf(1, 3)) {
+		t.Errorf("wrong result for organs: %v", found)
+	}
+}
+
+func TestExportDOT(t *testing.T) {
+	trie := NewPatriciaTrie()
+	trie.Insert("organ", roaring.BitmapOf(0, 1))
+	trie.Insert("organs", roaring.BitmapOf(1))
+	trie.Insert("art", roaring.BitmapOf(2))
+
+	var dot strings.Builder
+	if err := trie.ExportDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph trie {
+	node [shape=point];
+	n0 -> n1 [label="art$"];
+	n0 -> n2 [label="organ"];
+	n1 [shape=box, label="1"];
+	n2 -> n3 [label="$"];
+	n2 -> n4 [label="s$"];
+	n3 [shape=box, label="2"];
+	n4 [shape=box, label="1"];
+}
+`
+	if dot.String() != expected {
+		t.Errorf("expected DOT graph\n%s\ngot\n%s", expected, dot.String())
+	}
+}
+
+func TestFlatTrieKeysAfter(t *testing.T) {
+	trie := NewPatriciaTrie()
+	for i, word := range []string{"orange", "organism", "apple", "ape", "or", "oregon", "ore", "horror", "oranges"} {
+		trie.Insert(word, roaring.BitmapOf(uint32(i)))
+	}
+	flat := newFlatTrie(trie)
+
+	tests := []struct {
+		after    string
+		limit    int
+		expected []string
+		more     bool
+	}{
+		{"", 3, []string{"ape", "apple", "horror"}, true},
+		{"horror", 3, []string{"or", "orange", "oranges"}, true},
+		{"or", 2, []string{"orange", "oranges"}, true},
+		{"orb", 10, []string{"ore", "oregon", "organism"}, false},
+		{"organism", 10, []string{}, false},
+	}
+	for _, test := range tests {
+		tokenSets, more := flat.keysAfter(test.after, test.limit)
+		tokens := make([]string, len(tokenSets))
+		for i, tokenSet := range tokenSets {
+			tokens[i] = tokenSet.token
+		}
+		if !slices.Equal(tokens, test.expected) || more != test.more {
+			t.Errorf("keys after %q are %v with more %v, expected %v with more %v", test.after, tokens, more, test.expected, test.more)
+		}
+	}
+}
+
+func TestFlatTrieRange(t *testing.T) {
+	trie := NewPatriciaTrie()
+	for i, word := range []string{"2023-12-31", "2024-01-15", "2024-02-01", "2024-02-29", "2024-03-01", "2025"} {
+		trie.Insert(word, roaring.BitmapOf(uint32(i)))
+	}
+	flat := newFlatTrie(trie)
+
+	tests := []struct {
+		from, to string
+		expected []string
+		set      *roaring.Bitmap
+	}{
+		{"2024-01", "2024-03", []string{"2024-01-15", "2024-02-01", "2024-02-29"}, roaring.BitmapOf(1, 2, 3)},
+		{"2024-02-01", "2024-02-29", []string{"2024-02-01"}, roaring.BitmapOf(2)},
+		{"2024", "", []string{"2024-01-15", "2024-02-01", "2024-02-29", "2024-03-01", "2025"}, roaring.BitmapOf(1, 2, 3, 4, 5)},
+		{"", "2024", []string{"2023-12-31"}, roaring.BitmapOf(0)},
+	}
+	for _, test := range tests {
+		result := flat.Range(context.Background(), test.from, test.to, 0)
+		if result == nil || !slices.Equal(result.tokens, test.expected) || !result.set.Equals(test.set) {
+			t.Errorf("wrong range [%q, %q): %v", test.from, test.to, result)
+		}
+	}
+	if result := flat.Range(context.Background(), "2024-04", "2024-12", 0); result != nil {
+		t.Errorf("expected no keys in an empty range, got %v", result.tokens)
+	}
+	if result := flat.Range(context.Background(), "2024", "", 2); !slices.Equal(result.tokens, []string{"2024-01-15", "2024-02-01"}) || !result.truncated {
+		t.Errorf("expected the first 2 keys of the range, got %v", result.tokens)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const expirySweepInterval = time.Minute
+
+// expiration is the time a document expires at.
+type expiration struct {
+	id uint32
+	at time.Time
+}
+
+// newExpirations lists the documents of a corpus that expire, soonest first.
+func newExpirations(corpus []document) []expiration {
+	var expirations []expiration
+	for id, doc := range corpus {
+		if !doc.expires.IsZero() {
+			expirations = append(expirations, expiration{id: uint32(id), at: doc.expires})
+		}
+	}
+	sort.SliceStable(expirations, func(i, j int) bool { return expirations[i].at.Before(expirations[j].at) })
+	return expirations
+}
+
+// expired returns the documents that expired by now and have not been swept yet.
+func (idx *namedIndex) expired(now time.Time) []uint32 {
+	var ids []uint32
+	for _, e := range idx.expirations {
+		if e.at.After(now) {
+			break
+		}
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// sweepExpired deletes the expired documents of every index.
+func (a *App) sweepExpired(now time.Time) {
+	a.indexesLock.RLock()
+	indexes := make([]*namedIndex, 0, len(a.indexes))
+	for _, idx := range a.indexes {
+		indexes = append(indexes, idx)
+	}
+	a.indexesLock.RUnlock()
+
+	for _, idx := range indexes {
+		idx.lock.Lock()
+		if ids := idx.expired(now); len(ids) > 0 {
+			idx.expirations = idx.expirations[len(ids):]
+			idx.markDeleted(nil, ids...)
+		}
+		idx.lock.Unlock()
+	}
+}
+
+// sweepExpiredEvery deletes expired documents periodically. Searches exclude expired documents
+// between sweeps.
+func (a *App) sweepExpiredEvery(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		a.sweepExpired(now)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDocumentExpiry(t *testing.T) {
+	app := NewApp()
+	handler := app.Routes()
+	now := time.Now()
+	corpus := fmt.Sprintf(`{"text": "red fox", "expires": %q}
+{"text": "red bird", "expires": %q}
+{"text": "red whale"}
+`, now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, multiFileRequest(t, "/v1/indexes/default/corpus", map[string]string{"docs.jsonl": corpus}, []string{"docs.jsonl"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	ids := func() []uint32 {
+		var ids []uint32
+		for _, res := range searchResults(t, handler, "/v1/indexes/default/search?query=red") {
+			ids = append(ids, res.Id)
+		}
+		return ids
+	}
+	// expired documents are excluded before they are swept
+	if got := ids(); len(got) != 2 || got[0] == 0 || got[1] == 0 {
+		t.Errorf("expected documents 1 and 2, got %v", got)
+	}
+
+	app.sweepExpired(now.Add(2 * time.Hour))
+	idx, _ := app.getIndex(defaultIndexName)
+	idx.lock.RLock()
+	swept := len(idx.expirations) == 0 && idx.isDeleted(1)
+	idx.lock.RUnlock()
+	if !swept {
+		t.Errorf("expected expired documents to be deleted")
+	}
+}
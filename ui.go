@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed ui/index.html
+var demoPage []byte
+
+// demo serves a single-page search interface for the API.
+func demo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(demoPage)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxUploadChunks     = 1 << 16
+	uploadSessionTTL    = 24 * time.Hour
+	uploadSweepInterval = time.Hour
+)
+
+var (
+	errUploadFinalizing = errors.New("the upload is being finalized")
+	errUploadTooLarge   = errors.New("the chunks of the upload exceed its maximum size")
+)
+
+// uploadSession is a corpus uploaded in numbered chunks, so that an interrupted transfer can resume
+// by sending only the missing chunks. Chunks are stored in a temporary directory until the session
+// is finalized.
+type uploadSession struct {
+	id       string
+	index    string
+	filename string
+	options  IndexOptions
+	dir      string
+	chunks   map[int]int64 // chunk number -> size
+	updated  time.Time
+	// set once the session is finalized, after which chunks are rejected
+	finalizing bool
+	lock       sync.Mutex
+}
+
+type uploadStatus struct {
+	Id       string `json:"id"`
+	Filename string `json:"filename"`
+	Chunks   []int  `json:"chunks"`
+	Size     int64  `json:"size"`
+}
+
+type finalizeResponse struct {
+	Documents int `json:"documents"`
+}
+
+func (s *uploadSession) status() uploadStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status := uploadStatus{Id: s.id, Filename: s.filename, Chunks: make([]int, 0, len(s.chunks)), Size: s.size()}
+	for n := range s.chunks {
+		status.Chunks = append(status.Chunks, n)
+	}
+	sort.Ints(status.Chunks)
+	return status
+}
+
+func (s *uploadSession) chunkPath(n int) string {
+	return filepath.Join(s.dir, "chunk-"+strconv.Itoa(n))
+}
+
+// size returns the total size of the chunks received. It must be called with the session lock held.
+func (s *uploadSession) size() int64 {
+	var size int64
+	for _, chunkSize := range s.chunks {
+		size += chunkSize
+	}
+	return size
+}
+
+// writeChunk stores chunk n, replacing any previous upload of the same chunk. It returns
+// errUploadTooLarge if the chunks would add up to more than maxSize bytes, and errUploadFinalizing
+// once the session is being finalized.
+func (s *uploadSession) writeChunk(n int, r io.Reader, maxSize int64) error {
+	s.lock.Lock()
+	finalizing, available := s.finalizing, maxSize-s.size()+s.chunks[n]
+	s.lock.Unlock()
+	if finalizing {
+		return errUploadFinalizing
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "partial-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, io.LimitReader(r, available+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// other chunks may have been received or the session finalized meanwhile
+	if s.finalizing {
+		return errUploadFinalizing
+	}
+	if size > maxSize-s.size()+s.chunks[n] {
+		return errUploadTooLarge
+	}
+	// the chunk only becomes visible once it was fully received
+	if err = os.Rename(tmp.Name(), s.chunkPath(n)); err != nil {
+		return err
+	}
+	s.chunks[n] = size
+	s.updated = time.Now()
+	return nil
+}
+
+// assemble concatenates chunks 0 to n-1 in order into a single file.
+func (s *uploadSession) assemble(n int) (*os.File, int64, error) {
+	corpus, err := os.Create(filepath.Join(s.dir, "corpus"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var size int64
+	for i := 0; i < n; i++ {
+		chunk, err := os.Open(s.chunkPath(i))
+		if err != nil {
+			corpus.Close()
+			return nil, 0, err
+		}
+		written, err := io.Copy(corpus, chunk)
+		chunk.Close()
+		if err != nil {
+			corpus.Close()
+			return nil, 0, err
+		}
+		size += written
+	}
+
+	if _, err = corpus.Seek(0, io.SeekStart); err != nil {
+		corpus.Close()
+		return nil, 0, err
+	}
+	return corpus, size, nil
+}
+
+func newUploadId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// expireUploadsEvery discards abandoned upload sessions periodically, so that their chunks do not stay
+// on disk until the next session is created.
+func (a *App) expireUploadsEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		a.uploadsLock.Lock()
+		a.expireUploads()
+		a.uploadsLock.Unlock()
+	}
+}
+
+// expireUploads removes sessions that have not received a chunk for uploadSessionTTL.
+// It must be called with uploadsLock held.
+func (a *App) expireUploads() {
+	for id, s := range a.uploads {
+		s.lock.Lock()
+		expired := time.Since(s.updated) > uploadSessionTTL
+		s.lock.Unlock()
+		if expired {
+			os.RemoveAll(s.dir)
+			delete(a.uploads, id)
+		}
+	}
+}
+
+// lookupUpload writes an error response and returns false if the session in the path does not exist.
+func (a *App) lookupUpload(w http.ResponseWriter, r *http.Request) (*uploadSession, bool) {
+	id := r.PathValue("id")
+	a.uploadsLock.Lock()
+	s, ok := a.uploads[id]
+	a.uploadsLock.Unlock()
+	if !ok || s.index != indexName(r) {
+		writeError(w, http.StatusNotFound, errUploadNotFound, "upload "+id+" does not exist")
+		return nil, false
+	}
+	return s, true
+}
+
+func (a *App) createUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !validIndexName(w, r) {
+		return
+	}
+
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		filename = "corpus.txt"
+	}
+	indexOptions, err := parseIndexOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidParameter, err.Error())
+		return
+	}
+
+	id, err := newUploadId()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	}
+	dir, err := os.MkdirTemp("", "stellr-upload-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	}
+
+	s := &uploadSession{
+		id:       id,
+		index:    indexName(r),
+		filename: filename,
+		options:  indexOptions,
+		dir:      dir,
+		chunks:   make(map[int]int64),
+		updated:  time.Now(),
+	}
+	a.uploadsLock.Lock()
+	a.expireUploads()
+	a.uploads[id] = s
+	a.uploadsLock.Unlock()
+
+	w.Header().Set("Location", "/v1/indexes/"+s.index+"/uploads/"+id)
+	writeResponseStatus(w, r, http.StatusCreated, s.status())
+}
+
+// upload reports which chunks of a session were received, or aborts the session.
+func (a *App) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodGet+", "+http.MethodDelete)
+		return
+	}
+
+	s, ok := a.lookupUpload(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeResponse(w, r, s.status())
+		return
+	}
+
+	a.uploadsLock.Lock()
+	delete(a.uploads, s.id)
+	a.uploadsLock.Unlock()
+	os.RemoveAll(s.dir)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) uploadChunk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w, http.MethodPut)
+		return
+	}
+
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 0 || n >= maxUploadChunks {
+		writeError(
+			w, http.StatusBadRequest, errInvalidParameter,
+			fmt.Sprintf("invalid chunk number %q, must be an integer between 0 and %d", r.PathValue("n"), maxUploadChunks-1),
+		)
+		return
+	}
+
+	s, ok := a.lookupUpload(w, r)
+	if !ok {
+		return
+	}
+
+	a.limitBody(w, r)
+	err = s.writeChunk(n, r.Body, a.limits.maxChunkedSize)
+	if a.bodyTooLarge(w, err) {
+		return
+	}
+	if errors.Is(err, errUploadFinalizing) {
+		writeError(w, http.StatusConflict, errInvalidRequest, "upload "+s.id+" is being finalized")
+		return
+	}
+	if errors.Is(err, errUploadTooLarge) {
+		writeError(
+			w, http.StatusRequestEntityTooLarge, errPayloadTooLarge,
+			"the chunks of an upload must not exceed "+formatBytes(a.limits.maxChunkedSize)+" altogether",
+		)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errInternal, "error storing chunk: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalizeUpload joins the chunks of a session and indexes the result, replacing the index.
+func (a *App) finalizeUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	s, ok := a.lookupUpload(w, r)
+	if !ok {
+		return
+	}
+
+	status := s.status()
+	for i, n := range status.Chunks {
+		if n != i {
+			writeError(w, http.StatusBadRequest, errInvalidRequest, "missing chunk "+strconv.Itoa(i))
+			return
+		}
+	}
+	if len(status.Chunks) == 0 {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "no chunks have been uploaded")
+		return
+	}
+
+	// once finalizing starts the session cannot be resumed
+	a.uploadsLock.Lock()
+	if _, ok := a.uploads[s.id]; !ok {
+		a.uploadsLock.Unlock()
+		writeError(w, http.StatusNotFound, errUploadNotFound, "upload "+s.id+" does not exist")
+		return
+	}
+	delete(a.uploads, s.id)
+	a.uploadsLock.Unlock()
+	// chunks still being received are rejected rather than written to a removed directory
+	s.lock.Lock()
+	s.finalizing = true
+	s.lock.Unlock()
+	defer os.RemoveAll(s.dir)
+
+	corpus, size, err := s.assemble(len(status.Chunks))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errInternal, "error assembling chunks: "+err.Error())
+		return
+	}
+	defer corpus.Close()
+
+	if !a.reserveUpload(w, size) {
+		return
+	}
+	defer a.memory.release(size * uploadMemoryFactor)
+	if !a.meterIndexing(w, r, size) {
+		return
+	}
+
+	sources, cleanup, err := a.uploadSources(corpus, size, s.filename, a.limits.maxChunkedSize)
+	defer cleanup()
+	if err != nil {
+		writeIngestError(w, err)
+		return
+	}
+	decompressed, ok := a.reserveDecompressed(w, sources, size, a.limits.maxChunkedSize)
+	if !ok {
+		return
+	}
+	defer a.memory.release(decompressed * uploadMemoryFactor)
+	build, err := a.buildCorpus(sources, s.options, a.documentIDs(s.index))
+	if err != nil {
+		writeIngestError(w, err)
+		return
+	}
+	a.getOrCreateIndex(s.index).replace(build)
+	writeResponse(w, r, finalizeResponse{Documents: len(build.corpus)})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	handler := NewApp().Routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/books/uploads?similarity=bm25", nil))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	var status uploadStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	base := "/v1/indexes/books/uploads/" + status.Id
+
+	put := func(n string, body string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, base+"/chunks/"+n, strings.NewReader(body)))
+		return w.Code
+	}
+	finalize := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, base+"/finalize", nil))
+		return w
+	}
+
+	// chunks may arrive out of order and split lines
+	if code := put("1", "ale of two cities\nthe sea "); code != http.StatusNoContent {
+		t.Fatalf("chunk upload failed with status %d", code)
+	}
+	if w = finalize(); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "missing chunk 0") {
+		t.Errorf("expected missing chunk error, got %d: %s", w.Code, w.Body.String())
+	}
+	put("0", "the old man and the sea\na t")
+	put("2", "wolf\n")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, base, nil))
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Chunks) != 3 || status.Size != 58 {
+		t.Errorf("wrong upload status %+v", status)
+	}
+
+	var resp finalizeResponse
+	if w = finalize(); w.Code != http.StatusOK {
+		t.Fatalf("finalize failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Documents != 3 {
+		t.Errorf("expected 3 documents, got %d", resp.Documents)
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/books/search?query=sea"); len(ids) != 2 {
+		t.Errorf("wrong search results %v", ids)
+	}
+
+	if w = finalize(); w.Code != http.StatusNotFound {
+		t.Errorf("finalized upload should no longer exist, got status %d", w.Code)
+	}
+}
+
+func TestChunkedUploadOverMaxUploadSize(t *testing.T) {
+	app := NewApp()
+	app.limits.maxUploadSize = 16
+	app.limits.maxChunkedSize = 1000
+	handler := app.Routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/books/uploads", nil))
+	var status uploadStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	base := "/v1/indexes/books/uploads/" + status.Id
+	// each chunk is within the maximum upload size, but not the whole upload
+	for i, chunk := range []string{"the old man\n", "and the sea\n", "a tale of\n", "two cities\n"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, base+"/chunks/"+strconv.Itoa(i), strings.NewReader(chunk)))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("chunk upload failed with status %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, base+"/finalize", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("finalize failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if ids := searchIds(t, handler, "/v1/indexes/books/search?query=cities"); len(ids) != 1 {
+		t.Errorf("wrong search results %v", ids)
+	}
+}
+
+func TestChunkedUploadLimits(t *testing.T) {
+	app := NewApp()
+	app.limits.maxChunkedSize = 16
+	handler := app.Routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/indexes/books/uploads", nil))
+	var status uploadStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	base := "/v1/indexes/books/uploads/" + status.Id
+	put := func(n string, body string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, base+"/chunks/"+n, strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := put("0", "red fox\n"); code != http.StatusNoContent {
+		t.Fatalf("chunk upload failed with status %d", code)
+	}
+	if code := put("1", "blue whale\n"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected chunks over the total limit to be rejected, got status %d", code)
+	}
+	// a chunk sent again replaces the previous one, so only its new size counts
+	if code := put("0", "red fox red bird"); code != http.StatusNoContent {
+		t.Errorf("expected a replaced chunk within the limit to be accepted, got status %d", code)
+	}
+
+	app.uploadsLock.Lock()
+	s := app.uploads[status.Id]
+	app.uploadsLock.Unlock()
+	s.lock.Lock()
+	s.finalizing = true
+	s.lock.Unlock()
+	if code := put("1", "x"); code != http.StatusConflict {
+		t.Errorf("expected chunks of an upload being finalized to be rejected, got status %d", code)
+	}
+
+	// abandoned sessions are removed with their chunks
+	s.lock.Lock()
+	s.updated = time.Now().Add(-uploadSessionTTL - time.Minute)
+	s.lock.Unlock()
+	app.uploadsLock.Lock()
+	app.expireUploads()
+	app.uploadsLock.Unlock()
+	if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+		t.Errorf("expected the chunks of an expired upload to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// vocabulary interns the tokens of an index, so that each distinct token is stored once and the
+// weights and statistics of the index are keyed by a dense token id.
+type vocabulary struct {
+	ids    map[string]uint32
+	tokens []string
+}
+
+func newVocabulary() *vocabulary {
+	return &vocabulary{ids: make(map[string]uint32)}
+}
+
+// intern returns the id of token, adding it to the vocabulary if it is new.
+func (v *vocabulary) intern(token string) uint32 {
+	if id, ok := v.ids[token]; ok {
+		return id
+	}
+	// tokens can be slices of a larger text that should not be kept alive
+	token = strings.Clone(token)
+	id := uint32(len(v.tokens))
+	v.ids[token] = id
+	v.tokens = append(v.tokens, token)
+	return id
+}
+
+// id returns the id of token, or false if it is not in the vocabulary.
+func (v *vocabulary) id(token string) (uint32, bool) {
+	id, ok := v.ids[token]
+	return id, ok
+}
+
+// clone returns a copy of the vocabulary that new tokens can be added to.
+func (v *vocabulary) clone() *vocabulary {
+	return &vocabulary{ids: maps.Clone(v.ids), tokens: slices.Clone(v.tokens)}
+}
+
+func (v *vocabulary) token(id uint32) string {
+	return v.tokens[id]
+}
+
+func (v *vocabulary) size() int {
+	return len(v.tokens)
+}
+
+// translate returns the terms of a document counted with the ids of the from vocabulary, using the
+// ids of the to vocabulary instead.
+func (terms docTerms) translate(from, to *vocabulary) docTerms {
+	if terms.counts == nil {
+		return terms
+	}
+	counts := make(map[uint32]int, len(terms.counts))
+	for id, count := range terms.counts {
+		counts[to.intern(from.token(id))] = count
+	}
+	terms.counts = counts
+	return terms
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestVocabulary(t *testing.T) {
+	from, to := newVocabulary(), newVocabulary()
+	fox, dog := from.intern("fox"), from.intern("dog")
+	if from.intern("fox") != fox || from.size() != 2 {
+		t.Fatalf("fox was interned twice: %v", from.tokens)
+	}
+	to.intern("cat")
+
+	terms := docTerms{counts: map[uint32]int{fox: 2, dog: 1}, maxCount: 2, length: 3}.translate(from, to)
+	if id, ok := to.id("fox"); !ok || terms.counts[id] != 2 {
+		t.Errorf("fox should be counted twice with its new id, got %v", terms.counts)
+	}
+	if id, ok := to.id("dog"); !ok || terms.counts[id] != 1 {
+		t.Errorf("dog should be counted once with its new id, got %v", terms.counts)
+	}
+	if _, ok := to.id("cow"); ok {
+		t.Error("cow should not be in the vocabulary")
+	}
+}